@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+var auditSince, auditUntil, auditActor, auditAction, auditExport string
+var auditLimit int
+
+func init() {
+	rootCmd.AddCommand(auditCommand)
+
+	auditCommand.Flags().StringVar(&auditSince, "since", "", "Only show entries after this time (RFC3339)")
+	auditCommand.Flags().StringVar(&auditUntil, "until", "", "Only show entries before this time (RFC3339)")
+	auditCommand.Flags().StringVar(&auditActor, "actor", "", "Only show entries whose actor contains this")
+	auditCommand.Flags().StringVar(&auditAction, "action", "", "Only show entries whose action contains this, e.g. DELETE")
+	auditCommand.Flags().IntVar(&auditLimit, "limit", 100, "Only show the newest entries, 0 shows all")
+	auditCommand.Flags().StringVar(&auditExport, "export", "", "Write the entries as JSON lines to this file")
+}
+
+var auditCommand = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the audit log",
+	Long:  `Show who created, changed or deleted clients and hooks`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(getAudit())
+	},
+}
+
+func getAudit() string {
+	query := url.Values{}
+	if auditSince != "" {
+		query.Set("since", auditSince)
+	}
+	if auditUntil != "" {
+		query.Set("until", auditUntil)
+	}
+	if auditActor != "" {
+		query.Set("actor", auditActor)
+	}
+	if auditAction != "" {
+		query.Set("action", auditAction)
+	}
+	query.Set("limit", strconv.Itoa(auditLimit))
+
+	body := RunRequest(server.AuditPath+"?"+query.Encode(), "GET")
+	entries := make([]*server.AuditEntry, 0)
+	err := json.Unmarshal([]byte(body), &entries)
+	if err != nil {
+		log.Print(err.Error())
+		return "Could not read the server's answer"
+	}
+
+	if auditExport != "" {
+		return exportAudit(entries)
+	}
+
+	res := ""
+	for _, e := range entries {
+		res = res + fmt.Sprintf("%s %s \"%s\" %s from %s (%d)\n", e.Time.Format(time.RFC3339), e.Actor, e.Action, e.Target, e.SourceIP, e.Status)
+	}
+	return res
+}
+
+func exportAudit(entries []*server.AuditEntry) string {
+	f, err := os.OpenFile(auditExport, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Print(err.Error())
+		return err.Error()
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		err = enc.Encode(e)
+		if err != nil {
+			log.Print(err.Error())
+			return err.Error()
+		}
+	}
+
+	return fmt.Sprintf("Exported %d entries to %s\n", len(entries), auditExport)
+}
@@ -23,6 +23,16 @@ func init() {
 	clientCommand.AddCommand(delClientCommand)
 	clientCommand.AddCommand(listClientCommand)
 	clientCommand.AddCommand(regenClientCommand)
+	clientCommand.AddCommand(renameClientCommand)
+	clientCommand.AddCommand(disableClientCommand)
+	clientCommand.AddCommand(enableClientCommand)
+	clientCommand.AddCommand(setClientCommand)
+	clientCommand.AddCommand(sinksClientCommand)
+
+	setClientCommand.Flags().StringVar(&clientOwner, "owner", "", "Who is responsible for the client")
+	setClientCommand.Flags().StringVar(&clientContact, "contact", "", "How to reach the owner, e.g. a mail address")
+	sinksClientCommand.Flags().StringVar(&sinksFile, "file", "", "Read the sinks as JSON list from this file")
+	sinksClientCommand.Flags().BoolVar(&sinksClear, "clear", false, "Remove all sinks")
 }
 
 var clientCommand = &cobra.Command{
@@ -80,11 +90,62 @@ func listClients() string {
 
 	res := ""
 	for _, c := range clients {
-		res = res + fmt.Sprintf("Name: %s, Hooks: %d, LastAction: %s\n", c.Name, len(c.Hooks), c.LastAction.Format(time.RFC822))
+		res = res + formatClient(c)
 	}
 	return res
 }
 
+func formatClient(c *server.Client) string {
+	res := fmt.Sprintf("Name: %s, ID: %s, Hooks: %d, LastAction: %s", c.Name, c.ID, len(c.Hooks), c.LastAction.Format(time.RFC822))
+	if c.Disabled {
+		res = res + ", disabled"
+	}
+	if c.Owner != "" {
+		res = res + ", Owner: " + c.Owner
+	}
+	if c.Contact != "" {
+		res = res + ", Contact: " + c.Contact
+	}
+	return res + "\n"
+}
+
+var sinksClientCommand = &cobra.Command{
+	Use:   "sinks",
+	Short: "List or set where calls of a Client are passed on to besides its receivers",
+	Long: `List or set the sinks the calls of all hooks of a CaptainHook client and of the hooks shared with it are
+passed on to, e.g. message brokers. See "hook sinks" for the format`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Print("Not enough arguments (clientname)")
+			return
+		}
+		if sinksClear {
+			fmt.Print(RunRequest(server.ClientPath+"/"+args[0]+server.SinksPath, "DELETE"))
+			return
+		}
+		if sinksFile == "" {
+			body := RunRequest(server.ClientPath+"/"+args[0]+server.SinksPath, "GET")
+			sinks := make([]*server.Sink, 0)
+			if err := json.Unmarshal([]byte(body), &sinks); err != nil {
+				fmt.Print(body)
+				return
+			}
+			for _, s := range sinks {
+				fmt.Printf("%s %s\n", s.ID, s.Type)
+			}
+			return
+		}
+
+		sinks, err := readSinks(sinksFile)
+		if err != nil {
+			fmt.Print(err.Error())
+			return
+		}
+
+		fmt.Print(RunRequestWithBody(server.ClientPath+"/"+args[0]+server.SinksPath, "PUT", sinks))
+	},
+}
+
 var regenClientCommand = &cobra.Command{
 	Use:   "regen",
 	Short: "Generate a new secret for a Client",
@@ -97,3 +158,64 @@ var regenClientCommand = &cobra.Command{
 func regenSecret(clientname string) string {
 	return RunRequest(server.ClientPath+"/"+clientname, "PATCH")
 }
+
+var renameClientCommand = &cobra.Command{
+	Use:   "rename",
+	Short: "Rename a Client",
+	Long:  `Rename a CaptainHook client. Its secret and the URLs of its hooks stay the same`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Print("Not enough arguments (clientname, newname)")
+			return
+		}
+		fmt.Print(updateClient(args[0], server.ClientUpdate{Name: &args[1]}))
+	},
+}
+
+var disableClientCommand = &cobra.Command{
+	Use:   "disable",
+	Short: "Disable a Client",
+	Long:  `Disable a CaptainHook client. It can't connect or manage its hooks until it is enabled again, nothing is deleted`,
+	Run: func(cmd *cobra.Command, args []string) {
+		disabled := true
+		fmt.Print(updateClient(args[0], server.ClientUpdate{Disabled: &disabled}))
+	},
+}
+
+var enableClientCommand = &cobra.Command{
+	Use:   "enable",
+	Short: "Enable a Client",
+	Long:  `Enable a disabled CaptainHook client`,
+	Run: func(cmd *cobra.Command, args []string) {
+		disabled := false
+		fmt.Print(updateClient(args[0], server.ClientUpdate{Disabled: &disabled}))
+	},
+}
+
+var clientOwner, clientContact string
+
+var setClientCommand = &cobra.Command{
+	Use:   "set",
+	Short: "Set the owner and contact of a Client",
+	Long:  `Set who is responsible for a CaptainHook client and how to reach them. Only the given flags are changed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		update := server.ClientUpdate{}
+		if cmd.Flags().Changed("owner") {
+			update.Owner = &clientOwner
+		}
+		if cmd.Flags().Changed("contact") {
+			update.Contact = &clientContact
+		}
+		fmt.Print(updateClient(args[0], update))
+	},
+}
+
+func updateClient(clientname string, update server.ClientUpdate) string {
+	body := RunRequestWithBody(server.ClientPath+"/"+clientname, "PUT", update)
+	client := new(server.Client)
+	err := json.Unmarshal([]byte(body), client)
+	if err != nil || client.ID == "" {
+		return body
+	}
+	return formatClient(client)
+}
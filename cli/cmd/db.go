@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+var fsckFix bool
+
+func init() {
+	rootCmd.AddCommand(dbCommand)
+	dbCommand.AddCommand(fsckCommand)
+
+	fsckCommand.Flags().BoolVar(&fsckFix, "fix", false, "Remove the orphaned keys and incomplete hooks")
+}
+
+var dbCommand = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain the database",
+	Long:  `Maintain the CaptainHook database`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var fsckCommand = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the database",
+	Long:  `Find keys in the database that don't belong to any client or hook anymore, like keys of deleted hooks. Use --fix to remove them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(fsck())
+	},
+}
+
+func fsck() string {
+	path := server.FsckPath
+	if fsckFix {
+		path = path + "?fix=true"
+	}
+
+	body := RunRequest(path, "POST")
+	report := new(server.FsckReport)
+	err := json.Unmarshal([]byte(body), report)
+	if err != nil {
+		log.Print(err.Error())
+		return "Could not read the server's answer"
+	}
+
+	res := ""
+	for _, p := range report.Problems {
+		note := ""
+		if !p.Fixable {
+			note = " (kept)"
+		}
+		res = res + fmt.Sprintf("%s: %s%s\n", p.Key, p.Problem, note)
+	}
+
+	res = res + fmt.Sprintf("Checked %d keys, found %d problems", report.Keys, len(report.Problems))
+	if fsckFix {
+		res = res + fmt.Sprintf(", removed %d", report.Removed)
+	}
+	return res + "\n"
+}
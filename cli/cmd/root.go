@@ -7,6 +7,7 @@ It is licensed under the MIT License
 package cmd
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,10 +16,13 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/user"
 	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
 )
 
 //ApplicationName is the name of the application
@@ -70,6 +74,11 @@ func Execute() {
 
 // RunRequest runs a request to the server to given path with http method
 func RunRequest(path, method string) string {
+	return RunRequestWithBody(path, method, nil)
+}
+
+// RunRequestWithBody runs a request to the server to given path with http method and sends payload as JSON
+func RunRequestWithBody(path, method string, payload interface{}) string {
 	u, err := url.Parse(serverAddress + path)
 
 	if err != nil {
@@ -78,9 +87,26 @@ func RunRequest(path, method string) string {
 		return nerr.Error()
 	}
 
-	req := &http.Request{
-		Method: method,
-		URL:    u,
+	var reqBody bytes.Buffer
+	if payload != nil {
+		err = json.NewEncoder(&reqBody).Encode(payload)
+		if err != nil {
+			log.Print(err.Error())
+			return "Could not encode the request"
+		}
+	}
+
+	req, err := http.NewRequest(method, u.String(), &reqBody)
+	if err != nil {
+		log.Print(err.Error())
+		return err.Error()
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// tell the server who is running the cli for the audit log
+	if u, err := user.Current(); err == nil {
+		req.Header.Set(server.UserHeader, u.Username)
 	}
 
 	client := &http.Client{
@@ -0,0 +1,51 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+func init() {
+	rootCmd.AddCommand(statsCommand)
+}
+
+var statsCommand = &cobra.Command{
+	Use:   "stats",
+	Short: "Show webhook counters",
+	Long:  `Show how many webhook calls were accepted or rejected by the limits since the server started`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(getStats())
+	},
+}
+
+func getStats() string {
+	body := RunRequest(server.StatsPath, "GET")
+	stats := new(server.StatsResponse)
+	err := json.Unmarshal([]byte(body), &stats)
+	if err != nil {
+		log.Print(err.Error())
+		return "Could not read the server's answer"
+	}
+
+	res := "Global: " + formatStats(stats.Global)
+	for _, h := range stats.Hooks {
+		res = res + fmt.Sprintf("%s/%s: %s", h.Client, h.Identifier, formatStats(h.Stats))
+	}
+	return res
+}
+
+func formatStats(s server.Stats) string {
+	return fmt.Sprintf("Accepted: %d, TooLarge: %d, RateLimited: %d, TooManyInFlight: %d, FilterMatched: %d, Filtered: %d, InFlight: %d\n",
+		s.Accepted, s.TooLarge, s.RateLimited, s.TooManyInFlight, s.FilterMatched, s.Filtered, s.InFlight)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+var target server.Target
+var targetCAFile string
+
+func init() {
+	clientCommand.AddCommand(targetCommand)
+	targetCommand.AddCommand(addTargetCommand)
+	targetCommand.AddCommand(listTargetCommand)
+	targetCommand.AddCommand(delTargetCommand)
+
+	addTargetCommand.Flags().StringVar(&target.Format, "format", "", "raw or cloudevents, defaults to the output format of each hook")
+	addTargetCommand.Flags().IntVar(&target.Timeout, "timeout", 0, "Seconds a single attempt may take, defaults to 10")
+	addTargetCommand.Flags().IntVar(&target.Attempts, "attempts", 0, "How often a call is tried, defaults to 5")
+	addTargetCommand.Flags().IntVar(&target.Backoff, "backoff", 0, "Seconds before the first retry, doubled for every further one, defaults to 1. Pushes give up before waiting more than an hour in total")
+	addTargetCommand.Flags().BoolVar(&target.InsecureSkipVerify, "insecure", false, "Accept any certificate of the target")
+	addTargetCommand.Flags().StringVar(&targetCAFile, "ca", "", "PEM file with certificates the target is verified with")
+	addTargetCommand.Flags().StringVar(&target.Secret, "secret", "", "Secret the calls are signed with, generated if not set")
+}
+
+var targetCommand = &cobra.Command{
+	Use:   "target",
+	Short: "Manage the push targets of a Client",
+	Long: `Manage the URLs calls of a CaptainHook client are pushed to by HTTP, for clients that can't keep a websocket open.
+Every call is signed with the secret of the target in the X-CaptainHook-Signature header`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var addTargetCommand = &cobra.Command{
+	Use:   "add",
+	Short: "Push the calls of a Client to a URL",
+	Long:  `Push the calls of a CaptainHook client to a URL, with retries and exponential backoff. Prints the secret of the target`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Print("Not enough arguments (clientname, url)")
+			return
+		}
+
+		target.URL = args[1]
+		if targetCAFile != "" {
+			b, err := ioutil.ReadFile(targetCAFile)
+			if err != nil {
+				fmt.Print(err.Error())
+				return
+			}
+			target.CACert = string(b)
+		}
+
+		body := RunRequestWithBody(server.ClientPath+"/"+args[0]+server.TargetsPath, "POST", target)
+		added := new(server.Target)
+		if err := json.Unmarshal([]byte(body), added); err != nil || added.ID == "" {
+			fmt.Print(body)
+			return
+		}
+		fmt.Print(formatTarget(added) + fmt.Sprintf("  Secret: %s\n", added.Secret))
+	},
+}
+
+var listTargetCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List the push targets of a Client",
+	Long:  `List the URLs the calls of a CaptainHook client are pushed to`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Print("Not enough arguments (clientname)")
+			return
+		}
+
+		body := RunRequest(server.ClientPath+"/"+args[0]+server.TargetsPath, "GET")
+		targets := make([]*server.Target, 0)
+		if err := json.Unmarshal([]byte(body), &targets); err != nil {
+			fmt.Print(body)
+			return
+		}
+
+		res := ""
+		for _, t := range targets {
+			res = res + formatTarget(t)
+		}
+		fmt.Print(res)
+	},
+}
+
+var delTargetCommand = &cobra.Command{
+	Use:   "del",
+	Short: "Stop pushing the calls of a Client to a URL",
+	Long:  `Remove a push target of a CaptainHook client by its ID`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Print("Not enough arguments (clientname, target)")
+			return
+		}
+
+		fmt.Print(RunRequest(server.ClientPath+"/"+args[0]+server.TargetsPath+"/"+args[1], "DELETE"))
+	},
+}
+
+func formatTarget(t *server.Target) string {
+	format := t.Format
+	if format == "" {
+		format = "hook format"
+	}
+	return fmt.Sprintf("%s: %s (%s, %d attempts, %ds timeout)\n", t.ID, t.URL, format, t.Attempts, t.Timeout)
+}
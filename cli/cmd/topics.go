@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+var eventsDead bool
+
+func init() {
+	clientCommand.AddCommand(topicCommand)
+	topicCommand.AddCommand(listTopicCommand)
+	topicCommand.AddCommand(eventsTopicCommand)
+	topicCommand.AddCommand(redeliverTopicCommand)
+
+	eventsTopicCommand.Flags().BoolVar(&eventsDead, "dead", false, "Only list events a subscriber did not accept")
+}
+
+var topicCommand = &cobra.Command{
+	Use:   "topic",
+	Short: "Inspect the topics a Client publishes events to",
+	Long: `Inspect the topics a CaptainHook client publishes events to and their subscribers. Clients publish events and
+manage the subscribers themselves, every event is signed with the secret of the subscriber in the
+X-CaptainHook-Signature header`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var listTopicCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List the topics of a Client and their subscribers",
+	Long:  `List the topics of a CaptainHook client and the URLs their events are posted to`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Print("Not enough arguments (clientname)")
+			return
+		}
+
+		body := RunRequest(server.ClientPath+"/"+args[0]+server.TopicsPath, "GET")
+		topics := make([]*server.Topic, 0)
+		if err := json.Unmarshal([]byte(body), &topics); err != nil {
+			fmt.Print(body)
+			return
+		}
+
+		res := ""
+		for _, t := range topics {
+			res = res + t.Name + "\n"
+			for _, s := range t.Subscribers {
+				res = res + "  " + formatSubscriber(s)
+			}
+		}
+		fmt.Print(res)
+	},
+}
+
+var eventsTopicCommand = &cobra.Command{
+	Use:   "events",
+	Short: "List the recent events of a topic and their delivery",
+	Long:  `List the recent events a CaptainHook client published to a topic and whether each subscriber accepted them`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Print("Not enough arguments (clientname, topic)")
+			return
+		}
+
+		path := server.ClientPath + "/" + args[0] + server.TopicsPath + "/" + args[1] + server.EventsPath
+		if eventsDead {
+			path = server.ClientPath + "/" + args[0] + server.TopicsPath + "/" + args[1] + server.DeadLettersPath
+		}
+		body := RunRequest(path, "GET")
+		events := make([]*server.Event, 0)
+		if err := json.Unmarshal([]byte(body), &events); err != nil {
+			fmt.Print(body)
+			return
+		}
+
+		res := ""
+		for _, e := range events {
+			res = res + fmt.Sprintf("%s %s %d bytes\n", e.PublishedAt.Format("2006-01-02 15:04:05"), e.ID, len(e.Body))
+			for _, r := range e.Results {
+				res = res + fmt.Sprintf("  %s: %s after %d attempts", r.URL, r.Outcome, r.Attempts)
+				if r.Error != "" {
+					res = res + " (" + r.Error + ")"
+				}
+				res = res + "\n"
+			}
+		}
+		fmt.Print(res)
+	},
+}
+
+var redeliverTopicCommand = &cobra.Command{
+	Use:   "redeliver",
+	Short: "Post an event again to the subscribers that did not accept it",
+	Long:  `Post an event of a CaptainHook client again to the subscribers of its topic that did not accept it after all attempts`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			fmt.Print("Not enough arguments (clientname, topic, event)")
+			return
+		}
+
+		fmt.Print(RunRequest(server.ClientPath+"/"+args[0]+server.TopicsPath+"/"+args[1]+server.EventsPath+"/"+args[2]+server.RedeliverPath, "POST"))
+	},
+}
+
+func formatSubscriber(s *server.Subscriber) string {
+	res := fmt.Sprintf("%s: %s (%d attempts, %ds timeout)", s.ID, s.URL, s.Attempts, s.Timeout)
+	if s.Description != "" {
+		res = res + " " + s.Description
+	}
+	return res + "\n"
+}
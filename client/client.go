@@ -9,8 +9,12 @@ package captainhook
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/hmac"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -20,18 +24,25 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// Client contains all the information needed to connect to the CaptainHook server
+// Client contains all the information needed to connect to the CaptainHook server. Calls are received as raw requests on
+// the Receiver, or on Events if the server passes them on as CloudEvents
 type Client struct {
 	secret                       string
 	rootCAs                      *x509.CertPool
+	certificates                 []tls.Certificate
 	ws                           *websocket.Conn
+	transport                    string
+	cancel                       context.CancelFunc
+	format                       string
 	Receiver                     chan *http.Request
+	Events                       chan *server.CloudEvent
 	host, port, scheme, wsscheme string
 }
 
 // NewClient creates a new CaptainHook client. Use the secret you received from your server administrator. rootCAs can contain additional certifactes for SSL validation,
-// e.g. snakeoil/self-signed. Set to nil if you don't expect that
-func NewClient(secret string, rootCAs *x509.CertPool) (Client, error) {
+// e.g. snakeoil/self-signed. Set to nil if you don't expect that. If the server accepts client certificates, you can pass your certificates
+// to authenticate with them. The secret may be empty in that case
+func NewClient(secret string, rootCAs *x509.CertPool, certificates ...tls.Certificate) (Client, error) {
 
 	if rootCAs == nil {
 		var err error
@@ -42,35 +53,30 @@ func NewClient(secret string, rootCAs *x509.CertPool) (Client, error) {
 	}
 
 	client := Client{
-		secret:   secret,
-		rootCAs:  rootCAs,
-		Receiver: make(chan *http.Request),
+		secret:       secret,
+		rootCAs:      rootCAs,
+		certificates: certificates,
+		Receiver:     make(chan *http.Request),
+		Events:       make(chan *server.CloudEvent),
 	}
 
 	return client, nil
 }
 
 // Connect to the captainhook server. Provide the hostname and port of the server. If the server offers an SSL connection, you should set useSSL to true.
+// If the websocket handshake fails, e.g. because a proxy strips the upgrade headers, calls are received as server-sent events
+// or by long-polling instead, see Transport
 func (c *Client) Connect(host, port string, useSSL bool) (*http.Response, error) {
-	c.host = host
-	c.port = port
-	if useSSL {
-		c.scheme = "https"
-		c.wsscheme = "wss"
-	} else {
-		c.scheme = "http"
-		c.wsscheme = "ws"
-	}
+	c.SetServer(host, port, useSSL)
 
 	u := url.URL{Scheme: c.wsscheme, Host: c.host + ":" + c.port, Path: server.ConnectPath}
-
-	header := http.Header{
-		"Authorization": []string{"Bearer " + c.secret},
+	if c.format != "" {
+		u.RawQuery = url.Values{server.FormatQuery: []string{c.format}}.Encode()
 	}
 
-	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: c.rootCAs}}
+	dialer := &websocket.Dialer{TLSClientConfig: c.tlsConfig()}
 
-	ws, resp, err := dialer.Dial(u.String(), header)
+	ws, resp, err := dialer.Dial(u.String(), c.header())
 	c.ws = ws
 	if err != nil {
 		if resp != nil {
@@ -82,10 +88,20 @@ func (c *Client) Connect(host, port string, useSSL bool) (*http.Response, error)
 				}
 				return c.Connect(location.Hostname(), location.Port(), true)
 			}
+			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+				return nil, &server.ErrCouldNotConnect{Message: strconv.Itoa(resp.StatusCode)}
+			}
+		}
+
+		if c.fallback() == nil {
+			return nil, nil
+		}
+		if resp != nil {
 			return nil, &server.ErrCouldNotConnect{Message: strconv.Itoa(resp.StatusCode)}
 		}
 		return resp, err
 	}
+	c.transport = TransportWebsocket
 
 	go func() {
 		for {
@@ -93,33 +109,151 @@ func (c *Client) Connect(host, port string, useSSL bool) (*http.Response, error)
 			if err != nil {
 				return
 			}
-			r := bufio.NewReader(bytes.NewReader(message))
-			req, err := http.ReadRequest(r)
-			if err != nil {
+			if c.receive(message) != nil {
 				return
 			}
-			c.Receiver <- req
 		}
 	}()
 	return nil, nil
 }
 
+// receive passes a call on to the Receiver or, if it is a CloudEvent, to Events
+func (c *Client) receive(message []byte) error {
+	if isCloudEvent(message) {
+		event, err := DecodeCloudEvent(message)
+		if err != nil {
+			return err
+		}
+		c.Events <- event
+		return nil
+	}
+
+	r := bufio.NewReader(bytes.NewReader(message))
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return err
+	}
+	c.Receiver <- req
+	return nil
+}
+
+// SetFormat chooses the format the server passes calls on in, server.OutputRaw or server.OutputCloudEvents. Raw calls
+// are sent to the Receiver, CloudEvents to Events. Without it, every hook uses its own format. Call it before Connect
+func (c *Client) SetFormat(format string) {
+	c.format = format
+}
+
+// DecodeCloudEvent decodes a call the server passed on as CloudEvent. The body of the call is returned by the Payload
+// of the event
+func DecodeCloudEvent(message []byte) (*server.CloudEvent, error) {
+	event := new(server.CloudEvent)
+	err := json.Unmarshal(message, event)
+	if err != nil {
+		return nil, err
+	}
+
+	if event.SpecVersion != server.CloudEventsSpecVersion {
+		return nil, &ErrUnsupportedEvent{SpecVersion: event.SpecVersion}
+	}
+	return event, nil
+}
+
+// ErrUnsupportedEvent occurs if a CloudEvent follows a version of the specification this client doesn't know
+type ErrUnsupportedEvent struct {
+	SpecVersion string
+}
+
+func (e *ErrUnsupportedEvent) Error() string {
+	return "Unsupported CloudEvents version '" + e.SpecVersion + "'"
+}
+
+// isCloudEvent tells a CloudEvent from a raw request, which never starts with a JSON object
+func isCloudEvent(message []byte) bool {
+	return len(message) > 0 && message[0] == '{'
+}
+
+// VerifyPush checks the signature of a call the server pushed to a target and returns its body. secret is the secret of
+// the target. Calls signed longer than maxAge ago are rejected, 0 accepts calls of any age. The body of req can be read
+// again afterwards
+func VerifyPush(req *http.Request, secret string, maxAge time.Duration) ([]byte, error) {
+	timestamp := req.Header.Get(server.TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, &ErrInvalidSignature{Message: "missing or invalid timestamp"}
+	}
+	if maxAge > 0 && time.Since(time.Unix(unix, 0)) > maxAge {
+		return nil, &ErrInvalidSignature{Message: "signed too long ago"}
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := server.Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(req.Header.Get(server.SignatureHeader))) {
+		return nil, &ErrInvalidSignature{Message: "signature does not match"}
+	}
+	return body, nil
+}
+
+// ErrInvalidSignature occurs if a pushed call was not signed with the secret of the target
+type ErrInvalidSignature struct {
+	Message string
+}
+
+func (e *ErrInvalidSignature) Error() string {
+	return "Invalid signature: " + e.Message
+}
+
+// SetServer sets the server the client talks to without connecting, for clients that only publish events or manage their
+// hooks. Connect sets it as well
+func (c *Client) SetServer(host, port string, useSSL bool) {
+	c.host = host
+	c.port = port
+	if useSSL {
+		c.scheme = "https"
+		c.wsscheme = "wss"
+	} else {
+		c.scheme = "http"
+		c.wsscheme = "ws"
+	}
+}
+
 // AddHook will add a new Webhook to the server identified by identifier.
 func (c *Client) AddHook(identifier string) error {
+	return c.AddHookWithOptions(identifier, server.HookOptions{})
+}
+
+// AddHookWithOptions will add a new Webhook to the server identified by identifier. opts describe what the hook is used for
+// and when it expires. When an expired hook is removed, the Receiver gets a request with the server.EventHeader set
+func (c *Client) AddHookWithOptions(identifier string, opts server.HookOptions) error {
 	u := url.URL{Scheme: c.scheme, Host: c.host + ":" + c.port, Path: server.HookPath + "/" + identifier}
 
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	header := c.header()
+	header.Set("Content-Type", "application/json")
 	req := &http.Request{
-		Method: "PUT",
-		URL:    &u,
-		Header: http.Header{
-			"Authorization": []string{"Bearer " + c.secret},
-		},
+		Method:        "PUT",
+		URL:           &u,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
 	}
 
 	client := &http.Client{
 		Timeout: time.Second * 10,
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{RootCAs: c.rootCAs},
+			TLSClientConfig: c.tlsConfig(),
 		},
 	}
 
@@ -142,15 +276,13 @@ func (c *Client) RemoveHook(identifier string) error {
 	req := &http.Request{
 		Method: "DELETE",
 		URL:    &u,
-		Header: http.Header{
-			"Authorization": []string{"Bearer " + c.secret},
-		},
+		Header: c.header(),
 	}
 
 	client := &http.Client{
 		Timeout: time.Second * 10,
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{RootCAs: c.rootCAs},
+			TLSClientConfig: c.tlsConfig(),
 		},
 	}
 
@@ -166,8 +298,30 @@ func (c *Client) RemoveHook(identifier string) error {
 	return nil
 }
 
-// Disconnect disconnects the websocket from the server
+// Disconnect disconnects the websocket, the event stream or the polling from the server
 func (c *Client) Disconnect() error {
+	if c.transport != TransportWebsocket {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		return nil
+	}
 	err := c.ws.Close()
 	return err
 }
+
+func (c *Client) tlsConfig() *tls.Config {
+	return &tls.Config{
+		RootCAs:      c.rootCAs,
+		Certificates: c.certificates,
+	}
+}
+
+// header returns the headers every request to the server needs. Clients using only a certificate do not send a secret
+func (c *Client) header() http.Header {
+	header := http.Header{}
+	if c.secret != "" {
+		header.Set("Authorization", "Bearer "+c.secret)
+	}
+	return header
+}
@@ -7,7 +7,19 @@ It is licensed under the MIT License
 package captainhook
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/captainhookpb"
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
 )
 
 func TestClientConnect(t *testing.T) {
@@ -107,3 +119,169 @@ func TestClientRemoveHook(t *testing.T) {
 		}
 	}
 }
+
+func TestNewClientWithCertificate(t *testing.T) {
+	tables := []struct {
+		secret     string
+		certs      []tls.Certificate
+		wantHeader bool
+	}{
+		{"test:abc", nil, true},
+		{"test:abc", []tls.Certificate{{}}, true},
+		{"", []tls.Certificate{{}}, false},
+	}
+
+	for _, table := range tables {
+		cli, err := NewClient(table.secret, nil, table.certs...)
+		if err != nil {
+			t.Errorf("Error creating client %s", err.Error())
+		}
+
+		if len(cli.tlsConfig().Certificates) != len(table.certs) {
+			t.Errorf("Expected %d certificates, got %d", len(table.certs), len(cli.tlsConfig().Certificates))
+		}
+
+		if (cli.header().Get("Authorization") != "") != table.wantHeader {
+			t.Errorf("Authorization header for secret '%s' is '%s'", table.secret, cli.header().Get("Authorization"))
+		}
+	}
+}
+
+func TestDecodeCloudEvent(t *testing.T) {
+	tables := []struct {
+		message string
+		payload string
+		valid   bool
+	}{
+		{`{"specversion":"1.0","id":"a","source":"http://localhost/h/x","type":"push","datacontenttype":"application/json","data":{"ref":"main"}}`, `{"ref":"main"}`, true},
+		{`{"specversion":"1.0","id":"b","source":"http://localhost/h/x","type":"push","data_base64":"YT0x"}`, "a=1", true},
+		{`{"specversion":"0.3","id":"c","source":"http://localhost/h/x","type":"push"}`, "", false},
+		{`POST /h/x HTTP/1.1`, "", false},
+	}
+
+	for _, table := range tables {
+		event, err := DecodeCloudEvent([]byte(table.message))
+		if (err == nil) != table.valid {
+			t.Errorf("Decoding %s: expected valid %t, got error %v", table.message, table.valid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		payload, err := event.Payload()
+		if err != nil {
+			t.Errorf("Error reading payload: %s", err.Error())
+		}
+		if string(payload) != table.payload {
+			t.Errorf("Payload of %s was %s, expected %s", event.ID, payload, table.payload)
+		}
+	}
+}
+
+func TestVerifyPush(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	tables := []struct {
+		secret    string
+		timestamp string
+		signature string
+		valid     bool
+	}{
+		{"abc", now, server.Sign("abc", now, []byte("payload")), true},
+		{"abc", now, server.Sign("other", now, []byte("payload")), false},
+		{"abc", old, server.Sign("abc", old, []byte("payload")), false},
+		{"abc", now, "", false},
+		{"abc", "", server.Sign("abc", "", []byte("payload")), false},
+	}
+
+	for _, table := range tables {
+		req := httptest.NewRequest("POST", "/push", strings.NewReader("payload"))
+		req.Header.Set(server.TimestampHeader, table.timestamp)
+		req.Header.Set(server.SignatureHeader, table.signature)
+
+		body, err := VerifyPush(req, table.secret, time.Minute)
+		if (err == nil) != table.valid {
+			t.Errorf("Verifying %s at %s: expected valid %t, got error %v", table.signature, table.timestamp, table.valid, err)
+			continue
+		}
+		if err == nil && string(body) != "payload" {
+			t.Errorf("Body was %s, expected payload", body)
+		}
+	}
+}
+
+func TestCallRequest(t *testing.T) {
+	call := &captainhookpb.Call{
+		Delivery: "d",
+		Method:   "POST",
+		Path:     "/h/x",
+		Query:    "a=1&b=2",
+		Headers: []*captainhookpb.Header{
+			{Name: "Content-Type", Values: []string{"application/json"}},
+			{Name: "X-Github-Event", Values: []string{"push"}},
+		},
+		Body: []byte(`{"ref":"main"}`),
+	}
+
+	req, err := CallRequest(call)
+	if err != nil {
+		t.Fatalf("Error converting call: %s", err.Error())
+	}
+	if req.Method != "POST" || req.URL.Path != "/h/x" || req.URL.Query().Get("b") != "2" {
+		t.Errorf("Request was %s %s, expected POST /h/x?a=1&b=2", req.Method, req.URL.String())
+	}
+	if req.Header.Get("X-GitHub-Event") != "push" {
+		t.Errorf("Event header was %s, expected push", req.Header.Get("X-GitHub-Event"))
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Errorf("Error reading body: %s", err.Error())
+	}
+	if string(body) != string(call.Body) {
+		t.Errorf("Body was %s, expected %s", body, call.Body)
+	}
+}
+
+func TestClientPublish(t *testing.T) {
+	tables := []struct {
+		status int
+		valid  bool
+	}{
+		{http.StatusAccepted, true},
+		{http.StatusBadRequest, false},
+		{http.StatusForbidden, false},
+	}
+
+	for _, table := range tables {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != server.TopicPath+"/orders"+server.EventsPath {
+				t.Errorf("Event was published to %s", r.URL.Path)
+			}
+			if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("Unexpected headers %v", r.Header)
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			w.WriteHeader(table.status)
+			json.NewEncoder(w).Encode(server.Event{ID: "e1", Topic: "orders", Body: body})
+		}))
+
+		u, _ := url.Parse(srv.URL)
+		cli, err := NewClient("secret", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cli.SetServer(u.Hostname(), u.Port(), false)
+
+		event, err := cli.Publish("orders", "application/json", []byte(`{"id":1}`))
+		srv.Close()
+		if (err == nil) != table.valid {
+			t.Errorf("Publishing with status %d: expected valid %t, got error %v", table.status, table.valid, err)
+			continue
+		}
+		if err == nil && (event.ID != "e1" || string(event.Body) != `{"id":1}`) {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+}
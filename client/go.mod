@@ -5,4 +5,7 @@ go 1.16
 require (
 	code.cerinuts.io/cerinuts/captainhook/server v0.0.0-20210722191400-35bdac104993
 	github.com/gorilla/websocket v1.4.2
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
+
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package captainhook
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/captainhookpb"
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCClient receives calls and manages hooks over the gRPC API of the server, a typed alternative to the websocket
+type GRPCClient struct {
+	conn *grpc.ClientConn
+	api  captainhookpb.CaptainHookClient
+}
+
+// bearer sends the secret of the client with every call
+type bearer struct {
+	secret string
+	secure bool
+}
+
+func (b *bearer) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.secret}, nil
+}
+
+func (b *bearer) RequireTransportSecurity() bool {
+	return b.secure
+}
+
+// DialGRPC connects to the gRPC API of the server, which listens on its own port. It authenticates with the secret or
+// the certificates of the client. Close the GRPCClient when done
+func (c *Client) DialGRPC(host, port string, useSSL bool) (*GRPCClient, error) {
+	opts := make([]grpc.DialOption, 0)
+	if useSSL {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(c.tlsConfig())))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	if c.secret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&bearer{secret: c.secret, secure: useSSL}))
+	}
+
+	conn, err := grpc.Dial(host+":"+port, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClient{conn: conn, api: captainhookpb.NewCaptainHookClient(conn)}, nil
+}
+
+// Receive passes the calls of all hooks to handle until ctx is done or the stream breaks. A call is acknowledged once
+// handle returns nil. Calls handle fails for, or that arrive while the stream breaks, are delivered again on the next one
+func (g *GRPCClient) Receive(ctx context.Context, handle func(*captainhookpb.Call) error) error {
+	stream, err := g.api.Receive(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		call, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if handle(call) != nil || call.Delivery == "" {
+			continue
+		}
+		err = stream.Send(&captainhookpb.Ack{Delivery: call.Delivery})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Hooks returns the hooks of the client matching the filter. The client of the filter is ignored
+func (g *GRPCClient) Hooks(ctx context.Context, filter server.HookFilter) ([]*captainhookpb.Hook, error) {
+	resp, err := g.api.ListHooks(ctx, &captainhookpb.ListHooksRequest{
+		Provider: filter.Provider,
+		Labels:   filter.Labels,
+		Search:   filter.Search,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Hooks, nil
+}
+
+// Hook returns a hook by identifier, slug or alias
+func (g *GRPCClient) Hook(ctx context.Context, identifier string) (*captainhookpb.Hook, error) {
+	return g.api.GetHook(ctx, &captainhookpb.GetHookRequest{Identifier: identifier})
+}
+
+// AddHook creates a new hook identified by identifier. opts describe what the hook is used for and when it expires
+func (g *GRPCClient) AddHook(ctx context.Context, identifier string, opts server.HookOptions) (*captainhookpb.Hook, error) {
+	req := &captainhookpb.CreateHookRequest{
+		Identifier: identifier,
+		Labels:     opts.Labels,
+		Ttl:        opts.TTL,
+		MaxCalls:   int32(opts.MaxCalls),
+	}
+	if opts.Description != nil {
+		req.Description = *opts.Description
+	}
+	if opts.Provider != nil {
+		req.Provider = *opts.Provider
+	}
+	if opts.ExpiresAt != nil {
+		req.ExpiresAt = timestamppb.New(*opts.ExpiresAt)
+	}
+	return g.api.CreateHook(ctx, req)
+}
+
+// RemoveHook deletes the hook identified by identifier
+func (g *GRPCClient) RemoveHook(ctx context.Context, identifier string) error {
+	_, err := g.api.DeleteHook(ctx, &captainhookpb.DeleteHookRequest{Identifier: identifier})
+	return err
+}
+
+// Close ends the connection to the server
+func (g *GRPCClient) Close() error {
+	return g.conn.Close()
+}
+
+// CallRequest converts a call received over gRPC into the request the hook was called with, as the Receiver gets it
+func CallRequest(call *captainhookpb.Call) (*http.Request, error) {
+	u := &url.URL{Path: call.Path, RawQuery: call.Query}
+	req, err := http.NewRequest(call.Method, u.String(), bytes.NewReader(call.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range call.Headers {
+		req.Header[h.Name] = h.Values
+	}
+	req.RequestURI = u.RequestURI()
+	return req, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package captainhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+const (
+	// TransportWebsocket receives calls over a websocket
+	TransportWebsocket = "websocket"
+	// TransportSSE receives calls as server-sent events
+	TransportSSE = "sse"
+	// TransportPoll receives calls by long-polling
+	TransportPoll = "poll"
+)
+
+const (
+	pollWait = 30 * time.Second
+	// pollRetry is the time to wait before polling again after a failed poll
+	pollRetry = 5 * time.Second
+	// maxEventSize is the largest server-sent event that is read, calls are limited by the server anyway
+	maxEventSize = 64 << 20
+)
+
+// Transport returns how calls are received after Connect, TransportWebsocket, TransportSSE or TransportPoll
+func (c *Client) Transport() string {
+	return c.transport
+}
+
+// fallback receives calls as server-sent events or, if the stream can't be opened, by long-polling
+func (c *Client) fallback() error {
+	err := c.connectStream()
+	if err == nil {
+		return nil
+	}
+	return c.connectPoll()
+}
+
+func (c *Client) connectStream() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(server.StreamPath, nil).String(), nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	req.Header = c.header()
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient(0).Do(req)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		cancel()
+		return &server.ErrCouldNotConnect{Message: strconv.Itoa(resp.StatusCode)}
+	}
+
+	c.cancel = cancel
+	c.transport = TransportSSE
+	go c.readStream(resp.Body)
+	return nil
+}
+
+// readStream passes the calls of an event stream on until it is closed
+func (c *Client) readStream(body io.ReadCloser) {
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	event, data := "", ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data != "" && c.receiveEvent(event, data) != nil {
+				return
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = data + strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+}
+
+func (c *Client) receiveEvent(event, data string) error {
+	if event == server.SSEEventCloudEvent {
+		return c.receive([]byte(data))
+	}
+
+	message, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	return c.receive(message)
+}
+
+func (c *Client) connectPoll() error {
+	// the first poll doesn't wait, it tells whether polling works at all
+	poll, err := c.poll(context.Background(), 0, "")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.transport = TransportPoll
+
+	go func() {
+		for {
+			for _, m := range poll.Messages {
+				if c.receive(m) != nil {
+					return
+				}
+			}
+
+			// the next poll acknowledges the calls received
+			ack := poll.Cursor
+			poll, err = c.poll(ctx, pollWait, ack)
+			for err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(pollRetry):
+				}
+				poll, err = c.poll(ctx, pollWait, ack)
+			}
+		}
+	}()
+	return nil
+}
+
+// poll waits up to wait for calls. ack is the cursor of the previous poll
+func (c *Client) poll(ctx context.Context, wait time.Duration, ack string) (*server.Poll, error) {
+	query := url.Values{server.WaitQuery: []string{wait.String()}}
+	if ack != "" {
+		query.Set(server.AckQuery, ack)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(server.PollPath, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+
+	// the server answers after wait at the latest
+	resp, err := c.httpClient(wait + 10*time.Second).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &server.ErrCouldNotConnect{Message: strconv.Itoa(resp.StatusCode)}
+	}
+
+	poll := new(server.Poll)
+	err = json.NewDecoder(resp.Body).Decode(poll)
+	if err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+// url returns the URL of path on the server with the given query and the chosen format
+func (c *Client) url(path string, query url.Values) *url.URL {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.format != "" {
+		query.Set(server.FormatQuery, c.format)
+	}
+	return &url.URL{Scheme: c.scheme, Host: c.host + ":" + c.port, Path: path, RawQuery: query.Encode()}
+}
+
+// httpClient returns a client for requests to the server. A timeout of 0 never times out, for streams
+func (c *Client) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: c.tlsConfig(),
+		},
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package captainhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+// Publish posts an event to the subscribers of a topic. The server answers as soon as the event is stored, it is
+// delivered in the background with retries. Subscribers check the events with VerifyPush and their secret
+func (c *Client) Publish(topic, contentType string, body []byte) (*server.Event, error) {
+	event := new(server.Event)
+	err := c.request(http.MethodPost, server.TopicPath+"/"+topic+server.EventsPath, contentType, bytes.NewReader(body), http.StatusAccepted, event)
+	return event, err
+}
+
+// AddSubscriber subscribes a URL to a topic. The returned subscriber contains the secret its events are signed with
+func (c *Client) AddSubscriber(topic string, subscriber server.Subscriber) (*server.Subscriber, error) {
+	body, err := json.Marshal(subscriber)
+	if err != nil {
+		return nil, err
+	}
+
+	added := new(server.Subscriber)
+	err = c.request(http.MethodPost, server.TopicPath+"/"+topic+server.SubscribersPath, "application/json", bytes.NewReader(body), http.StatusCreated, added)
+	return added, err
+}
+
+// RemoveSubscriber stops posting the events of a topic to the subscriber identified by id
+func (c *Client) RemoveSubscriber(topic, id string) error {
+	return c.request(http.MethodDelete, server.TopicPath+"/"+topic+server.SubscribersPath+"/"+id, "", nil, http.StatusOK, nil)
+}
+
+// PublishedEvents returns the recent events of a topic and whether each subscriber accepted them
+func (c *Client) PublishedEvents(topic string) ([]*server.Event, error) {
+	events := make([]*server.Event, 0)
+	err := c.request(http.MethodGet, server.TopicPath+"/"+topic+server.EventsPath, "", nil, http.StatusOK, &events)
+	return events, err
+}
+
+// DeadLetters returns the events of a topic a subscriber did not accept after all attempts
+func (c *Client) DeadLetters(topic string) ([]*server.Event, error) {
+	events := make([]*server.Event, 0)
+	err := c.request(http.MethodGet, server.TopicPath+"/"+topic+server.DeadLettersPath, "", nil, http.StatusOK, &events)
+	return events, err
+}
+
+// Redeliver posts the event identified by id again to the subscribers that did not accept it
+func (c *Client) Redeliver(topic, id string) (*server.Event, error) {
+	event := new(server.Event)
+	err := c.request(http.MethodPost, server.TopicPath+"/"+topic+server.EventsPath+"/"+id+server.RedeliverPath, "", nil, http.StatusAccepted, event)
+	return event, err
+}
+
+// request sends a request to the external API and decodes the answer into result if it is not nil. Answers other than
+// expected are returned as ErrUnknownServerError
+func (c *Client) request(method, path, contentType string, body io.Reader, expected int, result interface{}) error {
+	u := url.URL{Scheme: c.scheme, Host: c.host + ":" + c.port, Path: path}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient(time.Second * 10).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		return &server.ErrUnknownServerError{Message: strconv.Itoa(resp.StatusCode)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
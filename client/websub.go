@@ -0,0 +1,41 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package captainhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/server"
+)
+
+// SubscribeWebSub subscribes the hook identified by identifier to a topic of a WebSub hub. The hub is discovered from
+// the topic if it is empty. The content the hub distributes is received like any other call of the hook once the hub
+// verified the subscription, see GetWebSub
+func (c *Client) SubscribeWebSub(identifier, topic, hub string, leaseSeconds int) (*server.WebSub, error) {
+	body, err := json.Marshal(server.WebSub{Topic: topic, Hub: hub, LeaseSeconds: leaseSeconds})
+	if err != nil {
+		return nil, err
+	}
+
+	websub := new(server.WebSub)
+	err = c.request(http.MethodPut, server.HookPath+"/"+identifier+server.WebSubPath, "application/json", bytes.NewReader(body), http.StatusAccepted, websub)
+	return websub, err
+}
+
+// GetWebSub returns the WebSub subscription of the hook identified by identifier and its state
+func (c *Client) GetWebSub(identifier string) (*server.WebSub, error) {
+	websub := new(server.WebSub)
+	err := c.request(http.MethodGet, server.HookPath+"/"+identifier+server.WebSubPath, "", nil, http.StatusOK, websub)
+	return websub, err
+}
+
+// UnsubscribeWebSub asks the hub to unsubscribe the hook identified by identifier
+func (c *Client) UnsubscribeWebSub(identifier string) error {
+	return c.request(http.MethodDelete, server.HookPath+"/"+identifier+server.WebSubPath, "", nil, http.StatusAccepted, nil)
+}
@@ -0,0 +1,292 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package captainhookpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// CaptainHookClient is the client API for CaptainHook service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CaptainHookClient interface {
+	// Receive streams the calls of all hooks of the client, requests that were queued while nobody was connected first.
+	// Every call with a delivery has to be acknowledged, calls that are not acknowledged when the stream ends are
+	// queued again. The stream ends when the client closes its side
+	Receive(ctx context.Context, opts ...grpc.CallOption) (CaptainHook_ReceiveClient, error)
+	// ListHooks returns the hooks of the client matching the request
+	ListHooks(ctx context.Context, in *ListHooksRequest, opts ...grpc.CallOption) (*ListHooksResponse, error)
+	// GetHook returns a hook by identifier, slug or alias
+	GetHook(ctx context.Context, in *GetHookRequest, opts ...grpc.CallOption) (*Hook, error)
+	// CreateHook creates a new hook
+	CreateHook(ctx context.Context, in *CreateHookRequest, opts ...grpc.CallOption) (*Hook, error)
+	// DeleteHook removes a hook
+	DeleteHook(ctx context.Context, in *DeleteHookRequest, opts ...grpc.CallOption) (*DeleteHookResponse, error)
+}
+
+type captainHookClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCaptainHookClient(cc grpc.ClientConnInterface) CaptainHookClient {
+	return &captainHookClient{cc}
+}
+
+func (c *captainHookClient) Receive(ctx context.Context, opts ...grpc.CallOption) (CaptainHook_ReceiveClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CaptainHook_ServiceDesc.Streams[0], "/captainhook.v1.CaptainHook/Receive", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &captainHookReceiveClient{stream}
+	return x, nil
+}
+
+type CaptainHook_ReceiveClient interface {
+	Send(*Ack) error
+	Recv() (*Call, error)
+	grpc.ClientStream
+}
+
+type captainHookReceiveClient struct {
+	grpc.ClientStream
+}
+
+func (x *captainHookReceiveClient) Send(m *Ack) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *captainHookReceiveClient) Recv() (*Call, error) {
+	m := new(Call)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *captainHookClient) ListHooks(ctx context.Context, in *ListHooksRequest, opts ...grpc.CallOption) (*ListHooksResponse, error) {
+	out := new(ListHooksResponse)
+	err := c.cc.Invoke(ctx, "/captainhook.v1.CaptainHook/ListHooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captainHookClient) GetHook(ctx context.Context, in *GetHookRequest, opts ...grpc.CallOption) (*Hook, error) {
+	out := new(Hook)
+	err := c.cc.Invoke(ctx, "/captainhook.v1.CaptainHook/GetHook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captainHookClient) CreateHook(ctx context.Context, in *CreateHookRequest, opts ...grpc.CallOption) (*Hook, error) {
+	out := new(Hook)
+	err := c.cc.Invoke(ctx, "/captainhook.v1.CaptainHook/CreateHook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captainHookClient) DeleteHook(ctx context.Context, in *DeleteHookRequest, opts ...grpc.CallOption) (*DeleteHookResponse, error) {
+	out := new(DeleteHookResponse)
+	err := c.cc.Invoke(ctx, "/captainhook.v1.CaptainHook/DeleteHook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CaptainHookServer is the server API for CaptainHook service.
+// All implementations must embed UnimplementedCaptainHookServer
+// for forward compatibility
+type CaptainHookServer interface {
+	// Receive streams the calls of all hooks of the client, requests that were queued while nobody was connected first.
+	// Every call with a delivery has to be acknowledged, calls that are not acknowledged when the stream ends are
+	// queued again. The stream ends when the client closes its side
+	Receive(CaptainHook_ReceiveServer) error
+	// ListHooks returns the hooks of the client matching the request
+	ListHooks(context.Context, *ListHooksRequest) (*ListHooksResponse, error)
+	// GetHook returns a hook by identifier, slug or alias
+	GetHook(context.Context, *GetHookRequest) (*Hook, error)
+	// CreateHook creates a new hook
+	CreateHook(context.Context, *CreateHookRequest) (*Hook, error)
+	// DeleteHook removes a hook
+	DeleteHook(context.Context, *DeleteHookRequest) (*DeleteHookResponse, error)
+	mustEmbedUnimplementedCaptainHookServer()
+}
+
+// UnimplementedCaptainHookServer must be embedded to have forward compatible implementations.
+type UnimplementedCaptainHookServer struct {
+}
+
+func (UnimplementedCaptainHookServer) Receive(CaptainHook_ReceiveServer) error {
+	return status.Errorf(codes.Unimplemented, "method Receive not implemented")
+}
+func (UnimplementedCaptainHookServer) ListHooks(context.Context, *ListHooksRequest) (*ListHooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListHooks not implemented")
+}
+func (UnimplementedCaptainHookServer) GetHook(context.Context, *GetHookRequest) (*Hook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHook not implemented")
+}
+func (UnimplementedCaptainHookServer) CreateHook(context.Context, *CreateHookRequest) (*Hook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateHook not implemented")
+}
+func (UnimplementedCaptainHookServer) DeleteHook(context.Context, *DeleteHookRequest) (*DeleteHookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteHook not implemented")
+}
+func (UnimplementedCaptainHookServer) mustEmbedUnimplementedCaptainHookServer() {}
+
+// UnsafeCaptainHookServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CaptainHookServer will
+// result in compilation errors.
+type UnsafeCaptainHookServer interface {
+	mustEmbedUnimplementedCaptainHookServer()
+}
+
+func RegisterCaptainHookServer(s grpc.ServiceRegistrar, srv CaptainHookServer) {
+	s.RegisterService(&CaptainHook_ServiceDesc, srv)
+}
+
+func _CaptainHook_Receive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CaptainHookServer).Receive(&captainHookReceiveServer{stream})
+}
+
+type CaptainHook_ReceiveServer interface {
+	Send(*Call) error
+	Recv() (*Ack, error)
+	grpc.ServerStream
+}
+
+type captainHookReceiveServer struct {
+	grpc.ServerStream
+}
+
+func (x *captainHookReceiveServer) Send(m *Call) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *captainHookReceiveServer) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CaptainHook_ListHooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListHooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptainHookServer).ListHooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/captainhook.v1.CaptainHook/ListHooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptainHookServer).ListHooks(ctx, req.(*ListHooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptainHook_GetHook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptainHookServer).GetHook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/captainhook.v1.CaptainHook/GetHook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptainHookServer).GetHook(ctx, req.(*GetHookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptainHook_CreateHook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateHookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptainHookServer).CreateHook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/captainhook.v1.CaptainHook/CreateHook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptainHookServer).CreateHook(ctx, req.(*CreateHookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptainHook_DeleteHook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteHookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptainHookServer).DeleteHook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/captainhook.v1.CaptainHook/DeleteHook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptainHookServer).DeleteHook(ctx, req.(*DeleteHookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CaptainHook_ServiceDesc is the grpc.ServiceDesc for CaptainHook service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CaptainHook_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "captainhook.v1.CaptainHook",
+	HandlerType: (*CaptainHookServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListHooks",
+			Handler:    _CaptainHook_ListHooks_Handler,
+		},
+		{
+			MethodName: "GetHook",
+			Handler:    _CaptainHook_GetHook_Handler,
+		},
+		{
+			MethodName: "CreateHook",
+			Handler:    _CaptainHook_CreateHook_Handler,
+		},
+		{
+			MethodName: "DeleteHook",
+			Handler:    _CaptainHook_DeleteHook_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Receive",
+			Handler:       _CaptainHook_Receive_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "captainhook.proto",
+}
@@ -0,0 +1,10 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+// Package captainhookpb contains the gRPC service of CaptainHook generated from captainhook.proto
+package captainhookpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative captainhook.proto
@@ -14,12 +14,40 @@ import (
 func main() {
 	server.InitConfig()
 
-	s := server.NewServer(viper.GetString("Host"), viper.GetString("ExternalPort"))
+	baseURL, err := server.PublicBaseURL()
+	if err != nil {
+		panic(err)
+	}
+
+	s := server.NewServer(baseURL)
+	s.ConfigureOutbound(viper.GetBool("AllowPrivateNetworks"),
+		viper.GetInt("ExternalPort"),
+		viper.GetInt("ExternalSSLPort"),
+		viper.GetInt("InternalPort"),
+		viper.GetInt("GRPCPort"))
+	err = s.ConfigureIPFilter(viper.GetString("IPPresetFile"),
+		viper.GetBool("TrustForwardedFor"),
+		viper.GetStringSlice("TrustedProxies"))
+	if err != nil {
+		panic(err)
+	}
+	s.ConfigureAudit(viper.GetString("AuditLogFile"))
+	s.ConfigureDeliveries(viper.GetInt("DeliveryRetention"), viper.GetInt("QueueLimit"))
+	s.ConfigureEvents(viper.GetInt("EventRetention"))
+	s.ConfigureLimits(server.Limits{
+		MaxBodySize: viper.GetInt64("MaxBodySize"),
+		RateLimit:   viper.GetFloat64("RateLimit"),
+		RateBurst:   viper.GetInt("RateBurst"),
+		MaxInFlight: viper.GetInt("MaxInFlight"),
+	})
+	// pushes and dispatches resume while loading, they need the configuration
 	s.Load()
+	s.StartReaper(viper.GetDuration("ReaperInterval"))
 	server.SetupSSLAPI(viper.GetString("Host"),
 		viper.GetInt("ExternalPort"),
 		viper.GetInt("ExternalSSLPort"),
 		viper.GetInt("InternalPort"),
+		viper.GetInt("GRPCPort"),
 		s,
 		viper.GetString("SSLCertificate"),
 		viper.GetString("SSLKey"))
@@ -0,0 +1,203 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gin-gonic/gin"
+)
+
+// auditPrefix is the key prefix of audit entries. Client names can't contain the delimeter, so this never collides with a client
+const auditPrefix = delimeter + "Audit" + delimeter
+
+// UserHeader is the header the CLI uses to tell the internal API which user runs it
+const UserHeader = "X-CaptainHook-User"
+
+// contextClientKey is the key auth stores the name of the authorized client in the gin context
+const contextClientKey = "captainhook.client"
+
+var auditSequence uint32
+
+// AuditEntry describes an administrative action on the server
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target"`
+	SourceIP string    `json:"sourceIP"`
+	Status   int       `json:"status"`
+}
+
+// AuditFilter restricts which audit entries are returned. Empty fields match everything
+type AuditFilter struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	Limit  int
+}
+
+func (f *AuditFilter) matches(e *AuditEntry) bool {
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Actor != "" && !strings.Contains(e.Actor, f.Actor) {
+		return false
+	}
+	if f.Action != "" && !strings.Contains(e.Action, f.Action) {
+		return false
+	}
+	return true
+}
+
+// auditLog appends entries to the database and optionally to a JSON-lines file
+type auditLog struct {
+	mu   sync.Mutex
+	file string
+}
+
+// ConfigureAudit sets a JSON-lines file every audit entry is appended to. Leave empty to only store entries in the database
+func (s *Server) ConfigureAudit(file string) {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	s.audit.file = file
+}
+
+// Audit records an administrative action
+func (s *Server) Audit(entry *AuditEntry) {
+	err := s.DB.StoreAuditEntry(entry)
+	if err != nil {
+		log.Errorf("Could not store audit entry: %s", err.Error())
+	}
+
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	if s.audit.file == "" {
+		return
+	}
+
+	f, err := os.OpenFile(s.audit.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("Could not open audit log: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	err = json.NewEncoder(f).Encode(entry)
+	if err != nil {
+		log.Errorf("Could not write audit log: %s", err.Error())
+	}
+}
+
+// StoreAuditEntry appends an audit entry to the database
+func (db *DB) StoreAuditEntry(entry *AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// the sequence keeps entries with the same timestamp apart
+	seq := atomic.AddUint32(&auditSequence, 1) % 1000000
+	key := fmt.Sprintf("%s%020d%06d", auditPrefix, entry.Time.UnixNano(), seq)
+
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), b)
+	})
+}
+
+// AuditEntries returns all audit entries matching the filter, oldest first. If a limit is set, only the newest entries are returned
+func (db *DB) AuditEntries(filter AuditFilter) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(auditPrefix)
+		start := prefix
+		if !filter.Since.IsZero() {
+			start = []byte(fmt.Sprintf("%s%020d", auditPrefix, filter.Since.UnixNano()))
+		}
+
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			entry := new(AuditEntry)
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, entry)
+			})
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, err
+}
+
+// auditLogger records every mutating API call in the audit log. Calls of the webhooks themselves and published events
+// are not administrative actions, redeliveries are
+func auditLogger(server *Server, internal bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+
+		path := c.FullPath()
+		if path == "" || strings.HasPrefix(path, ExternalHookPath+"/") || path == TopicPath+"/:topic"+EventsPath {
+			return
+		}
+
+		actor := "anonymous"
+		if internal {
+			actor = "admin"
+			if user := c.GetHeader(UserHeader); user != "" {
+				actor = "admin:" + user
+			}
+		} else if name := c.GetString(contextClientKey); name != "" {
+			actor = "client:" + name
+		}
+
+		target := make([]string, 0, len(c.Params))
+		for _, p := range c.Params {
+			target = append(target, p.Key+"="+p.Value)
+		}
+
+		sourceIP := c.Request.RemoteAddr
+		if ip := server.clientIP(c.Request); ip != nil {
+			sourceIP = ip.String()
+		}
+
+		server.Audit(&AuditEntry{
+			Time:     time.Now(),
+			Actor:    actor,
+			Action:   c.Request.Method + " " + path,
+			Target:   strings.Join(target, " "),
+			SourceIP: sourceIP,
+			Status:   c.Writer.Status(),
+		})
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestAuditLogger checks which calls of the external API are recorded in the audit log
+func TestAuditLogger(t *testing.T) {
+	s := testServer(t.TempDir())
+	defer s.Stop()
+
+	router := gin.New()
+	router.Use(auditLogger(s, false))
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+	router.POST(ExternalHookPath+"/:uuid", ok)
+	router.POST(TopicPath+"/:topic"+EventsPath, ok)
+	router.GET(TopicPath+"/:topic"+EventsPath, ok)
+	router.POST(TopicPath+"/:topic"+EventsPath+"/:event"+RedeliverPath, ok)
+	router.POST(TopicPath+"/:topic"+SubscribersPath, ok)
+
+	tables := []struct {
+		method  string
+		path    string
+		audited bool
+	}{
+		{http.MethodPost, ExternalHookPath + "/abc", false},
+		{http.MethodPost, TopicPath + "/builds" + EventsPath, false},
+		{http.MethodGet, TopicPath + "/builds" + EventsPath, false},
+		{http.MethodPost, TopicPath + "/builds" + EventsPath + "/1" + RedeliverPath, true},
+		{http.MethodPost, TopicPath + "/builds" + SubscribersPath, true},
+	}
+
+	for _, table := range tables {
+		before, err := s.DB.AuditEntries(AuditFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest(table.method, table.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		entries, err := s.DB.AuditEntries(AuditFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if audited := len(entries) > len(before); audited != table.audited {
+			t.Errorf("%s %s: audited is %t, want %t", table.method, table.path, audited, table.audited)
+		}
+	}
+}
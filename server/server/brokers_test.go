@@ -0,0 +1,323 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofrs/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/streadway/amqp"
+)
+
+// The broker sinks are tested against real brokers, which are only used if their environment variable is set.
+// testdata/brokers.yaml starts them in containers. The outbox is tested without a broker in outbox_test.go
+
+const testWait = 10 * time.Second
+
+// broker returns the address of the broker in the environment variable name or skips the test
+func broker(t *testing.T, name string) string {
+	addr := os.Getenv(name)
+	if addr == "" {
+		t.Skipf("%s is not set", name)
+	}
+	return addr
+}
+
+func testSinkCall(t *testing.T) *SinkCall {
+	id, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SinkCall{
+		Delivery:   id.String(),
+		Hook:       "test",
+		HookUUID:   "3f1c4f5e-4ad2-4f3c-9f5e-2b8f6f0d9a11",
+		Client:     "testclient",
+		ReceivedAt: time.Now().UTC().Truncate(time.Second),
+		Method:     http.MethodPost,
+		Path:       "/h/3f1c4f5e-4ad2-4f3c-9f5e-2b8f6f0d9a11",
+		Header:     http.Header{"Content-Type": {"application/json"}, "X-Github-Event": {"push"}},
+		Body:       json.RawMessage(`{"ref":"refs/heads/master"}`),
+		body:       []byte(`{"ref":"refs/heads/master"}`),
+	}
+}
+
+func openTestSink(t *testing.T, sink *Sink) sinkDriver {
+	err := sink.prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.driver.close() })
+	return sink.driver
+}
+
+// checkSinkCall compares a published call with the one that was sent
+func checkSinkCall(t *testing.T, data []byte, sent *SinkCall) {
+	got := new(SinkCall)
+	err := json.Unmarshal(data, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Delivery != sent.Delivery || got.Hook != sent.Hook || got.Method != sent.Method || !got.ReceivedAt.Equal(sent.ReceivedAt) {
+		t.Errorf("got call %+v, sent %+v", got, sent)
+	}
+	if string(got.Body) != string(sent.Body) {
+		t.Errorf("got body %s, sent %s", got.Body, sent.Body)
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	url := broker(t, "CAPTAINHOOK_TEST_NATS")
+	sub, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	messages, err := sub.SubscribeSync("captainhook.test.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Flush()
+
+	driver := openTestSink(t, &Sink{Type: SinkNATS, NATS: &NATSSink{URL: url, Subject: `captainhook.test.{{ .Hook }}.{{ .Header.Get "X-GitHub-Event" }}`}})
+	// the sink connects in the background
+	deadline := time.Now().Add(testWait)
+	for !driver.(*natsDriver).conn.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	call := testSinkCall(t)
+	err = driver.send(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := messages.NextMsg(testWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "captainhook.test.test.push" {
+		t.Errorf("published to %s", msg.Subject)
+	}
+	if msg.Header.Get(msgIDHeader) != call.Delivery {
+		t.Errorf("got %s %s, want %s", msgIDHeader, msg.Header.Get(msgIDHeader), call.Delivery)
+	}
+	checkSinkCall(t, msg.Data, call)
+}
+
+func TestKafkaSink(t *testing.T) {
+	brokers := strings.Split(broker(t, "CAPTAINHOOK_TEST_KAFKA"), ",")
+	driver := openTestSink(t, &Sink{Type: SinkKafka, Kafka: &KafkaSink{Brokers: brokers, Topic: "captainhook-{{ .Hook }}"}})
+
+	call := testSinkCall(t)
+	err := driver.send(call)
+	// the topic might be created by the first call
+	for attempt := 0; err != nil && attempt < 10; attempt++ {
+		time.Sleep(time.Second)
+		err = driver.send(call)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		Topic:       "captainhook-test",
+		GroupID:     "captainhook-test-" + call.Delivery,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Headers) == 0 || string(msg.Headers[0].Value) != call.Delivery {
+			// a call of an earlier run
+			continue
+		}
+		if string(msg.Key) != call.Hook {
+			t.Errorf("got key %s, want %s", msg.Key, call.Hook)
+		}
+		checkSinkCall(t, msg.Value, call)
+		return
+	}
+}
+
+func TestAMQPSink(t *testing.T) {
+	url := broker(t, "CAPTAINHOOK_TEST_AMQP")
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := channel.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the default exchange routes to the queue named like the routing key
+	driver := openTestSink(t, &Sink{Type: SinkAMQP, AMQP: &AMQPSink{URL: url, RoutingKey: queue.Name}})
+	call := testSinkCall(t)
+	err = driver.send(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-deliveries:
+		if d.MessageId != call.Delivery {
+			t.Errorf("got message ID %s, want %s", d.MessageId, call.Delivery)
+		}
+		if d.DeliveryMode != amqp.Persistent {
+			t.Error("message is not persistent")
+		}
+		checkSinkCall(t, d.Body, call)
+	case <-time.After(testWait):
+		t.Fatal("no message arrived")
+	}
+}
+
+func TestRedisSink(t *testing.T) {
+	url := broker(t, "CAPTAINHOOK_TEST_REDIS")
+	call := testSinkCall(t)
+	stream := "captainhook:test:" + call.Delivery
+	driver := openTestSink(t, &Sink{Type: SinkRedis, Redis: &RedisSink{URL: url, Stream: "captainhook:{{ .Hook }}:{{ .Delivery }}", MaxLen: 10}})
+
+	err := driver.send(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkRedisStream(t, url, stream, call)
+}
+
+// checkRedisStream checks that call is the only entry of the stream and removes it
+func checkRedisStream(t *testing.T, url, stream string, call *SinkCall) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+	ctx := context.Background()
+	defer client.Del(ctx, stream)
+
+	entries, err := client.XRange(ctx, stream, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if entries[0].Values["delivery"] != call.Delivery {
+		t.Errorf("got delivery %v, want %s", entries[0].Values["delivery"], call.Delivery)
+	}
+	data, _ := entries[0].Values["call"].(string)
+	checkSinkCall(t, []byte(data), call)
+}
+
+// TestSinkOutbox checks that a call stays in the outbox of a redis sink until it is passed on, even across restarts
+func TestSinkOutbox(t *testing.T) {
+	url := broker(t, "CAPTAINHOOK_TEST_REDIS")
+	dir := t.TempDir()
+	s := testServer(dir)
+
+	_, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := s.AddHook("testclient", "test", HookOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks, err := s.SetHookSinks("testclient", "test", []*Sink{{Type: SinkRedis, Redis: &RedisSink{URL: url, Stream: "captainhook:{{ .Hook }}:{{ .Delivery }}"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the call is stored, but the server stops before it is passed on
+	sinks[0].outbox.close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/"+hook.UUID, strings.NewReader(`{"ref":"refs/heads/master"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "127.0.0.1:49152"
+	_, err = s.HandleHook(hook.UUID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := req.Header.Get(DeliveryHeader)
+
+	_, entries, err := s.DB.Outbox(sinks[0].ID, outboxBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d calls in the outbox, want 1", len(entries))
+	}
+	s.Stop()
+
+	// the outbox is passed on once the server runs again
+	s = testServer(dir)
+	defer s.Stop()
+	s.Load()
+	deadline := time.Now().Add(testWait)
+	for len(entries) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		_, entries, err = s.DB.Outbox(sinks[0].ID, outboxBatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(entries) > 0 {
+		t.Fatal("the call was not passed on")
+	}
+
+	deliveries, err := s.DB.Deliveries(hook.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Outcome != OutcomeDelivered || deliveries[0].Sinks[0].Outcome != OutcomeDelivered {
+		t.Errorf("got deliveries %+v", deliveries)
+	}
+
+	call := &SinkCall{Delivery: delivery, Hook: "test", Method: http.MethodPost, ReceivedAt: deliveries[0].ReceivedAt, Body: json.RawMessage(`{"ref":"refs/heads/master"}`)}
+	checkRedisStream(t, url, "captainhook:test:"+delivery, call)
+}
+
+// testServer returns a server with its database in dir
+func testServer(dir string) *Server {
+	return &Server{
+		Clients:           make(map[string]*Client),
+		Hooks:             make(map[string]*Webhook),
+		DB:                Open(dir),
+		slugs:             make(map[string]*Webhook),
+		presets:           make(map[string][]*net.IPNet),
+		limiter:           newLimiter(Limits{}),
+		deliveryRetention: 10,
+	}
+}
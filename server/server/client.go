@@ -12,6 +12,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,12 +23,56 @@ const secretByteLength = 32
 
 // Client contains the information and hooks of a registered client
 type Client struct {
+	// ID never changes, it prefixes the secret and the database keys. Clients created before it existed use their name
+	ID string `json:"id"`
+	// Name is used to manage the client and can be changed
 	Name       string              `json:"name"`
 	Secret     []byte              `json:"-"`
+	Disabled   bool                `json:"disabled"`
+	Owner      string              `json:"owner"`
+	Contact    string              `json:"contact"`
 	CreatedAt  time.Time           `json:"createdAt"`
 	LastAction time.Time           `json:"lastAction"`
 	Hooks      map[string]*Webhook `json:"hooks"`
-	ws         []*melody.Melody
+	Targets    []*Target           `json:"targets"`
+	Sinks      []*Sink             `json:"sinks,omitempty"`
+	Topics     map[string]*Topic   `json:"topics,omitempty"`
+	ws         []*connection
+	// polledAt is the end of the last poll, polls are the calls of answered polls until they are acknowledged
+	polledAt time.Time
+	polls    map[string]*time.Timer
+	wsMu     sync.Mutex
+}
+
+// connection is an open websocket, event stream or poll of a client. Calls are passed on in its format, or in the
+// format of their hook if it is empty
+type connection struct {
+	m *melody.Melody
+	// messages receives the calls of streams and polls, which have no websocket
+	messages  chan *queuedRequest
+	closed    chan struct{}
+	closeOnce sync.Once
+	format    string
+}
+
+// close ends the connection
+func (con *connection) close() {
+	if con.m != nil {
+		con.m.Close()
+		return
+	}
+	con.closeOnce.Do(func() {
+		close(con.closed)
+	})
+}
+
+// ClientUpdate changes the name, state or contact information of a client. Fields that are nil are left unchanged
+type ClientUpdate struct {
+	Name *string `json:"name"`
+	// Disabled clients can't authenticate and their connections are closed, nothing is deleted
+	Disabled *bool   `json:"disabled"`
+	Owner    *string `json:"owner"`
+	Contact  *string `json:"contact"`
 }
 
 func (c *Client) generateSecret() (string, error) {
@@ -42,21 +87,27 @@ func (c *Client) generateSecret() (string, error) {
 		return "", err
 	}
 
-	s := c.Name + ":" + base64.URLEncoding.EncodeToString(b)
+	s := c.ID + ":" + base64.URLEncoding.EncodeToString(b)
 	c.Secret = sha256.New().Sum([]byte(s))
 
 	return s, nil
 }
 
-// OpenWebsocket opens a socket for this client that listens to all hooks
-func (c *Client) OpenWebsocket(con *gin.Context) {
+// OpenWebsocket opens a socket for this client that listens to all hooks. bufferSize is the number of messages the connection
+// buffers, format is the output format of the connection or empty to use the one of each hook. onConnect is called once the
+// connection is established. It blocks until the connection is closed
+func (c *Client) OpenWebsocket(con *gin.Context, bufferSize int, format string, onConnect func(*melody.Session)) {
 	m := melody.New()
-	if c.ws == nil {
-		c.ws = make([]*melody.Melody, 0)
+	m.Config.MessageBufferSize = bufferSize
+	if onConnect != nil {
+		m.HandleConnect(onConnect)
 	}
-	c.ws = append(c.ws, m)
+
+	ws := &connection{m: m, format: format}
+	c.addConnection(ws)
 
 	err := m.HandleRequest(con.Writer, con.Request)
+	c.removeConnection(ws)
 	if err != nil {
 		log.Print(err)
 		err = m.CloseWithMsg([]byte(err.Error()))
@@ -64,13 +115,99 @@ func (c *Client) OpenWebsocket(con *gin.Context) {
 			log.Print(err)
 		}
 		con.Status(http.StatusInternalServerError)
+		return
+	}
+	m.Close()
+}
+
+// openStream registers a connection without websocket, calls are sent to its messages. bufferSize is the number of
+// messages it buffers, further calls are dropped until they are read
+func (c *Client) openStream(bufferSize int, format string) *connection {
+	stream := &connection{
+		messages: make(chan *queuedRequest, bufferSize),
+		closed:   make(chan struct{}),
+		format:   format,
+	}
+	c.addConnection(stream)
+	return stream
+}
+
+func (c *Client) addConnection(con *connection) {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+
+	if c.ws == nil {
+		c.ws = make([]*connection, 0)
+	}
+	c.ws = append(c.ws, con)
+}
+
+// removeConnection forgets a closed connection. No more calls are sent to it afterwards
+func (c *Client) removeConnection(con *connection) {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+
+	for i, ws := range c.ws {
+		if ws == con {
+			c.ws = append(c.ws[:i], c.ws[i+1:]...)
+			return
+		}
+	}
+}
+
+// broadcast sends msg, a serialized request of hook, to all connections of this client and returns the number of receivers.
+// deliveryKey is passed on to streams so they can track the delivery
+func (c *Client) broadcast(hook *Webhook, msg []byte, deliveryKey string) int {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+
+	formatted := make(map[string][]byte)
+	receivers := 0
+	for _, ws := range c.ws {
+		if ws.m != nil && ws.m.Len() == 0 {
+			continue
+		}
+
+		format := ws.format
+		if format == "" {
+			format = hook.outputFormat()
+		}
+		if formatted[format] == nil {
+			b, err := hook.format(msg, format)
+			if err != nil {
+				log.Errorf("Could not format request as %s: %s", format, err.Error())
+				continue
+			}
+			formatted[format] = b
+		}
+
+		if ws.m == nil {
+			select {
+			case ws.messages <- &queuedRequest{Delivery: deliveryKey, Message: formatted[format]}:
+				receivers++
+			default:
+				log.Warnf("Stream of %s is full, dropping call", c.Name)
+			}
+			continue
+		}
+
+		err := ws.m.Broadcast(formatted[format])
+		if err != nil {
+			log.Error("Could not send to websocket")
+			continue
+		}
+		receivers += ws.m.Len()
 	}
+	return receivers
 }
 
 // Destroy this client and all related webhooks and connections
 func (c *Client) Destroy() {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+
 	for _, w := range c.ws {
-		w.Close()
+		w.close()
 	}
 }
 
@@ -78,12 +215,20 @@ func (c *Client) Destroy() {
 func (c *Client) MarshalJSON() ([]byte, error) {
 	_, h := hookMapToSlice(c.Hooks)
 	cli := struct {
+		ID         string     `json:"id"`
 		Name       string     `json:"name"`
+		Disabled   bool       `json:"disabled"`
+		Owner      string     `json:"owner"`
+		Contact    string     `json:"contact"`
 		CreatedAt  time.Time  `json:"createdAt"`
 		LastAction time.Time  `json:"lastAction"`
 		Hooks      []*Webhook `json:"hooks"`
 	}{
+		c.ID,
 		c.Name,
+		c.Disabled,
+		c.Owner,
+		c.Contact,
 		c.CreatedAt,
 		c.LastAction,
 		h,
@@ -96,7 +241,11 @@ func (c *Client) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON unmarshals the JSON representation of a client
 func (c *Client) UnmarshalJSON(in []byte) error {
 	cli := struct {
+		ID         string     `json:"id"`
 		Name       string     `json:"name"`
+		Disabled   bool       `json:"disabled"`
+		Owner      string     `json:"owner"`
+		Contact    string     `json:"contact"`
 		CreatedAt  time.Time  `json:"createdAt"`
 		LastAction time.Time  `json:"lastAction"`
 		Hooks      []*Webhook `json:"hooks"`
@@ -107,7 +256,11 @@ func (c *Client) UnmarshalJSON(in []byte) error {
 		return err
 	}
 
+	c.ID = cli.ID
 	c.Name = cli.Name
+	c.Disabled = cli.Disabled
+	c.Owner = cli.Owner
+	c.Contact = cli.Contact
 	c.CreatedAt = cli.CreatedAt
 	c.LastAction = cli.LastAction
 	c.Hooks = make(map[string]*Webhook)
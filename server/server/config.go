@@ -8,11 +8,17 @@ package server
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme/autocert"
 )
 
+const defaultACMECacheDir = "/var/cerinuts/captainhook/acme"
+
 // InitConfig initializes the config with default values
 func InitConfig() {
 
@@ -25,8 +31,31 @@ func InitConfig() {
 	viper.SetDefault("ExternalPort", 12840)
 	viper.SetDefault("ExternalSSLPort", 12842)
 	viper.SetDefault("InternalPort", 12841)
+	viper.SetDefault("GRPCPort", 0)
 	viper.SetDefault("SSLCertificate", "")
 	viper.SetDefault("SSLKey", "")
+	viper.SetDefault("ACME", false)
+	viper.SetDefault("ACMEDirectoryURL", autocert.DefaultACMEDirectory)
+	viper.SetDefault("ACMECacheDir", defaultACMECacheDir)
+	viper.SetDefault("ACMEEmail", "")
+	viper.SetDefault("ACMEDomains", []string{})
+	viper.SetDefault("ACMECAFile", "")
+	viper.SetDefault("ClientCAFile", "")
+	viper.SetDefault("ClientCertificateMap", map[string]string{})
+	viper.SetDefault("IPPresetFile", "")
+	viper.SetDefault("TrustForwardedFor", false)
+	viper.SetDefault("TrustedProxies", []string{})
+	viper.SetDefault("AllowPrivateNetworks", false)
+	viper.SetDefault("MaxBodySize", 10*1024*1024)
+	viper.SetDefault("RateLimit", 0)
+	viper.SetDefault("RateBurst", 0)
+	viper.SetDefault("MaxInFlight", 0)
+	viper.SetDefault("AuditLogFile", "")
+	viper.SetDefault("DeliveryRetention", 100)
+	viper.SetDefault("QueueLimit", 1000)
+	viper.SetDefault("EventRetention", 1000)
+	viper.SetDefault("ReaperInterval", "30s")
+	viper.SetDefault("PublicBaseURL", "")
 	viper.SetDefault("Debug", false)
 	viper.SetDefault("Loglevel", "Warning")
 
@@ -39,3 +68,33 @@ func InitConfig() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 }
+
+// PublicBaseURL returns the URL the external API is reachable at from the outside, e.g. https://hooks.example.com/captainhook.
+// Hook URLs are generated below it. If PublicBaseURL is not configured, it is derived from the listener configuration
+func PublicBaseURL() (string, error) {
+	base := viper.GetString("PublicBaseURL")
+	if base == "" {
+		host := viper.GetString("Host")
+		if domains := viper.GetStringSlice("ACMEDomains"); viper.GetBool("ACME") && len(domains) > 0 {
+			host = domains[0]
+		}
+		if SSLEnabled() {
+			base = "https://" + host + ":" + strconv.Itoa(viper.GetInt("ExternalSSLPort"))
+		} else {
+			base = "http://" + host + ":" + strconv.Itoa(viper.GetInt("ExternalPort"))
+		}
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", &ErrInvalidBaseURL{Message: err.Error()}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", &ErrInvalidBaseURL{Message: "'" + base + "' needs a http or https scheme and a host"}
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", &ErrInvalidBaseURL{Message: "'" + base + "' must not contain a query or fragment"}
+	}
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
@@ -7,7 +7,9 @@ It is licensed under the MIT License
 package server
 
 import (
+	"encoding/json"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -56,44 +58,200 @@ func Open(path string) *DB {
 // Store stores a client in the database
 func (db *DB) Store(client *Client) error {
 	return db.bdb.Update(func(txn *badger.Txn) error {
-		err := txn.Set([]byte(client.Name+delimeter+"Secret"), []byte(client.Secret))
+		err := txn.Set([]byte(client.ID+delimeter+"Secret"), []byte(client.Secret))
 		if err != nil {
 			log.Error(err)
 			return err
 		}
 
-		err = txn.Set([]byte(client.Name+delimeter+"CreatedAt"), []byte(client.CreatedAt.Format(time.RFC3339)))
+		err = txn.Set([]byte(client.ID+delimeter+"Name"), []byte(client.Name))
 		if err != nil {
 			log.Error(err)
 			return err
 		}
 
-		err = txn.Set([]byte(client.Name+delimeter+"LastAction"), []byte(client.LastAction.Format(time.RFC3339)))
+		err = txn.Set([]byte(client.ID+delimeter+"Disabled"), []byte(strconv.FormatBool(client.Disabled)))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = txn.Set([]byte(client.ID+delimeter+"Owner"), []byte(client.Owner))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = txn.Set([]byte(client.ID+delimeter+"Contact"), []byte(client.Contact))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = setJSON(txn, client.ID+delimeter+"Targets", client.Targets)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = setJSON(txn, client.ID+delimeter+"Sinks", client.Sinks)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = setJSON(txn, client.ID+delimeter+"Topics", client.Topics)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = txn.Set([]byte(client.ID+delimeter+"CreatedAt"), []byte(client.CreatedAt.Format(time.RFC3339)))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = txn.Set([]byte(client.ID+delimeter+"LastAction"), []byte(client.LastAction.Format(time.RFC3339)))
 		if err != nil {
 			log.Error(err)
 			return err
 		}
 
 		for _, h := range client.Hooks {
-			err = txn.Set([]byte(client.Name+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"URL"), []byte(h.URL))
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"URL"), []byte(h.URL))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"UUID"), []byte(h.UUID))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"CreatedAt"), []byte(h.CreatedAt.Format(time.RFC3339)))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"LastCall"), []byte(h.LastCall.Format(time.RFC3339)))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Slug"), []byte(h.Slug))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Description"), []byte(h.Description))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Provider"), []byte(h.Provider))
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Labels", h.Labels)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Aliases", h.Aliases)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"IPFilter", h.IPFilter)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Limits", h.Limits)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Response", h.Response)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Filter", h.Filter)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Transforms", h.Transforms)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Output", h.Output)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Sinks", h.Sinks)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"NoReceiver", h.NoReceiver)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Pause", h.Pause)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Subscribers", h.Subscribers)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"WebSub", h.WebSub)
 			if err != nil {
 				log.Error(err)
 				return err
 			}
 
-			err = txn.Set([]byte(client.Name+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"UUID"), []byte(h.UUID))
+			err = setJSON(txn, client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"ExpiresAt", h.ExpiresAt)
 			if err != nil {
 				log.Error(err)
 				return err
 			}
 
-			err = txn.Set([]byte(client.Name+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"CreatedAt"), []byte(h.CreatedAt.Format(time.RFC3339)))
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"MaxCalls"), []byte(strconv.Itoa(h.MaxCalls)))
 			if err != nil {
 				log.Error(err)
 				return err
 			}
 
-			err = txn.Set([]byte(client.Name+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"LastCall"), []byte(h.LastCall.Format(time.RFC3339)))
+			err = txn.Set([]byte(client.ID+delimeter+"Hooks"+delimeter+h.Identifier+delimeter+"Calls"), []byte(strconv.Itoa(h.calls())))
 			if err != nil {
 				log.Error(err)
 				return err
@@ -105,7 +263,7 @@ func (db *DB) Store(client *Client) error {
 	})
 }
 
-// Load loads all clients in the database
+// Load loads all clients in the database, mapped by their ID
 func (db *DB) Load() (map[string]*Client, error) {
 	clients := make(map[string]*Client)
 	err := db.bdb.View(func(txn *badger.Txn) error {
@@ -116,6 +274,10 @@ func (db *DB) Load() (map[string]*Client, error) {
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
 			k := item.Key()
+			// keys starting with the delimeter don't belong to a client
+			if strings.HasPrefix(string(k), delimeter) {
+				continue
+			}
 			err := item.Value(func(v []byte) error {
 				return handleKeyValuePair(string(k), string(v), clients)
 			})
@@ -129,75 +291,266 @@ func (db *DB) Load() (map[string]*Client, error) {
 	return clients, err
 }
 
-// Delete deletes a client from the database
-func (db *DB) Delete(clientName string) error {
+// Delete deletes a client with all its hooks and queued requests from the database
+func (db *DB) Delete(clientID string) error {
 	return db.bdb.Update(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		prefix := []byte(clientName)
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			err := txn.Delete(k)
-			if err != nil {
-				log.Error(err)
-				return err
-			}
+		err := deleteKeys(txn, clientID+delimeter)
+		if err != nil {
+			return err
 		}
-		return nil
+		return deleteKeys(txn, queuePrefix+clientID+delimeter)
+	})
+}
+
+// DeleteHook deletes all keys of a hook of the given client together with its deliveries and buffered calls
+func (db *DB) DeleteHook(clientID, identifier, hookUUID string) error {
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		err := deleteKeys(txn, hookKeyPrefix(clientID, identifier))
+		if err != nil {
+			return err
+		}
+		if hookUUID == "" {
+			return nil
+		}
+		err = deleteKeys(txn, deliveryPrefix+hookUUID+delimeter)
+		if err != nil {
+			return err
+		}
+		return deleteKeys(txn, bufferPrefix+hookUUID+delimeter)
+	})
+}
+
+// deletePrefix deletes all keys starting with prefix
+func (db *DB) deletePrefix(p string) error {
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		return deleteKeys(txn, p)
 	})
 }
 
+// deleteKeys deletes all keys starting with prefix within txn
+func deleteKeys(txn *badger.Txn, p string) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	prefix := []byte(p)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		// the key has to stay valid until the transaction is committed
+		err := txn.Delete(it.Item().KeyCopy(nil))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// hookKeyPrefix is the prefix of all keys of a hook
+func hookKeyPrefix(clientID, identifier string) string {
+	return clientID + delimeter + "Hooks" + delimeter + identifier + delimeter
+}
+
 func handleKeyValuePair(k, v string, clients map[string]*Client) error {
 	keysplit := strings.Split(k, delimeter)
-	name := keysplit[0]
-	if clients[name] == nil {
-		clients[name] = new(Client)
-		clients[name].Hooks = make(map[string]*Webhook)
-		clients[name].Name = name
+	if len(keysplit) < 2 || (keysplit[1] == "Hooks" && len(keysplit) < 4) {
+		// left for fsck, see Server.Fsck
+		log.Warnf("Skipping malformed key %s", k)
+		return nil
+	}
+	id := keysplit[0]
+	if clients[id] == nil {
+		clients[id] = new(Client)
+		clients[id].Hooks = make(map[string]*Webhook)
+		clients[id].ID = id
+		// clients stored before they had an ID use it as name, otherwise it is overwritten by the Name key
+		clients[id].Name = id
 	}
 	switch keysplit[1] {
+	case "Name":
+		clients[id].Name = v
+	case "Disabled":
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		clients[id].Disabled = disabled
+	case "Owner":
+		clients[id].Owner = v
+	case "Contact":
+		clients[id].Contact = v
 	case "Secret":
-		clients[name].Secret = []byte(v)
+		clients[id].Secret = []byte(v)
+	case "Targets":
+		err := json.Unmarshal([]byte(v), &clients[id].Targets)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	case "Sinks":
+		err := json.Unmarshal([]byte(v), &clients[id].Sinks)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	case "Topics":
+		err := json.Unmarshal([]byte(v), &clients[id].Topics)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
 	case "CreatedAt":
 		t, err := time.Parse(time.RFC3339, v)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
-		clients[name].CreatedAt = t
+		clients[id].CreatedAt = t
 	case "LastAction":
 		t, err := time.Parse(time.RFC3339, v)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
-		clients[name].LastAction = t
+		clients[id].LastAction = t
 	case "Hooks":
-		if clients[name].Hooks[keysplit[2]] == nil {
-			clients[name].Hooks[keysplit[2]] = new(Webhook)
-			clients[name].Hooks[keysplit[2]].Identifier = keysplit[2]
+		if clients[id].Hooks[keysplit[2]] == nil {
+			clients[id].Hooks[keysplit[2]] = new(Webhook)
+			clients[id].Hooks[keysplit[2]].Identifier = keysplit[2]
 		}
 		switch keysplit[3] {
 		case "URL":
-			clients[name].Hooks[keysplit[2]].URL = v
+			clients[id].Hooks[keysplit[2]].URL = v
 		case "UUID":
-			clients[name].Hooks[keysplit[2]].UUID = v
+			clients[id].Hooks[keysplit[2]].UUID = v
 		case "CreatedAt":
 			t, err := time.Parse(time.RFC3339, v)
 			if err != nil {
 				log.Error(err)
 				return err
 			}
-			clients[name].Hooks[keysplit[2]].CreatedAt = t
+			clients[id].Hooks[keysplit[2]].CreatedAt = t
 		case "LastCall":
 			t, err := time.Parse(time.RFC3339, v)
 			if err != nil {
 				log.Error(err)
 				return err
 			}
-			clients[name].Hooks[keysplit[2]].LastCall = t
+			clients[id].Hooks[keysplit[2]].LastCall = t
+		case "Slug":
+			clients[id].Hooks[keysplit[2]].Slug = v
+		case "Description":
+			clients[id].Hooks[keysplit[2]].Description = v
+		case "Provider":
+			clients[id].Hooks[keysplit[2]].Provider = v
+		case "Labels":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Labels)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Aliases":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Aliases)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "IPFilter":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].IPFilter)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Response":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Response)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Limits":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Limits)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Filter":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Filter)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Transforms":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Transforms)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Output":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Output)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Sinks":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Sinks)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "NoReceiver":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].NoReceiver)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Pause":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Pause)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "Subscribers":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].Subscribers)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "WebSub":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].WebSub)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "ExpiresAt":
+			err := json.Unmarshal([]byte(v), &clients[id].Hooks[keysplit[2]].ExpiresAt)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		case "MaxCalls":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			clients[id].Hooks[keysplit[2]].MaxCalls = n
+		case "Calls":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			clients[id].Hooks[keysplit[2]].Calls = n
 		}
 	}
 	return nil
 }
+
+// setJSON stores the JSON representation of v. It is used for values that don't fit in a single string
+func setJSON(txn *badger.Txn, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(key), b)
+}
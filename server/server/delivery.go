@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gofrs/uuid"
+)
+
+// deliveryPrefix is the key prefix of delivery records, followed by the hook uuid
+const deliveryPrefix = delimeter + "Delivery" + delimeter
+
+// DeliveryHeader contains the id of the delivery in every request passed to a client
+const DeliveryHeader = "X-CaptainHook-Delivery"
+
+const (
+	// OutcomeDelivered means at least one receiver got the request
+	OutcomeDelivered = "delivered"
+	// OutcomeDropped means nobody was connected and the request was acknowledged anyway
+	OutcomeDropped = "dropped"
+	// OutcomeRejected means nobody was connected and the caller was asked to retry
+	OutcomeRejected = "rejected"
+	// OutcomeQueued means nobody was connected and the request waits for the client to connect
+	OutcomeQueued = "queued"
+	// OutcomeForbidden means the caller was not allowed by the ip filter
+	OutcomeForbidden = "forbidden"
+	// OutcomeLimited means the call exceeded a rate or size limit
+	OutcomeLimited = "limited"
+	// OutcomeFailed means the request could not be passed on
+	OutcomeFailed = "failed"
+)
+
+const (
+	// NoReceiverAccept acknowledges calls nobody receives and drops them
+	NoReceiverAccept = "accept"
+	// NoReceiverReject answers calls nobody receives with 503, so the provider retries
+	NoReceiverReject = "reject"
+	// NoReceiverQueue stores calls nobody receives until the client connects
+	NoReceiverQueue = "queue"
+)
+
+var deliverySequence uint32
+
+// NoReceiverPolicy defines what happens to a call if no receiver of the client is connected
+type NoReceiverPolicy struct {
+	// Action is one of accept, reject or queue. Defaults to accept
+	Action string `json:"action"`
+	// RetryAfter is sent to the caller in seconds if the call is rejected
+	RetryAfter int `json:"retryAfter"`
+}
+
+// Delivery records a single call of a hook and what happened to it. Request is the call as it was passed on,
+// Original the call as it was received if transforms changed it
+type Delivery struct {
+	ID         string         `json:"id"`
+	Hook       string         `json:"hook"`
+	Client     string         `json:"client"`
+	ReceivedAt time.Time      `json:"receivedAt"`
+	Method     string         `json:"method"`
+	Outcome    string         `json:"outcome"`
+	Receivers  int            `json:"receivers"`
+	Request    []byte         `json:"request,omitempty"`
+	Original   []byte         `json:"original,omitempty"`
+	Targets    []TargetResult `json:"targets,omitempty"`
+	Sinks      []SinkResult   `json:"sinks,omitempty"`
+	key        string
+	pushes     []*push
+	sinks      []*sinkRun
+}
+
+func newDelivery(w *Webhook, req *http.Request) *Delivery {
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Error(err)
+	}
+
+	now := time.Now()
+	seq := atomic.AddUint32(&deliverySequence, 1) % 1000000
+	return &Delivery{
+		ID:         id.String(),
+		Hook:       w.UUID,
+		Client:     w.client.Name,
+		ReceivedAt: now,
+		Method:     req.Method,
+		key:        fmt.Sprintf("%s%s%s%020d%06d", deliveryPrefix, w.UUID, delimeter, now.UnixNano(), seq),
+	}
+}
+
+// SetHookNoReceiverPolicy sets what happens to calls of the hook identified by identifier of the given client while nobody is connected
+func (s *Server) SetHookNoReceiverPolicy(clientname, identifier string, policy NoReceiverPolicy) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	switch policy.Action {
+	case "":
+		policy.Action = NoReceiverAccept
+	case NoReceiverAccept, NoReceiverReject, NoReceiverQueue:
+	default:
+		err = &ErrInvalidPolicy{Message: "unknown action '" + policy.Action + "'"}
+		log.Error(err)
+		return err
+	}
+	if policy.RetryAfter < 0 {
+		err = &ErrInvalidPolicy{Message: "retryAfter must not be negative"}
+		log.Error(err)
+		return err
+	}
+
+	s.mu.Lock()
+	hook.NoReceiver = policy
+	s.mu.Unlock()
+	return s.storeClient(hook.client)
+}
+
+// noReceiverPolicy returns the policy of the hook for calls nobody received
+func (s *Server) noReceiverPolicy(w *Webhook) NoReceiverPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return w.NoReceiver
+}
+
+// ConfigureDeliveries sets how many deliveries are kept per hook and how many requests may be queued per client
+func (s *Server) ConfigureDeliveries(retention, queueLimit int) {
+	s.deliveryRetention = retention
+	s.queueLimit = queueLimit
+}
+
+// GetDeliveries returns the newest deliveries of the hook identified by identifier of the given client, oldest first
+func (s *Server) GetDeliveries(clientname, identifier string, limit int, withRequest bool) ([]*Delivery, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.DB.Deliveries(hook.UUID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[len(deliveries)-limit:]
+	}
+	if !withRequest {
+		for _, d := range deliveries {
+			d.Request = nil
+			d.Original = nil
+		}
+	}
+
+	return deliveries, nil
+}
+
+// recordDelivery stores the delivery and removes the oldest ones of the hook beyond the retention
+func (s *Server) recordDelivery(d *Delivery) {
+	err := s.DB.StoreDelivery(d)
+	if err != nil {
+		log.Errorf("Could not store delivery: %s", err.Error())
+		return
+	}
+
+	err = s.DB.PruneDeliveries(d.Hook, s.deliveryRetention)
+	if err != nil {
+		log.Errorf("Could not prune deliveries: %s", err.Error())
+	}
+}
+
+// StoreDelivery stores or updates a delivery record
+func (db *DB) StoreDelivery(d *Delivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(d.key), b)
+	})
+}
+
+// Deliveries returns all delivery records of a hook, oldest first
+func (db *DB) Deliveries(hookUUID string) ([]*Delivery, error) {
+	deliveries := make([]*Delivery, 0)
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(deliveryPrefix + hookUUID + delimeter)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			d := new(Delivery)
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, d)
+			})
+			if err != nil {
+				return err
+			}
+			d.key = string(it.Item().KeyCopy(nil))
+			deliveries = append(deliveries, d)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// PruneDeliveries removes the oldest delivery records of a hook, so that at most retention records are left. 0 keeps everything
+func (db *DB) PruneDeliveries(hookUUID string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(deliveryPrefix + hookUUID + delimeter)
+		keys := make([][]byte, 0)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+
+		for i := 0; i < len(keys)-retention; i++ {
+			err := txn.Delete(keys[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteDeliveries removes all delivery records of a hook
+func (db *DB) DeleteDeliveries(hookUUID string) error {
+	return db.deletePrefix(deliveryPrefix + hookUUID + delimeter)
+}
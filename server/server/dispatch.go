@@ -0,0 +1,612 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gofrs/uuid"
+)
+
+// eventPrefix is the key prefix of published events, followed by the client ID
+const eventPrefix = delimeter + "Events" + delimeter
+
+// EventIDHeader contains the ID of an event posted to a subscriber. It stays the same when the event is retried or
+// redelivered, so subscribers can drop duplicates
+const EventIDHeader = "X-CaptainHook-Event-ID"
+
+// TopicHeader contains the topic of an event posted to a subscriber
+const TopicHeader = "X-CaptainHook-Topic"
+
+// OutcomeDeadLettered means a subscriber did not accept an event after all attempts. It can be redelivered
+const OutcomeDeadLettered = "deadlettered"
+
+var eventSequence uint32
+
+var topicPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+// Topic is a named stream of events a client publishes to its subscribers
+type Topic struct {
+	Name        string        `json:"name"`
+	Subscribers []*Subscriber `json:"subscribers"`
+}
+
+// Subscriber is a URL the events of a topic are posted to as they were published. Events are signed with the secret of
+// the subscriber and retried like calls pushed to targets, see Target and SignatureHeader. Format is not used
+type Subscriber struct {
+	Target
+	Description string `json:"description,omitempty"`
+}
+
+// Event is a message a client published to a topic and the log of its delivery to the subscribers
+type Event struct {
+	ID          string           `json:"id"`
+	Topic       string           `json:"topic"`
+	Client      string           `json:"client"`
+	PublishedAt time.Time        `json:"publishedAt"`
+	ContentType string           `json:"contentType,omitempty"`
+	Body        []byte           `json:"body"`
+	Results     []DispatchResult `json:"results"`
+	key         string
+	dispatches  []*dispatch
+}
+
+// DispatchResult records the delivery of an event to a subscriber. Outcome is pushing, delivered or deadlettered
+type DispatchResult struct {
+	Subscriber  string     `json:"subscriber"`
+	URL         string     `json:"url"`
+	Outcome     string     `json:"outcome"`
+	Status      int        `json:"status,omitempty"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+}
+
+// dispatch is an event on its way to a subscriber
+type dispatch struct {
+	event      *Event
+	subscriber *Subscriber
+	// attempts were made before, e.g. by the server before it restarted
+	attempts int
+}
+
+// ConfigureEvents sets how many published events are kept per client with their delivery logs. 0 keeps everything
+func (s *Server) ConfigureEvents(retention int) {
+	s.eventRetention = retention
+}
+
+// GetTopics returns the topics of the given client that have subscribers
+func (s *Server) GetTopics(clientname string) ([]*Topic, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	topics := make([]*Topic, 0, len(client.Topics))
+	for _, t := range client.Topics {
+		topics = append(topics, t)
+	}
+	return topics, nil
+}
+
+// GetSubscribers returns the subscribers of a topic of the given client
+func (s *Server) GetSubscribers(clientname, topic string) ([]*Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	if client.Topics[topic] == nil {
+		return make([]*Subscriber, 0), nil
+	}
+	return append(make([]*Subscriber, 0, len(client.Topics[topic].Subscribers)), client.Topics[topic].Subscribers...), nil
+}
+
+// AddSubscriber subscribes a URL to a topic of the given client and returns it with its secret. The topic is created
+// with its first subscriber
+func (s *Server) AddSubscriber(clientname, topic string, subscriber Subscriber) (*Subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	if !topicPattern.MatchString(topic) {
+		err := &ErrInvalidTopic{Name: topic}
+		log.Error(err)
+		return nil, err
+	}
+
+	if subscriber.Format != "" {
+		err := &ErrInvalidTarget{Message: "subscribers get events as they were published, format is not used"}
+		log.Error(err)
+		return nil, err
+	}
+
+	err := subscriber.prepare(s.dialer())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Error(err)
+		return nil, &ErrCreatingUUID{Message: err.Error()}
+	}
+	subscriber.ID = id.String()
+
+	err = subscriber.generateSecret()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if client.Topics == nil {
+		client.Topics = make(map[string]*Topic)
+	}
+	if client.Topics[topic] == nil {
+		client.Topics[topic] = &Topic{Name: topic}
+	}
+	client.Topics[topic].Subscribers = append(client.Topics[topic].Subscribers, &subscriber)
+	return &subscriber, s.DB.Store(client)
+}
+
+// RemoveSubscriber removes the subscriber identified by id from a topic of the given client. The topic is removed with
+// its last subscriber, its events are kept
+func (s *Server) RemoveSubscriber(clientname, topic, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return err
+	}
+
+	if t := client.Topics[topic]; t != nil {
+		for i, sub := range t.Subscribers {
+			if sub.ID != id {
+				continue
+			}
+			t.Subscribers = append(t.Subscribers[:i], t.Subscribers[i+1:]...)
+			if len(t.Subscribers) == 0 {
+				delete(client.Topics, topic)
+			}
+			return s.DB.Store(client)
+		}
+	}
+
+	err := &ErrSubscriberNotExists{ID: id}
+	log.Error(err)
+	return err
+}
+
+// prepareSubscribers sets up the subscribers of all clients after loading them. Subscribers that became invalid are
+// dropped. The caller holds s.mu
+func (s *Server) prepareSubscribers() {
+	for _, c := range s.Clients {
+		for name, t := range c.Topics {
+			subscribers := make([]*Subscriber, 0, len(t.Subscribers))
+			for _, sub := range t.Subscribers {
+				err := sub.prepare(s.dialer())
+				if err != nil {
+					log.Warnf("Dropping subscriber %s of %s of %s: %s", sub.ID, name, c.Name, err.Error())
+					continue
+				}
+				subscribers = append(subscribers, sub)
+			}
+			t.Subscribers = subscribers
+		}
+	}
+}
+
+// Publish stores an event of the given client and posts it to the subscribers of the topic in the background. Events
+// without subscribers are only stored. The body may not exceed the global MaxBodySize
+func (s *Server) Publish(clientname, topic, contentType string, r io.Reader) (*Event, error) {
+	client, err := s.getClient(clientname)
+	if err != nil {
+		return nil, err
+	}
+
+	if !topicPattern.MatchString(topic) {
+		err := &ErrInvalidTopic{Name: topic}
+		log.Error(err)
+		return nil, err
+	}
+
+	if maxBody := s.limiter.getLimits().MaxBodySize; maxBody > 0 {
+		r = &limitedBody{ReadCloser: ioutil.NopCloser(r), remaining: maxBody, limit: maxBody}
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Error(err)
+		return nil, &ErrCreatingUUID{Message: err.Error()}
+	}
+
+	now := time.Now()
+	seq := atomic.AddUint32(&eventSequence, 1) % 1000000
+	event := &Event{
+		ID:          id.String(),
+		Topic:       topic,
+		Client:      client.Name,
+		PublishedAt: now,
+		ContentType: contentType,
+		Body:        body,
+		Results:     make([]DispatchResult, 0),
+		key:         fmt.Sprintf("%s%s%s%020d%06d", eventPrefix, client.ID, delimeter, now.UnixNano(), seq),
+	}
+	s.mu.RLock()
+	if t := client.Topics[topic]; t != nil {
+		for _, sub := range t.Subscribers {
+			event.Results = append(event.Results, DispatchResult{Subscriber: sub.ID, URL: sub.URL, Outcome: OutcomePushing})
+			event.dispatches = append(event.dispatches, &dispatch{event: event, subscriber: sub})
+		}
+	}
+	s.mu.RUnlock()
+
+	err = s.DB.StoreEvent(event)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	err = s.DB.PruneEvents(client.ID, s.eventRetention)
+	if err != nil {
+		log.Errorf("Could not prune events: %s", err.Error())
+	}
+
+	s.startDispatches(event.dispatches)
+	return event, nil
+}
+
+// GetEvents returns the events the given client published to a topic, oldest first. If deadLettered is set, only
+// events a subscriber did not accept after all attempts are returned
+func (s *Server) GetEvents(clientname, topic string, deadLettered bool) ([]*Event, error) {
+	client, err := s.getClient(clientname)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.DB.Events(client.ID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	matching := make([]*Event, 0)
+	for _, e := range events {
+		if e.Topic == topic && (!deadLettered || e.deadLettered()) {
+			matching = append(matching, e)
+		}
+	}
+	return matching, nil
+}
+
+// GetEvent returns the event identified by id the given client published to a topic
+func (s *Server) GetEvent(clientname, topic, id string) (*Event, error) {
+	events, err := s.GetEvents(clientname, topic, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+
+	err = &ErrEventNotExists{ID: id}
+	log.Error(err)
+	return nil, err
+}
+
+// Redeliver posts the event identified by id again to the subscribers that did not accept it after all attempts. They
+// get all attempts again. Subscribers that were removed in the meantime are skipped
+func (s *Server) Redeliver(clientname, topic, id string) (*Event, error) {
+	event, err := s.GetEvent(clientname, topic, id)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.getClient(clientname)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	for i, r := range event.Results {
+		if r.Outcome != OutcomeDeadLettered {
+			continue
+		}
+		sub := client.subscriber(topic, r.Subscriber)
+		if sub == nil {
+			continue
+		}
+		event.Results[i] = DispatchResult{Subscriber: sub.ID, URL: sub.URL, Outcome: OutcomePushing}
+		event.dispatches = append(event.dispatches, &dispatch{event: event, subscriber: sub})
+	}
+	s.mu.RUnlock()
+
+	if len(event.dispatches) == 0 {
+		return event, nil
+	}
+	err = s.DB.StoreEvent(event)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	s.startDispatches(event.dispatches)
+	return event, nil
+}
+
+// subscriber returns the subscriber of a topic identified by id, or nil. The caller holds the lock of the server
+func (c *Client) subscriber(topic, id string) *Subscriber {
+	if c.Topics[topic] == nil {
+		return nil
+	}
+	for _, sub := range c.Topics[topic].Subscribers {
+		if sub.ID == id {
+			return sub
+		}
+	}
+	return nil
+}
+
+// deadLettered tells whether a subscriber did not accept the event after all attempts
+func (e *Event) deadLettered() bool {
+	for _, r := range e.Results {
+		if r.Outcome == OutcomeDeadLettered {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeDispatches continues posting the events that were on their way to subscribers when the server stopped, with
+// the attempts that are left. The caller holds s.mu
+func (s *Server) resumeDispatches() {
+	for _, c := range s.Clients {
+		events, err := s.DB.Events(c.ID)
+		if err != nil {
+			log.Errorf("Could not read events of %s: %s", c.Name, err.Error())
+			continue
+		}
+
+		for _, e := range events {
+			for _, r := range e.Results {
+				if r.Outcome != OutcomePushing {
+					continue
+				}
+				sub := c.subscriber(e.Topic, r.Subscriber)
+				if sub == nil {
+					// the subscriber is gone, the result stays as it was
+					continue
+				}
+				e.dispatches = append(e.dispatches, &dispatch{event: e, subscriber: sub, attempts: r.Attempts})
+			}
+			s.startDispatches(e.dispatches)
+		}
+	}
+}
+
+// startDispatches posts events to their subscribers in the background
+func (s *Server) startDispatches(dispatches []*dispatch) {
+	for _, d := range dispatches {
+		go s.dispatch(d)
+	}
+}
+
+// dispatch posts an event to a subscriber until it is accepted or the attempts are used up, recording every attempt.
+// Events that were not accepted are dead-lettered
+func (s *Server) dispatch(d *dispatch) {
+	sub := d.subscriber
+	result := DispatchResult{Subscriber: sub.ID, URL: sub.URL, Outcome: OutcomePushing, Attempts: d.attempts}
+	backoff := time.Duration(sub.Backoff) * time.Second
+	for i := 1; i < d.attempts; i++ {
+		backoff *= 2
+	}
+
+	for result.Outcome == OutcomePushing {
+		if result.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		result.Attempts++
+		now := time.Now()
+		result.LastAttempt = &now
+
+		status, err := d.send()
+		result.Status = status
+		result.Error = ""
+		if err == nil && status >= 200 && status < 300 {
+			result.Outcome = OutcomeDelivered
+		} else {
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Error = http.StatusText(status)
+			}
+			log.Warnf("Event %s of %s to %s failed (attempt %d of %d): %s", d.event.ID, d.event.Topic, sub.URL, result.Attempts, sub.Attempts, result.Error)
+
+			// the subscriber refused the event, trying again won't change that
+			if result.Attempts >= sub.Attempts || (err == nil && refused(status)) {
+				result.Outcome = OutcomeDeadLettered
+			}
+		}
+
+		err = s.DB.UpdateEvent(d.event.key, func(e *Event) {
+			e.record(result)
+		})
+		if err != nil {
+			log.Errorf("Could not update event: %s", err.Error())
+		}
+	}
+}
+
+// record replaces the result of the subscriber with a newer one
+func (e *Event) record(result DispatchResult) {
+	for i := range e.Results {
+		if e.Results[i].Subscriber == result.Subscriber {
+			e.Results[i] = result
+		}
+	}
+}
+
+// send makes a single attempt to post the event and returns the status the subscriber answered with
+func (d *dispatch) send() (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.subscriber.URL, bytes.NewReader(d.event.Body))
+	if err != nil {
+		return 0, err
+	}
+	if d.event.ContentType != "" {
+		req.Header.Set("Content-Type", d.event.ContentType)
+	}
+	req.Header.Set(EventIDHeader, d.event.ID)
+	req.Header.Set(TopicHeader, d.event.Topic)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(d.subscriber.Secret, timestamp, d.event.Body))
+
+	resp, err := d.subscriber.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// StoreEvent stores or updates an event
+func (db *DB) StoreEvent(e *Event) error {
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		return setJSON(txn, e.key, e)
+	})
+}
+
+// UpdateEvent changes the event stored at key
+func (db *DB) UpdateEvent(key string, update func(e *Event)) error {
+	err := db.updateEvent(key, update)
+	// dispatches to several subscribers finish at the same time
+	for i := 0; err == badger.ErrConflict && i < updateRetries; i++ {
+		err = db.updateEvent(key, update)
+	}
+	return err
+}
+
+func (db *DB) updateEvent(key string, update func(e *Event)) error {
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err == badger.ErrKeyNotFound {
+			// the event might be pruned already
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		e := new(Event)
+		err = item.Value(func(v []byte) error {
+			return json.Unmarshal(v, e)
+		})
+		if err != nil {
+			return err
+		}
+
+		update(e)
+		return setJSON(txn, key, e)
+	})
+}
+
+// Events returns all events of a client, oldest first
+func (db *DB) Events(clientID string) ([]*Event, error) {
+	events := make([]*Event, 0)
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(eventPrefix + clientID + delimeter)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			e := new(Event)
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, e)
+			})
+			if err != nil {
+				return err
+			}
+			e.key = string(it.Item().KeyCopy(nil))
+			events = append(events, e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// PruneEvents removes the oldest events of a client, so that at most retention events are left. 0 keeps everything
+func (db *DB) PruneEvents(clientID string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(eventPrefix + clientID + delimeter)
+		keys := make([][]byte, 0)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+
+		for i := 0; i < len(keys)-retention; i++ {
+			err := txn.Delete(keys[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteEvents removes all events of a client
+func (db *DB) DeleteEvents(clientID string) error {
+	return db.deletePrefix(eventPrefix + clientID + delimeter)
+}
@@ -6,7 +6,10 @@ It is licensed under the MIT License
 
 package server
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+)
 
 // ErrClientAlreadyExists occurs if someone tries to add a client with an existing name
 type ErrClientAlreadyExists struct {
@@ -106,3 +109,316 @@ type ErrUnknownServerError struct {
 func (e *ErrUnknownServerError) Error() string {
 	return "Server responded with error: " + e.Message
 }
+
+// ErrInvalidCertificate occurs if a configured certificate or CA bundle can not be used
+type ErrInvalidCertificate struct {
+	Message string
+}
+
+func (e *ErrInvalidCertificate) Error() string {
+	return "Invalid certificate: " + e.Message
+}
+
+// ErrInvalidNetwork occurs if an address or CIDR can not be parsed
+type ErrInvalidNetwork struct {
+	Network string
+}
+
+func (e *ErrInvalidNetwork) Error() string {
+	return "'" + e.Network + "' is neither a valid address nor CIDR"
+}
+
+// ErrUnknownPreset occurs if an ip filter references a preset that is not loaded
+type ErrUnknownPreset struct {
+	Name string
+}
+
+func (e *ErrUnknownPreset) Error() string {
+	return "Preset '" + e.Name + "' does not exist"
+}
+
+// ErrIPNotAllowed occurs if a hook is called from an address that is not allowed by its ip filter
+type ErrIPNotAllowed struct {
+	IP         string
+	Identifier string
+}
+
+func (e *ErrIPNotAllowed) Error() string {
+	return "Address '" + e.IP + "' is not allowed to call hook '" + e.Identifier + "'"
+}
+
+// ErrRequestTooLarge occurs if the body of a hook call exceeds the configured size
+type ErrRequestTooLarge struct {
+	Limit int64
+}
+
+func (e *ErrRequestTooLarge) Error() string {
+	return "Request body exceeds the limit of " + strconv.FormatInt(e.Limit, 10) + " bytes"
+}
+
+// ErrRateLimited occurs if a hook is called more often than allowed
+type ErrRateLimited struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	return "Rate limit exceeded, retry after " + e.RetryAfter.String()
+}
+
+// ErrTooManyInFlight occurs if too many calls are handled at the same time
+type ErrTooManyInFlight struct {
+	Limit int
+}
+
+func (e *ErrTooManyInFlight) Error() string {
+	return "More than " + strconv.Itoa(e.Limit) + " requests in flight"
+}
+
+// ErrInvalidLimits occurs if someone tries to set invalid limits
+type ErrInvalidLimits struct {
+	Message string
+}
+
+func (e *ErrInvalidLimits) Error() string {
+	return "Invalid limits: " + e.Message
+}
+
+// ErrInvalidBaseURL occurs if the configured public base URL can't be used to generate hook URLs
+type ErrInvalidBaseURL struct {
+	Message string
+}
+
+func (e *ErrInvalidBaseURL) Error() string {
+	return "Invalid public base URL: " + e.Message
+}
+
+// ErrInvalidSlug occurs if someone tries to set a slug that can't be used as path
+type ErrInvalidSlug struct {
+	Slug    string
+	Message string
+}
+
+func (e *ErrInvalidSlug) Error() string {
+	return "Slug '" + e.Slug + "' is invalid: " + e.Message
+}
+
+// ErrSlugAlreadyExists occurs if someone tries to set a slug that is already used by another hook
+type ErrSlugAlreadyExists struct {
+	Slug string
+}
+
+func (e *ErrSlugAlreadyExists) Error() string {
+	return "Slug '" + e.Slug + "' is already in use"
+}
+
+// ErrInvalidResponse occurs if someone tries to set a hook response that can't be sent
+type ErrInvalidResponse struct {
+	Message string
+}
+
+func (e *ErrInvalidResponse) Error() string {
+	return "Invalid response: " + e.Message
+}
+
+// ErrInvalidPolicy occurs if someone tries to set a policy for calls without receiver that doesn't exist
+type ErrInvalidPolicy struct {
+	Message string
+}
+
+func (e *ErrInvalidPolicy) Error() string {
+	return "Invalid policy: " + e.Message
+}
+
+// ErrNoReceiver occurs if nobody received a call and the caller should retry later
+type ErrNoReceiver struct {
+	Client     string
+	RetryAfter time.Duration
+}
+
+func (e *ErrNoReceiver) Error() string {
+	return "No receiver of client '" + e.Client + "' is connected"
+}
+
+// ErrQueueFull occurs if a call can't be queued because the queue of the client is full
+type ErrQueueFull struct {
+	Client string
+	Limit  int
+}
+
+func (e *ErrQueueFull) Error() string {
+	return "Queue of client '" + e.Client + "' is full (" + strconv.Itoa(e.Limit) + " requests)"
+}
+
+// ErrInvalidMetadata occurs if someone tries to set a description, label or provider that is not allowed
+type ErrInvalidMetadata struct {
+	Message string
+}
+
+func (e *ErrInvalidMetadata) Error() string {
+	return "Invalid metadata: " + e.Message
+}
+
+// ErrHookPaused occurs if a paused hook is called that rejects calls
+type ErrHookPaused struct {
+	Identifier string
+	Status     int
+}
+
+func (e *ErrHookPaused) Error() string {
+	return "Hook '" + e.Identifier + "' is paused"
+}
+
+// ErrInvalidExpiry occurs if someone tries to create a hook with an expiry that can't be used
+type ErrInvalidExpiry struct {
+	Message string
+}
+
+func (e *ErrInvalidExpiry) Error() string {
+	return "Invalid expiry: " + e.Message
+}
+
+// ErrInvalidSubscriber occurs if a hook is shared with the client it belongs to
+type ErrInvalidSubscriber struct {
+	Client string
+}
+
+func (e *ErrInvalidSubscriber) Error() string {
+	return "The hook already belongs to client '" + e.Client + "'"
+}
+
+// ErrInvalidFilter occurs if someone tries to set a filter rule that can't be evaluated
+type ErrInvalidFilter struct {
+	Message string
+}
+
+func (e *ErrInvalidFilter) Error() string {
+	return "Invalid filter: " + e.Message
+}
+
+// ErrInvalidTransform occurs if someone tries to set a transform that can't be applied
+type ErrInvalidTransform struct {
+	Message string
+}
+
+func (e *ErrInvalidTransform) Error() string {
+	return "Invalid transform: " + e.Message
+}
+
+// ErrInvalidOutput occurs if someone tries to set an output format that doesn't exist
+type ErrInvalidOutput struct {
+	Message string
+}
+
+func (e *ErrInvalidOutput) Error() string {
+	return "Invalid output: " + e.Message
+}
+
+// ErrInvalidTarget occurs if someone tries to add a push target calls can't be sent to
+type ErrInvalidTarget struct {
+	Message string
+}
+
+func (e *ErrInvalidTarget) Error() string {
+	return "Invalid target: " + e.Message
+}
+
+// ErrForbiddenAddress occurs if a request to an address a client chose would reach this host or a private network
+type ErrForbiddenAddress struct {
+	Address string
+}
+
+func (e *ErrForbiddenAddress) Error() string {
+	return "Address '" + e.Address + "' is not allowed, it belongs to this host or a private network"
+}
+
+// ErrTargetNotExists occurs if a client has no push target with the given ID
+type ErrTargetNotExists struct {
+	ID string
+}
+
+func (e *ErrTargetNotExists) Error() string {
+	return "Target '" + e.ID + "' does not exist"
+}
+
+// ErrInvalidTopic occurs if someone tries to publish or subscribe to a topic with an invalid name
+type ErrInvalidTopic struct {
+	Name string
+}
+
+func (e *ErrInvalidTopic) Error() string {
+	return "Invalid topic '" + e.Name + "': use up to 128 letters, digits, '.', '_', ':' or '-'"
+}
+
+// ErrSubscriberNotExists occurs if a topic has no subscriber with the given ID
+type ErrSubscriberNotExists struct {
+	ID string
+}
+
+func (e *ErrSubscriberNotExists) Error() string {
+	return "Subscriber '" + e.ID + "' does not exist"
+}
+
+// ErrEventNotExists occurs if a topic has no event with the given ID
+type ErrEventNotExists struct {
+	ID string
+}
+
+func (e *ErrEventNotExists) Error() string {
+	return "Event '" + e.ID + "' does not exist"
+}
+
+// ErrInvalidWebSub occurs if someone tries to subscribe a hook to a WebSub topic that can't be subscribed to, or a hub
+// verifies a subscription with invalid parameters
+type ErrInvalidWebSub struct {
+	Message string
+}
+
+func (e *ErrInvalidWebSub) Error() string {
+	return "Invalid WebSub subscription: " + e.Message
+}
+
+// ErrWebSubNotExists occurs if a hook has no WebSub subscription, or not the one a hub asks for
+type ErrWebSubNotExists struct {
+	Identifier string
+}
+
+func (e *ErrWebSubNotExists) Error() string {
+	return "Hook '" + e.Identifier + "' has no such WebSub subscription"
+}
+
+// ErrHubFailed occurs if a WebSub hub does not accept a subscription request
+type ErrHubFailed struct {
+	Hub     string
+	Message string
+}
+
+func (e *ErrHubFailed) Error() string {
+	return "Hub '" + e.Hub + "' failed: " + e.Message
+}
+
+// ErrInvalidSink occurs if someone tries to set a sink of a hook or client calls can't be passed on to
+type ErrInvalidSink struct {
+	Message string
+}
+
+func (e *ErrInvalidSink) Error() string {
+	return "Invalid sink: " + e.Message
+}
+
+// ErrInvalidWait occurs if a poll asks to wait for something that is no duration
+type ErrInvalidWait struct {
+	Wait string
+}
+
+func (e *ErrInvalidWait) Error() string {
+	return "Invalid wait '" + e.Wait + "', use a duration like 30s"
+}
+
+// ErrDeliveryNotExists occurs if a delivery is not recorded (anymore) or has no stored request
+type ErrDeliveryNotExists struct {
+	ID string
+}
+
+func (e *ErrDeliveryNotExists) Error() string {
+	return "Delivery '" + e.ID + "' does not exist"
+}
@@ -0,0 +1,234 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// EventHeader marks requests that don't come from a provider but inform the client about one of its hooks
+const EventHeader = "X-CaptainHook-Event"
+
+// EventHookExpired is sent to the clients connections when one of its hooks expired and was removed
+const EventHookExpired = "hook.expired"
+
+const (
+	// ExpiryReasonTime means the hook reached its expiry time
+	ExpiryReasonTime = "expired"
+	// ExpiryReasonMaxCalls means the hook was called as often as allowed
+	ExpiryReasonMaxCalls = "maxCalls"
+)
+
+// HookOptions are the settings of a new hook. Besides its metadata a hook can expire after a TTL, at a given time
+// or after it was called MaxCalls times
+type HookOptions struct {
+	HookMetadata
+	// TTL is the lifetime of the hook as duration like 10m or 2h
+	TTL string `json:"ttl"`
+	// ExpiresAt is the time the hook is removed, it can't be combined with TTL
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// MaxCalls is the number of calls after which the hook is removed. 0 means unlimited
+	MaxCalls int `json:"maxCalls"`
+}
+
+// HookEvent is the body of requests sent with the EventHeader
+type HookEvent struct {
+	Event      string    `json:"event"`
+	Identifier string    `json:"identifier"`
+	UUID       string    `json:"uuid"`
+	Reason     string    `json:"reason"`
+	Time       time.Time `json:"time"`
+}
+
+// expiry validates the expiry options and returns the expiry time, which is nil if the hook does not expire by time
+func (o *HookOptions) expiry(now time.Time) (*time.Time, error) {
+	if o.MaxCalls < 0 {
+		return nil, &ErrInvalidExpiry{Message: "maxCalls must not be negative"}
+	}
+
+	if o.TTL != "" && o.ExpiresAt != nil {
+		return nil, &ErrInvalidExpiry{Message: "either ttl or expiresAt can be set"}
+	}
+
+	if o.TTL != "" {
+		ttl, err := time.ParseDuration(o.TTL)
+		if err != nil {
+			return nil, &ErrInvalidExpiry{Message: err.Error()}
+		}
+		if ttl <= 0 {
+			return nil, &ErrInvalidExpiry{Message: "ttl has to be positive"}
+		}
+		expiresAt := now.Add(ttl)
+		return &expiresAt, nil
+	}
+
+	if o.ExpiresAt != nil && !o.ExpiresAt.After(now) {
+		return nil, &ErrInvalidExpiry{Message: "expiresAt is in the past"}
+	}
+
+	return o.ExpiresAt, nil
+}
+
+// expired returns the reason the hook expired or an empty string if it is still valid
+func (w *Webhook) expired(now time.Time) string {
+	w.callsMu.Lock()
+	defer w.callsMu.Unlock()
+	if w.ExpiresAt != nil && !now.Before(*w.ExpiresAt) {
+		return ExpiryReasonTime
+	}
+	if w.MaxCalls > 0 && w.Calls >= w.MaxCalls {
+		return ExpiryReasonMaxCalls
+	}
+	return ""
+}
+
+// reserveCall takes one of the calls of the hook before a call is passed on, so calls arriving at the same time can't
+// exceed MaxCalls. It is false if the hook expired or all its calls are taken. See finishCall
+func (w *Webhook) reserveCall(now time.Time) bool {
+	w.callsMu.Lock()
+	defer w.callsMu.Unlock()
+	if w.ExpiresAt != nil && !now.Before(*w.ExpiresAt) {
+		return false
+	}
+	if w.MaxCalls > 0 && w.Calls+w.reserved >= w.MaxCalls {
+		return false
+	}
+	w.reserved++
+	return true
+}
+
+// finishCall counts a reserved call if it was accepted and gives it back otherwise
+func (w *Webhook) finishCall(accepted bool) {
+	w.callsMu.Lock()
+	defer w.callsMu.Unlock()
+	w.reserved--
+	if accepted {
+		w.Calls++
+	}
+}
+
+// calls returns how often the hook was called
+func (w *Webhook) calls() int {
+	w.callsMu.Lock()
+	defer w.callsMu.Unlock()
+	return w.Calls
+}
+
+// StartReaper removes expired hooks and renews the WebSub subscriptions whose lease ends soon every interval in the
+// background. An interval of 0 disables the reaper, expired hooks are still not reachable anymore
+func (s *Server) StartReaper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.reapExpiredHooks(now)
+			s.renewWebSubs(now)
+		}
+	}()
+}
+
+// reapExpiredHooks removes all hooks that expired before now
+func (s *Server) reapExpiredHooks(now time.Time) {
+	expired := make(map[*Webhook]string)
+	s.mu.RLock()
+	for _, c := range s.Clients {
+		for _, h := range c.Hooks {
+			if reason := h.expired(now); reason != "" {
+				expired[h] = reason
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for h, reason := range expired {
+		s.expireHook(h, reason)
+	}
+}
+
+// expireExhausted removes the hook if it was called as often as allowed
+func (s *Server) expireExhausted(hook *Webhook) {
+	if reason := hook.expired(time.Now()); reason == ExpiryReasonMaxCalls {
+		s.expireHook(hook, reason)
+	}
+}
+
+// expireHook removes an expired hook and tells the connected receivers of its client
+func (s *Server) expireHook(hook *Webhook, reason string) {
+	s.mu.Lock()
+	if hook.client.Hooks[hook.Identifier] != hook {
+		// removed in the meantime
+		s.mu.Unlock()
+		return
+	}
+
+	log.Infof("Hook %s of %s expired (%s)", hook.Identifier, hook.client.Name, reason)
+
+	err := s.removeHook(hook)
+	s.mu.Unlock()
+	if err != nil {
+		log.Errorf("Could not remove expired hook: %s", err.Error())
+		return
+	}
+
+	s.notify(hook, &HookEvent{
+		Event:      EventHookExpired,
+		Identifier: hook.Identifier,
+		UUID:       hook.UUID,
+		Reason:     reason,
+		Time:       time.Now(),
+	})
+}
+
+// removeHook makes the hook unreachable and removes it with everything stored for it from its client and the database.
+// The caller holds s.mu
+func (s *Server) removeHook(hook *Webhook) error {
+	s.removeSinks(hook.Sinks)
+	s.unindexHook(hook)
+	delete(hook.client.Hooks, hook.Identifier)
+
+	return s.DB.DeleteHook(hook.client.ID, hook.Identifier, hook.UUID)
+}
+
+// notify sends an event about a hook to the connected receivers of its clients. Clients receive it like a call of the hook
+// with the EventHeader set
+func (s *Server) notify(hook *Webhook, event *HookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Event)
+
+	var b bytes.Buffer
+	writer := bufio.NewWriter(&b)
+	err = req.WriteProxy(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	_, pushes, _ := hook.broadcast(b.Bytes(), "")
+	s.startPushes(pushes)
+}
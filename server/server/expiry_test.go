@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	tables := []struct {
+		expiresAt *time.Time
+		maxCalls  int
+		calls     int
+		reason    string
+	}{
+		{nil, 0, 0, ""},
+		{nil, 0, 100, ""},
+		{&future, 0, 0, ""},
+		{&past, 0, 0, ExpiryReasonTime},
+		{&now, 0, 0, ExpiryReasonTime},
+		{nil, 1, 0, ""},
+		{nil, 1, 1, ExpiryReasonMaxCalls},
+		{nil, 3, 2, ""},
+		{nil, 3, 4, ExpiryReasonMaxCalls},
+		{&past, 1, 1, ExpiryReasonTime},
+	}
+
+	for _, table := range tables {
+		hook := &Webhook{ExpiresAt: table.expiresAt, MaxCalls: table.maxCalls, Calls: table.calls}
+		if reason := hook.expired(now); reason != table.reason {
+			t.Errorf("hook expiring at %v after %d of %d calls: got reason '%s', want '%s'", table.expiresAt, table.calls, table.maxCalls, reason, table.reason)
+		}
+	}
+}
+
+func TestWebhookReserveCall(t *testing.T) {
+	hook := &Webhook{MaxCalls: 2}
+	now := time.Now()
+
+	if !hook.reserveCall(now) || !hook.reserveCall(now) {
+		t.Fatal("could not reserve the calls of the hook")
+	}
+	if hook.reserveCall(now) {
+		t.Error("reserved more calls than allowed")
+	}
+
+	// a call that was not accepted is given back
+	hook.finishCall(false)
+	if hook.expired(now) != "" {
+		t.Error("a call that was given back counts")
+	}
+	if !hook.reserveCall(now) {
+		t.Error("a call that was given back can't be reserved again")
+	}
+
+	hook.finishCall(true)
+	hook.finishCall(true)
+	if hook.calls() != 2 || hook.expired(now) != ExpiryReasonMaxCalls {
+		t.Errorf("got %d calls, want 2 and the hook expired", hook.calls())
+	}
+}
+
+// TestOneShotHook checks that concurrent calls of a hook with MaxCalls 1 are passed on only once
+func TestOneShotHook(t *testing.T) {
+	s := testServer(t.TempDir())
+	defer s.Stop()
+
+	_, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := s.AddHook("testclient", "test", HookOptions{MaxCalls: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	accepted := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPost, "/"+hook.UUID, strings.NewReader(`{}`))
+			req.RemoteAddr = "127.0.0.1:49152"
+			_, err := s.HandleHook(hook.UUID, req)
+			var notExists *ErrHookNotExists
+			if err != nil && !errors.As(err, &notExists) {
+				t.Errorf("Error calling hook: %s", err.Error())
+			}
+			if err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("%d calls were accepted, want 1", accepted)
+	}
+	if hook.calls() != 1 {
+		t.Errorf("got %d calls, want 1", hook.calls())
+	}
+	if _, err = s.getHook("testclient", "test"); err == nil {
+		t.Error("the hook was not removed after its call")
+	}
+}
+
+// TestRejectedCallsDontCount checks that calls that were not passed on leave the calls of a one-shot hook
+func TestRejectedCallsDontCount(t *testing.T) {
+	s := testServer(t.TempDir())
+	defer s.Stop()
+
+	_, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := s.AddHook("testclient", "test", HookOptions{MaxCalls: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetHookFilter("testclient", "test", &Filter{Rules: []FilterRule{{Source: FilterSourceMethod, Values: []string{http.MethodPut}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		method string
+		calls  int
+	}{
+		{http.MethodPost, 0},
+		{http.MethodPost, 0},
+		{http.MethodPut, 1},
+	}
+
+	for _, table := range tables {
+		req, _ := http.NewRequest(table.method, "/"+hook.UUID, nil)
+		req.RemoteAddr = "127.0.0.1:49152"
+		_, err = s.HandleHook(hook.UUID, req)
+		if err != nil {
+			t.Errorf("Error calling hook with %s: %s", table.method, err.Error())
+		}
+		if hook.calls() != table.calls {
+			t.Errorf("after a %s call: got %d calls, want %d", table.method, hook.calls(), table.calls)
+		}
+	}
+}
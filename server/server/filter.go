@@ -0,0 +1,306 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FilterSourceMethod matches the http method of a call
+	FilterSourceMethod = "method"
+	// FilterSourceHeader matches a header of a call, e.g. X-GitHub-Event
+	FilterSourceHeader = "header"
+	// FilterSourceQuery matches a query parameter of a call
+	FilterSourceQuery = "query"
+	// FilterSourceBody matches a field of a JSON body of a call
+	FilterSourceBody = "body"
+)
+
+const (
+	// FilterMatchAll forwards calls that match all rules
+	FilterMatchAll = "all"
+	// FilterMatchAny forwards calls that match at least one rule
+	FilterMatchAny = "any"
+)
+
+// OutcomeFiltered means the call didn't match the filter of the hook. The caller got a response but it was not passed on
+const OutcomeFiltered = "filtered"
+
+// Filter decides which calls of a hook are passed on to its clients. Calls that don't match are answered as usual
+// but never forwarded
+type Filter struct {
+	// Match is all or any. Defaults to all
+	Match string       `json:"match"`
+	Rules []FilterRule `json:"rules"`
+}
+
+// FilterRule matches a single part of a call
+type FilterRule struct {
+	// Source is method, header, query or body
+	Source string `json:"source"`
+	// Name is the header, the query parameter or the path of a body field like $.repository.name or commits[0].id
+	Name string `json:"name,omitempty"`
+	// Values are patterns like push or refs/heads/* the value has to match one of. Without values the rule matches
+	// if the header, parameter or field exists
+	Values []string `json:"values,omitempty"`
+	// Negate matches calls the rule does not match
+	Negate bool `json:"negate,omitempty"`
+}
+
+// SetHookFilter replaces the filter of the webhook identified by identifier of the given client. nil forwards all calls
+func (s *Server) SetHookFilter(clientname, identifier string, filter *Filter) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	if filter != nil {
+		if filter.Match == "" {
+			filter.Match = FilterMatchAll
+		}
+		if err = validateFilter(filter); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	hook.Filter = filter
+	return s.storeClient(hook.client)
+}
+
+// applyFilter tells whether a call of the hook is passed on and counts the result
+func (s *Server) applyFilter(hook *Webhook, d *Delivery, req *http.Request) (bool, error) {
+	if hook.Filter == nil {
+		return true, nil
+	}
+
+	matched, err := hook.Filter.matches(req)
+	if err != nil {
+		return false, err
+	}
+
+	s.countFilter(hook, matched)
+	if !matched {
+		d.Outcome = OutcomeFiltered
+	}
+	return matched, nil
+}
+
+func validateFilter(f *Filter) error {
+	if f.Match != FilterMatchAll && f.Match != FilterMatchAny {
+		return &ErrInvalidFilter{Message: "match has to be all or any"}
+	}
+
+	for _, r := range f.Rules {
+		switch r.Source {
+		case FilterSourceMethod:
+			if len(r.Values) == 0 {
+				return &ErrInvalidFilter{Message: "a method rule needs values"}
+			}
+		case FilterSourceHeader, FilterSourceQuery:
+			if r.Name == "" {
+				return &ErrInvalidFilter{Message: "a " + r.Source + " rule needs a name"}
+			}
+		case FilterSourceBody:
+			if _, err := parseFieldPath(r.Name); err != nil {
+				return err
+			}
+		default:
+			return &ErrInvalidFilter{Message: "unknown source '" + r.Source + "'"}
+		}
+
+		for _, v := range r.Values {
+			if _, err := path.Match(v, ""); err != nil {
+				return &ErrInvalidFilter{Message: "'" + v + "' is not a valid pattern"}
+			}
+		}
+	}
+
+	return nil
+}
+
+// matches tells whether a call passes the filter. The body is read if a rule needs it and restored afterwards
+func (f *Filter) matches(req *http.Request) (bool, error) {
+	var body interface{}
+	bodyRead := false
+
+	for _, r := range f.Rules {
+		if r.Source == FilterSourceBody && !bodyRead {
+			var err error
+			body, err = readJSONBody(req)
+			if err != nil {
+				return false, err
+			}
+			bodyRead = true
+		}
+
+		matched := r.matches(req, body) != r.Negate
+		if matched && f.Match == FilterMatchAny {
+			return true, nil
+		}
+		if !matched && f.Match != FilterMatchAny {
+			return false, nil
+		}
+	}
+
+	// all rules matched, or none for any
+	return f.Match != FilterMatchAny || len(f.Rules) == 0, nil
+}
+
+func (r *FilterRule) matches(req *http.Request, body interface{}) bool {
+	var values []string
+	switch r.Source {
+	case FilterSourceMethod:
+		values = []string{req.Method}
+	case FilterSourceHeader:
+		values = req.Header.Values(r.Name)
+	case FilterSourceQuery:
+		values = req.URL.Query()[r.Name]
+	case FilterSourceBody:
+		// paths stored before their indexes were limited don't match anything
+		if steps, err := parseFieldPath(r.Name); err == nil {
+			if v, ok := lookupField(body, steps); ok {
+				values = []string{fieldString(v)}
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return false
+	}
+	if len(r.Values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		for _, pattern := range r.Values {
+			if ok, _ := path.Match(pattern, v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readJSONBody decodes the body of the call and puts it back so it can still be forwarded. A body that is no JSON
+// has no fields
+func readJSONBody(req *http.Request) (interface{}, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var body interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if dec.Decode(&body) != nil {
+		return nil, nil
+	}
+	return body, nil
+}
+
+// maxFieldIndex is the largest array index of a field path. Transforms fill arrays up to the index they set
+const maxFieldIndex = 1000
+
+// fieldStep is a part of a field path, either the key of an object or the index in an array
+type fieldStep struct {
+	key   string
+	index int
+}
+
+// parseFieldPath splits a path like $.pull_request.labels[0].name into its steps. The leading $ is optional
+func parseFieldPath(p string) ([]fieldStep, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	if p == "" {
+		return nil, &ErrInvalidFilter{Message: "a body rule needs the path of a field"}
+	}
+
+	steps := make([]fieldStep, 0)
+	for _, part := range strings.Split(p, ".") {
+		key := part
+		indexes := ""
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			indexes = part[i:]
+		}
+		if key == "" && indexes == "" {
+			return nil, &ErrInvalidFilter{Message: "'" + p + "' contains an empty field"}
+		}
+		if key != "" {
+			steps = append(steps, fieldStep{key: key, index: -1})
+		}
+
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if !strings.HasPrefix(indexes, "[") || end < 0 {
+				return nil, &ErrInvalidFilter{Message: "'" + p + "' contains an invalid index"}
+			}
+			n, err := strconv.Atoi(indexes[1:end])
+			if err != nil || n < 0 {
+				return nil, &ErrInvalidFilter{Message: "'" + p + "' contains an invalid index"}
+			}
+			if n > maxFieldIndex {
+				return nil, &ErrInvalidFilter{Message: "'" + p + "' contains an index larger than " + strconv.Itoa(maxFieldIndex)}
+			}
+			steps = append(steps, fieldStep{index: n})
+			indexes = indexes[end+1:]
+		}
+	}
+	return steps, nil
+}
+
+func lookupField(v interface{}, steps []fieldStep) (interface{}, bool) {
+	for _, step := range steps {
+		if step.index < 0 {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[step.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		arr, ok := v.([]interface{})
+		if !ok || step.index >= len(arr) {
+			return nil, false
+		}
+		v = arr[step.index]
+	}
+	return v, true
+}
+
+// fieldString returns the value of a field the way patterns are matched against. Objects and arrays are compared as JSON
+func fieldString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	case nil:
+		return "null"
+	default:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+}
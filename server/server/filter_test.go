@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFilterBody = `{"ref":"refs/heads/master","repository":{"name":"api","private":false},"commits":[{"id":"a1","tags":["x","y"]},{"id":"b2"}],"size":3,"empty":null}`
+
+// decodeTestBody decodes a body the way calls are decoded
+func decodeTestBody(t *testing.T, body string) interface{} {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tables := []struct {
+		path  string
+		steps []fieldStep
+		valid bool
+	}{
+		{"ref", []fieldStep{{key: "ref", index: -1}}, true},
+		{"$.ref", []fieldStep{{key: "ref", index: -1}}, true},
+		{"$ref", []fieldStep{{key: "ref", index: -1}}, true},
+		{"$.repository.name", []fieldStep{{key: "repository", index: -1}, {key: "name", index: -1}}, true},
+		{"commits[0].id", []fieldStep{{key: "commits", index: -1}, {index: 0}, {key: "id", index: -1}}, true},
+		{"commits[1][2]", []fieldStep{{key: "commits", index: -1}, {index: 1}, {index: 2}}, true},
+		{"$[3]", []fieldStep{{index: 3}}, true},
+		{"a[1000]", []fieldStep{{key: "a", index: -1}, {index: 1000}}, true},
+		{"a[1001]", nil, false},
+		{"a[0][100000000]", nil, false},
+		{"", nil, false},
+		{"$", nil, false},
+		{"$.", nil, false},
+		{"a..b", nil, false},
+		{"a.", nil, false},
+		{"a[x]", nil, false},
+		{"a[-1]", nil, false},
+		{"a[1", nil, false},
+		{"a[1]b", nil, false},
+	}
+
+	for _, table := range tables {
+		steps, err := parseFieldPath(table.path)
+		if table.valid && err != nil {
+			t.Errorf("Error parsing '%s': %s", table.path, err.Error())
+			continue
+		}
+		if !table.valid {
+			if err == nil {
+				t.Errorf("'%s' was parsed to %+v, want an error", table.path, steps)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(steps, table.steps) {
+			t.Errorf("'%s' was parsed to %+v, want %+v", table.path, steps, table.steps)
+		}
+	}
+}
+
+func TestLookupField(t *testing.T) {
+	body := decodeTestBody(t, testFilterBody)
+
+	tables := []struct {
+		path  string
+		value string
+		found bool
+	}{
+		{"ref", "refs/heads/master", true},
+		{"$.repository.name", "api", true},
+		{"repository.private", "false", true},
+		{"repository", `{"name":"api","private":false}`, true},
+		{"commits[0].id", "a1", true},
+		{"commits[0].tags[1]", "y", true},
+		{"commits[1].id", "b2", true},
+		{"size", "3", true},
+		{"empty", "null", true},
+		{"commits[2].id", "", false},
+		{"commits[1].tags", "", false},
+		{"repository[0]", "", false},
+		{"ref.name", "", false},
+		{"missing", "", false},
+	}
+
+	for _, table := range tables {
+		steps, err := parseFieldPath(table.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, found := lookupField(body, steps)
+		if found != table.found {
+			t.Errorf("'%s': found is %t, want %t", table.path, found, table.found)
+			continue
+		}
+		if found && fieldString(v) != table.value {
+			t.Errorf("'%s': got %s, want %s", table.path, fieldString(v), table.value)
+		}
+	}
+}
+
+func TestFilterRuleMatches(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/h/test?action=opened&action=closed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-GitHub-Event", "push")
+	body := decodeTestBody(t, testFilterBody)
+
+	tables := []struct {
+		rule  FilterRule
+		match bool
+	}{
+		{FilterRule{Source: FilterSourceMethod, Values: []string{"POST"}}, true},
+		{FilterRule{Source: FilterSourceMethod, Values: []string{"GET", "PUT"}}, false},
+		{FilterRule{Source: FilterSourceHeader, Name: "X-GitHub-Event", Values: []string{"push", "ping"}}, true},
+		{FilterRule{Source: FilterSourceHeader, Name: "x-github-event", Values: []string{"pu*"}}, true},
+		{FilterRule{Source: FilterSourceHeader, Name: "X-GitHub-Event", Values: []string{"pull_request"}}, false},
+		{FilterRule{Source: FilterSourceHeader, Name: "X-GitHub-Event"}, true},
+		{FilterRule{Source: FilterSourceHeader, Name: "X-Gitlab-Event"}, false},
+		{FilterRule{Source: FilterSourceQuery, Name: "action", Values: []string{"closed"}}, true},
+		{FilterRule{Source: FilterSourceQuery, Name: "state"}, false},
+		{FilterRule{Source: FilterSourceBody, Name: "$.ref", Values: []string{"refs/heads/*"}}, true},
+		{FilterRule{Source: FilterSourceBody, Name: "ref", Values: []string{"refs/tags/*"}}, false},
+		{FilterRule{Source: FilterSourceBody, Name: "repository.private", Values: []string{"false"}}, true},
+		{FilterRule{Source: FilterSourceBody, Name: "commits[1].id", Values: []string{"b?"}}, true},
+		{FilterRule{Source: FilterSourceBody, Name: "commits[5].id"}, false},
+		{FilterRule{Source: FilterSourceBody, Name: "size", Values: []string{"3"}}, true},
+		{FilterRule{Source: FilterSourceBody, Name: "empty"}, true},
+		{FilterRule{Source: FilterSourceBody, Name: "missing"}, false},
+		// stored before indexes were limited
+		{FilterRule{Source: FilterSourceBody, Name: "commits[5000].id"}, false},
+		// negation is applied by the filter, not by the rule
+		{FilterRule{Source: FilterSourceMethod, Values: []string{"POST"}, Negate: true}, true},
+	}
+
+	for _, table := range tables {
+		if match := table.rule.matches(req, body); match != table.match {
+			t.Errorf("rule %+v: got %t, want %t", table.rule, match, table.match)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	post := FilterRule{Source: FilterSourceMethod, Values: []string{"POST"}}
+	get := FilterRule{Source: FilterSourceMethod, Values: []string{"GET"}}
+	master := FilterRule{Source: FilterSourceBody, Name: "ref", Values: []string{"refs/heads/master"}}
+	notGet := get
+	notGet.Negate = true
+
+	tables := []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{Match: FilterMatchAll}, true},
+		{Filter{Match: FilterMatchAny}, true},
+		{Filter{Match: FilterMatchAll, Rules: []FilterRule{post, master}}, true},
+		{Filter{Match: FilterMatchAll, Rules: []FilterRule{post, get}}, false},
+		{Filter{Match: FilterMatchAny, Rules: []FilterRule{get, master}}, true},
+		{Filter{Match: FilterMatchAny, Rules: []FilterRule{get}}, false},
+		{Filter{Match: FilterMatchAll, Rules: []FilterRule{notGet, master}}, true},
+	}
+
+	for _, table := range tables {
+		req, err := http.NewRequest(http.MethodPost, "/h/test", strings.NewReader(testFilterBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := table.filter.matches(req)
+		if err != nil {
+			t.Errorf("Error matching %+v: %s", table.filter, err.Error())
+			continue
+		}
+		if match != table.match {
+			t.Errorf("filter %+v: got %t, want %t", table.filter, match, table.match)
+		}
+
+		// the body can still be passed on
+		var b bytes.Buffer
+		b.ReadFrom(req.Body)
+		if b.String() != testFilterBody {
+			t.Errorf("filter %+v changed the body to %s", table.filter, b.String())
+		}
+	}
+}
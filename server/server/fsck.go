@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// fsckBatchSize is the number of keys removed in one transaction, so large databases don't exceed the transaction size
+const fsckBatchSize = 1000
+
+// FsckProblem is an inconsistency found in the database
+type FsckProblem struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+	// Fixable is set if the key can be removed without losing a working client or hook
+	Fixable bool `json:"fixable"`
+}
+
+// FsckReport is the result of a database check
+type FsckReport struct {
+	Keys     int           `json:"keys"`
+	Problems []FsckProblem `json:"problems"`
+	Removed  int           `json:"removed"`
+}
+
+// Fsck compares the database with the loaded clients and hooks and finds keys that don't belong to any of them,
+// like keys of deleted hooks, and hooks that are incomplete. If fix is set, these are removed
+func (s *Server) Fsck(fix bool) (*FsckReport, error) {
+	// no client or hook is added or removed while their keys are checked
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &FsckReport{Problems: make([]FsckProblem, 0)}
+
+	keys, err := s.DB.keys()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	report.Keys = len(keys)
+
+	clients := make(map[string]*Client)
+	for _, c := range s.Clients {
+		clients[c.ID] = c
+	}
+
+	orphans := make([]string, 0)
+	for _, k := range keys {
+		problem, fixable := s.checkKey(k, clients)
+		if problem == "" {
+			continue
+		}
+		report.Problems = append(report.Problems, FsckProblem{Key: k, Problem: problem, Fixable: fixable})
+		if fixable {
+			orphans = append(orphans, k)
+		}
+	}
+
+	// hooks without UUID or URL can't be called, they are left over from partly deleted hooks
+	incomplete := make([]*Webhook, 0)
+	for _, c := range s.Clients {
+		for _, h := range c.Hooks {
+			if h.UUID == "" || h.URL == "" {
+				report.Problems = append(report.Problems, FsckProblem{
+					Key:     hookKeyPrefix(c.ID, h.Identifier),
+					Problem: "incomplete hook without UUID or URL",
+					Fixable: true,
+				})
+				incomplete = append(incomplete, h)
+			}
+		}
+	}
+
+	sort.Slice(report.Problems, func(i, j int) bool {
+		return report.Problems[i].Key < report.Problems[j].Key
+	})
+
+	if !fix {
+		return report, nil
+	}
+
+	for _, h := range incomplete {
+		err = s.removeHook(h)
+		if err != nil {
+			log.Error(err)
+			return report, err
+		}
+		report.Removed++
+	}
+
+	err = s.DB.deleteKeyList(orphans)
+	if err != nil {
+		log.Error(err)
+		return report, err
+	}
+	report.Removed += len(orphans)
+
+	log.Infof("Fsck removed %d problems", report.Removed)
+	return report, nil
+}
+
+// checkKey returns what is wrong with a key and whether it can be removed, or an empty string if it is fine.
+// clients maps the IDs of all clients to them. The caller holds s.mu
+func (s *Server) checkKey(k string, clients map[string]*Client) (string, bool) {
+	switch {
+	case strings.HasPrefix(k, auditPrefix):
+		return "", false
+	case strings.HasPrefix(k, deliveryPrefix):
+		if s.Hooks[firstSegment(k[len(deliveryPrefix):])] == nil {
+			return "delivery of an unknown hook", true
+		}
+		return "", false
+	case strings.HasPrefix(k, bufferPrefix):
+		if s.Hooks[firstSegment(k[len(bufferPrefix):])] == nil {
+			return "buffered call of an unknown hook", true
+		}
+		return "", false
+	case strings.HasPrefix(k, queuePrefix):
+		if clients[firstSegment(k[len(queuePrefix):])] == nil {
+			return "queued request of an unknown client", true
+		}
+		return "", false
+	case strings.HasPrefix(k, eventPrefix):
+		if clients[firstSegment(k[len(eventPrefix):])] == nil {
+			return "event of an unknown client", true
+		}
+		return "", false
+	case strings.HasPrefix(k, outboxPrefix):
+		if s.findSink(firstSegment(k[len(outboxPrefix):])) == nil {
+			return "call in the outbox of an unknown sink", true
+		}
+		return "", false
+	case strings.HasPrefix(k, delimeter):
+		// might belong to a newer version, so it is never removed
+		return "unknown internal key", false
+	}
+
+	keysplit := strings.Split(k, delimeter)
+	if len(keysplit) < 2 || (keysplit[1] == "Hooks" && len(keysplit) < 4) {
+		return "malformed key", true
+	}
+
+	c := clients[keysplit[0]]
+	if c == nil {
+		return "key of an unknown client", true
+	}
+	if keysplit[1] == "Hooks" && c.Hooks[keysplit[2]] == nil {
+		return "key of a deleted hook", true
+	}
+	if keysplit[1] == "Hooks" && len(keysplit) > 4 {
+		// identifiers containing the delimeter can't be loaded
+		return "malformed key", true
+	}
+
+	return "", false
+}
+
+// firstSegment returns s up to the first delimeter
+func firstSegment(s string) string {
+	if i := strings.Index(s, delimeter); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// keys returns all keys of the database
+func (db *DB) keys() ([]string, error) {
+	keys := make([]string, 0)
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// deleteKeyList deletes the given keys
+func (db *DB) deleteKeyList(keys []string) error {
+	for len(keys) > 0 {
+		n := fsckBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+
+		err := db.bdb.Update(func(txn *badger.Txn) error {
+			for _, k := range keys[:n] {
+				err := txn.Delete([]byte(k))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		keys = keys[n:]
+	}
+	return nil
+}
@@ -0,0 +1,457 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/captainhookpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcClientKey is the key the authenticated client is stored at in the context of gRPC calls
+type grpcClientKey struct{}
+
+// auditedMethods are the gRPC methods that change something and are recorded in the audit log
+var auditedMethods = map[string]bool{
+	"/captainhook.v1.CaptainHook/CreateHook": true,
+	"/captainhook.v1.CaptainHook/DeleteHook": true,
+}
+
+// grpcService implements the CaptainHook gRPC service for the clients of the server
+type grpcService struct {
+	captainhookpb.UnimplementedCaptainHookServer
+	server *Server
+}
+
+// authenticatedStream is a server stream whose context carries the authenticated client
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// unackedCalls are the calls sent on a gRPC stream that were not acknowledged yet, by delivery ID
+type unackedCalls struct {
+	mu    sync.Mutex
+	calls map[string]*queuedRequest
+}
+
+func (u *unackedCalls) add(id string, q *queuedRequest) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.calls[id] = q
+}
+
+// remove forgets a call and returns it, or nil if it is unknown or was acknowledged already
+func (u *unackedCalls) remove(id string) *queuedRequest {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	q := u.calls[id]
+	delete(u.calls, id)
+	return q
+}
+
+// setupGRPC serves the gRPC API on the given port with the TLS configuration of the external API. Port 0 disables it
+func setupGRPC(hostname string, port int, server *Server, cfg *tls.Config) {
+	if port == 0 {
+		return
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(server.authenticateUnary),
+		grpc.StreamInterceptor(server.authenticateStream),
+	}
+	if cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+
+	g := grpc.NewServer(opts...)
+	captainhookpb.RegisterCaptainHookServer(g, &grpcService{server: server})
+
+	lis, err := net.Listen("tcp", hostname+":"+strconv.Itoa(port))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		err := g.Serve(lis)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// authenticateContext adds the client to ctx that authenticated with its certificate or the bearer token in the
+// authorization metadata, like auth does for the external API
+func (s *Server) authenticateContext(ctx context.Context) (context.Context, error) {
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	client := s.authenticate(state, authorization)
+	if client == nil {
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return context.WithValue(ctx, grpcClientKey{}, client), nil
+}
+
+func (s *Server) authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	authenticated, err := s.authenticateContext(ctx)
+	if err != nil {
+		// denied calls are recorded like the 403 answers of the APIs
+		if auditedMethods[info.FullMethod] {
+			s.auditGRPC(ctx, info.FullMethod, req, err)
+		}
+		return nil, err
+	}
+
+	resp, err := handler(authenticated, req)
+	if auditedMethods[info.FullMethod] {
+		s.auditGRPC(authenticated, info.FullMethod, req, err)
+	}
+	return resp, err
+}
+
+func (s *Server) authenticateStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticateContext(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// auditGRPC records a mutating gRPC call in the audit log like auditLogger does for the APIs. Calls that were not
+// authenticated are recorded as anonymous
+func (s *Server) auditGRPC(ctx context.Context, method string, req interface{}, err error) {
+	actor := "anonymous"
+	if client := grpcClient(ctx); client != nil {
+		actor = "client:" + client.Name
+	}
+	target := ""
+	if r, ok := req.(interface{ GetIdentifier() string }); ok {
+		target = "identifier=" + r.GetIdentifier()
+	}
+	sourceIP := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		sourceIP = p.Addr.String()
+		if host, _, serr := net.SplitHostPort(sourceIP); serr == nil {
+			sourceIP = host
+		}
+	}
+
+	s.Audit(&AuditEntry{
+		Time:     time.Now(),
+		Actor:    actor,
+		Action:   "GRPC " + method,
+		Target:   target,
+		SourceIP: sourceIP,
+		Status:   httpStatus(status.Code(err)),
+	})
+}
+
+func grpcClient(ctx context.Context) *Client {
+	client, _ := ctx.Value(grpcClientKey{}).(*Client)
+	return client
+}
+
+// grpcError converts an error of the server into a gRPC status with the code matching the status of the APIs
+func grpcError(err error) error {
+	switch err.(type) {
+	case *ErrHookNotExists, *ErrClientNotExists:
+		return status.Error(codes.NotFound, err.Error())
+	case *ErrHookAlreadyExists:
+		return status.Error(codes.AlreadyExists, err.Error())
+	case *ErrInvalidMetadata, *ErrInvalidExpiry:
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// httpStatus returns the http status the APIs answer with for a gRPC code
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (g *grpcService) Receive(stream captainhookpb.CaptainHook_ReceiveServer) error {
+	return g.server.Receive(grpcClient(stream.Context()), stream)
+}
+
+func (g *grpcService) ListHooks(ctx context.Context, req *captainhookpb.ListHooksRequest) (*captainhookpb.ListHooksResponse, error) {
+	found := g.server.FindHooks(HookFilter{
+		Client:   grpcClient(ctx).Name,
+		Provider: req.Provider,
+		Labels:   req.Labels,
+		Search:   req.Search,
+	})
+
+	resp := &captainhookpb.ListHooksResponse{Hooks: make([]*captainhookpb.Hook, 0, len(found))}
+	for _, f := range found {
+		resp.Hooks = append(resp.Hooks, hookMessage(f.Webhook))
+	}
+	return resp, nil
+}
+
+func (g *grpcService) GetHook(ctx context.Context, req *captainhookpb.GetHookRequest) (*captainhookpb.Hook, error) {
+	hook, err := g.server.getHook(grpcClient(ctx).Name, req.Identifier)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return hookMessage(hook), nil
+}
+
+func (g *grpcService) CreateHook(ctx context.Context, req *captainhookpb.CreateHookRequest) (*captainhookpb.Hook, error) {
+	opts := HookOptions{
+		HookMetadata: HookMetadata{Labels: req.Labels},
+		TTL:          req.Ttl,
+		MaxCalls:     int(req.MaxCalls),
+	}
+	if req.Description != "" {
+		opts.Description = &req.Description
+	}
+	if req.Provider != "" {
+		opts.Provider = &req.Provider
+	}
+	if req.ExpiresAt != nil {
+		expiresAt := req.ExpiresAt.AsTime()
+		opts.ExpiresAt = &expiresAt
+	}
+
+	hook, err := g.server.AddHook(grpcClient(ctx).Name, req.Identifier, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return hookMessage(hook), nil
+}
+
+func (g *grpcService) DeleteHook(ctx context.Context, req *captainhookpb.DeleteHookRequest) (*captainhookpb.DeleteHookResponse, error) {
+	err := g.server.DeleteHook(grpcClient(ctx).Name, req.Identifier)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &captainhookpb.DeleteHookResponse{}, nil
+}
+
+// Receive sends the calls of the client to a gRPC stream until it ends, requests that were queued while nobody was
+// connected first. Calls that were not acknowledged by then are queued again, so every call is delivered at least once
+func (s *Server) Receive(client *Client, stream captainhookpb.CaptainHook_ReceiveServer) error {
+	con := client.openStream(s.queueLimit+defaultMessageBuffer, OutputRaw)
+	unacked := &unackedCalls{calls: make(map[string]*queuedRequest)}
+	defer func() {
+		client.removeConnection(con)
+		s.requeueUnacked(client, unacked, con)
+	}()
+
+	// calls that could not be sent are queued again with the unacknowledged ones, unless they are still queued
+	send := func(q *queuedRequest, queued bool) error {
+		call, err := s.call(q)
+		if err != nil {
+			log.Errorf("Could not read request for %s: %s", client.Name, err.Error())
+			return nil
+		}
+
+		// the ack may arrive before Send returns
+		if q.Delivery != "" {
+			unacked.add(call.Delivery, q)
+		}
+		err = stream.Send(call)
+		if err != nil && queued {
+			unacked.remove(call.Delivery)
+		}
+		return err
+	}
+
+	acks := make(chan error, 1)
+	go func() {
+		acks <- receiveAcks(stream, unacked)
+	}()
+
+	s.flushQueue(client, OutputRaw, func(q *queuedRequest) error {
+		return send(q, true)
+	})
+
+	for {
+		select {
+		case q := <-con.messages:
+			err := send(q, false)
+			if err != nil {
+				return err
+			}
+		case err := <-acks:
+			return err
+		case <-con.closed:
+			return status.Error(codes.Unavailable, "connection closed by server")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// receiveAcks forgets the calls the client acknowledged until it stops sending
+func receiveAcks(stream captainhookpb.CaptainHook_ReceiveServer, unacked *unackedCalls) error {
+	for {
+		ack, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		unacked.remove(ack.Delivery)
+	}
+}
+
+// requeueUnacked queues the calls of an ended stream again that were not acknowledged or not even sent. Their
+// deliveries count one receiver less and are queued if that was the only one
+func (s *Server) requeueUnacked(client *Client, unacked *unackedCalls, con *connection) {
+	unacked.mu.Lock()
+	calls := make([]*queuedRequest, 0, len(unacked.calls))
+	for _, q := range unacked.calls {
+		calls = append(calls, q)
+	}
+	unacked.mu.Unlock()
+
+	for pending := true; pending; {
+		select {
+		case q := <-con.messages:
+			if q.Delivery != "" {
+				calls = append(calls, q)
+			}
+		default:
+			pending = false
+		}
+	}
+
+	queued := 0
+	for _, q := range calls {
+		if s.queuedHook(q) == nil {
+			continue
+		}
+
+		err := s.enqueue(client, q.Delivery, q.Message)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		queued++
+
+		err = s.DB.UpdateDelivery(q.Delivery, func(d *Delivery) {
+			if d.Receivers > 0 {
+				d.Receivers--
+			}
+			if d.Receivers == 0 && d.Outcome == OutcomeDelivered {
+				d.Outcome = OutcomeQueued
+			}
+		})
+		if err != nil {
+			log.Errorf("Could not update delivery: %s", err.Error())
+		}
+	}
+
+	if queued > 0 {
+		log.Infof("Queued %d unacknowledged calls of %s again", queued, client.Name)
+	}
+}
+
+// call converts a serialized request into the message sent on gRPC streams
+func (s *Server) call(q *queuedRequest) (*captainhookpb.Call, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(q.Message)))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	call := &captainhookpb.Call{
+		Delivery: req.Header.Get(DeliveryHeader),
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Query:    req.URL.RawQuery,
+		Headers:  make([]*captainhookpb.Header, 0, len(req.Header)),
+		Body:     body,
+	}
+	if hook := s.queuedHook(q); hook != nil {
+		call.Hook = hook.Identifier
+		call.HookUuid = hook.UUID
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		call.Headers = append(call.Headers, &captainhookpb.Header{Name: name, Values: req.Header[name]})
+	}
+
+	return call, nil
+}
+
+func hookMessage(w *Webhook) *captainhookpb.Hook {
+	h := &captainhookpb.Hook{
+		Identifier:  w.Identifier,
+		Uuid:        w.UUID,
+		Url:         w.URL,
+		SlugUrl:     w.SlugURL,
+		Description: w.Description,
+		Provider:    w.Provider,
+		Labels:      w.Labels,
+		CreatedAt:   timestamppb.New(w.CreatedAt),
+		LastCall:    timestamppb.New(w.LastCall),
+		MaxCalls:    int32(w.MaxCalls),
+		Calls:       int32(w.calls()),
+	}
+	if w.ExpiresAt != nil {
+		h.ExpiresAt = timestamppb.New(*w.ExpiresAt)
+	}
+	return h
+}
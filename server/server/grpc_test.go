@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"code.cerinuts.io/cerinuts/captainhook/server/captainhookpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TestAuditGRPC checks that mutating gRPC calls are recorded in the audit log, including the denied ones
+func TestAuditGRPC(t *testing.T) {
+	s := testServer(t.TempDir())
+	defer s.Stop()
+
+	secret, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	tables := []struct {
+		method        string
+		authorization string
+		actor         string
+		status        int
+	}{
+		{"/captainhook.v1.CaptainHook/CreateHook", "", "anonymous", http.StatusForbidden},
+		{"/captainhook.v1.CaptainHook/DeleteHook", "Bearer wrong", "anonymous", http.StatusForbidden},
+		{"/captainhook.v1.CaptainHook/CreateHook", "Bearer " + secret, "client:testclient", http.StatusOK},
+		// reading calls are not recorded
+		{"/captainhook.v1.CaptainHook/ListHooks", "", "", 0},
+		{"/captainhook.v1.CaptainHook/ListHooks", "Bearer " + secret, "", 0},
+	}
+
+	for _, table := range tables {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", table.authorization))
+		before, err := s.DB.AuditEntries(AuditFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.authenticateUnary(ctx, &captainhookpb.CreateHookRequest{Identifier: "test"}, &grpc.UnaryServerInfo{FullMethod: table.method}, handler)
+
+		entries, err := s.DB.AuditEntries(AuditFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.actor == "" {
+			if len(entries) != len(before) {
+				t.Errorf("%s was recorded", table.method)
+			}
+			continue
+		}
+		if len(entries) != len(before)+1 {
+			t.Errorf("%s with '%s' was not recorded", table.method, table.authorization)
+			continue
+		}
+
+		e := entries[len(entries)-1]
+		if e.Actor != table.actor || e.Action != "GRPC "+table.method || e.Status != table.status || e.Target != "identifier=test" {
+			t.Errorf("%s with '%s' was recorded as %+v, want actor %s and status %d", table.method, table.authorization, e, table.actor, table.status)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// IPFilter restricts which addresses may call a webhook. Denied networks always win.
+// If Allow or Presets contain any entry, only addresses within these networks are accepted
+type IPFilter struct {
+	Allow   []string `json:"allow"`
+	Deny    []string `json:"deny"`
+	Presets []string `json:"presets"`
+}
+
+// ConfigureIPFilter loads the provider presets from presetFile and sets up how the address of a caller is determined.
+// If trustForwardedFor is set, X-Forwarded-For is evaluated for requests coming from one of the trustedProxies
+func (s *Server) ConfigureIPFilter(presetFile string, trustForwardedFor bool, trustedProxies []string) error {
+	proxies, err := parseNetworks(trustedProxies)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	s.trustForwardedFor = trustForwardedFor
+	s.trustedProxies = proxies
+
+	s.presets = make(map[string][]*net.IPNet)
+	if presetFile == "" {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(presetFile)
+	err = v.ReadInConfig()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	for _, name := range v.AllKeys() {
+		networks, err := parseNetworks(v.GetStringSlice(name))
+		if err != nil {
+			log.Errorf("Invalid preset '%s': %s", name, err.Error())
+			return err
+		}
+		s.presets[name] = networks
+	}
+	log.Infof("Loaded %d ip presets from %s", len(s.presets), presetFile)
+
+	return nil
+}
+
+// SetHookIPFilter replaces the ip filter of the webhook identified by identifier of the given client
+func (s *Server) SetHookIPFilter(clientname, identifier string, filter IPFilter) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	if err = s.validateIPFilter(filter); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	s.mu.Lock()
+	hook.IPFilter = filter
+	s.mu.Unlock()
+	return s.storeClient(hook.client)
+}
+
+func (s *Server) validateIPFilter(filter IPFilter) error {
+	if _, err := parseNetworks(filter.Allow); err != nil {
+		return err
+	}
+	if _, err := parseNetworks(filter.Deny); err != nil {
+		return err
+	}
+	for _, p := range filter.Presets {
+		if s.presets[strings.ToLower(p)] == nil {
+			return &ErrUnknownPreset{Name: p}
+		}
+	}
+	return nil
+}
+
+// checkIP returns an error if the caller of req is not allowed to call the hook. The caller must not hold s.mu
+func (s *Server) checkIP(w *Webhook, req *http.Request) error {
+	ip := s.clientIP(req)
+	if ip == nil {
+		return &ErrIPNotAllowed{IP: req.RemoteAddr, Identifier: w.Identifier}
+	}
+
+	// filters are replaced as a whole, never changed in place
+	s.mu.RLock()
+	filter := w.IPFilter
+	s.mu.RUnlock()
+
+	// filters have been validated when they were set, so parsing errors can be ignored here
+	deny, _ := parseNetworks(filter.Deny)
+	if containsIP(deny, ip) {
+		return &ErrIPNotAllowed{IP: ip.String(), Identifier: w.Identifier}
+	}
+
+	allow, _ := parseNetworks(filter.Allow)
+	for _, p := range filter.Presets {
+		allow = append(allow, s.presets[strings.ToLower(p)]...)
+	}
+	if len(filter.Allow) == 0 && len(filter.Presets) == 0 {
+		return nil
+	}
+	if containsIP(allow, ip) {
+		return nil
+	}
+
+	return &ErrIPNotAllowed{IP: ip.String(), Identifier: w.Identifier}
+}
+
+// clientIP determines the address of the caller. X-Forwarded-For is only used if the request was sent by a trusted proxy.
+// The header is read from right to left, the first address that is not a trusted proxy is the caller
+func (s *Server) clientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trustForwardedFor || !containsIP(s.trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := make([]string, 0)
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fip == nil {
+			// an invalid entry can't be trusted, neither can anything left of it
+			return ip
+		}
+		ip = fip
+		if !containsIP(s.trustedProxies, ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+// parseNetworks parses CIDRs. Single addresses are treated as a network containing only this address
+func parseNetworks(in []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(in))
+	for _, n := range in {
+		n = strings.TrimSpace(n)
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, &ErrInvalidNetwork{Network: n}
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, &ErrInvalidNetwork{Network: n}
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
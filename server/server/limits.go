@@ -0,0 +1,275 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits restricts how often and how large webhook calls may be. Zero means unlimited.
+// For a single hook, a MaxBodySize of zero falls back to the global MaxBodySize and a larger one is capped by it
+type Limits struct {
+	// MaxBodySize is the maximum size of a request body in bytes
+	MaxBodySize int64 `json:"maxBodySize"`
+	// RateLimit is the number of requests per second that are refilled into the token bucket
+	RateLimit float64 `json:"rateLimit"`
+	// RateBurst is the size of the token bucket. Defaults to RateLimit, at least 1
+	RateBurst int `json:"rateBurst"`
+	// MaxInFlight is the maximum number of requests handled at the same time
+	MaxInFlight int `json:"maxInFlight"`
+}
+
+// Stats contains the counters of calls to the external hook endpoint
+type Stats struct {
+	Accepted        uint64 `json:"accepted"`
+	TooLarge        uint64 `json:"tooLarge"`
+	RateLimited     uint64 `json:"rateLimited"`
+	TooManyInFlight uint64 `json:"tooManyInFlight"`
+	FilterMatched   uint64 `json:"filterMatched"`
+	Filtered        uint64 `json:"filtered"`
+	InFlight        int    `json:"inFlight"`
+}
+
+// HookStats contains the counters of a single hook
+type HookStats struct {
+	Client     string `json:"client"`
+	Identifier string `json:"identifier"`
+	UUID       string `json:"uuid"`
+	Stats
+}
+
+// limiter enforces Limits and counts the results
+type limiter struct {
+	mu     sync.Mutex
+	limits Limits
+	bucket *rate.Limiter
+	stats  Stats
+}
+
+func newLimiter(l Limits) *limiter {
+	lim := new(limiter)
+	lim.setLimits(l)
+	return lim
+}
+
+func (l *limiter) setLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits = limits
+	l.bucket = nil
+	if limits.RateLimit > 0 {
+		burst := limits.RateBurst
+		if burst < 1 {
+			burst = int(math.Max(1, math.Ceil(limits.RateLimit)))
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(limits.RateLimit), burst)
+	}
+}
+
+// reserve takes a token from the bucket. The returned reservation can be canceled if the request is rejected later on
+func (l *limiter) reserve() (*rate.Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.bucket == nil {
+		return nil, nil
+	}
+
+	r := l.bucket.Reserve()
+	if !r.OK() {
+		l.stats.RateLimited++
+		return nil, &ErrRateLimited{RetryAfter: time.Second}
+	}
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		l.stats.RateLimited++
+		return nil, &ErrRateLimited{RetryAfter: delay}
+	}
+
+	return r, nil
+}
+
+func (l *limiter) enter() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits.MaxInFlight > 0 && l.stats.InFlight >= l.limits.MaxInFlight {
+		l.stats.TooManyInFlight++
+		return &ErrTooManyInFlight{Limit: l.limits.MaxInFlight}
+	}
+	l.stats.InFlight++
+	return nil
+}
+
+func (l *limiter) leave() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.InFlight--
+}
+
+func (l *limiter) count(f func(*Stats)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f(&l.stats)
+}
+
+func (l *limiter) getStats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *limiter) getLimits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits
+}
+
+// limitedBody fails with ErrRequestTooLarge as soon as more than limit bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining, limit int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// only fail if there actually is more data
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, &ErrRequestTooLarge{Limit: b.limit}
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// ConfigureLimits sets the limits that apply to all calls of the external hook endpoint together
+func (s *Server) ConfigureLimits(l Limits) {
+	s.limiter.setLimits(l)
+}
+
+// SetHookLimits replaces the limits of the webhook identified by identifier of the given client
+func (s *Server) SetHookLimits(clientname, identifier string, l Limits) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	if l.MaxBodySize < 0 || l.RateLimit < 0 || l.RateBurst < 0 || l.MaxInFlight < 0 {
+		err = &ErrInvalidLimits{Message: "limits must not be negative"}
+		log.Error(err)
+		return err
+	}
+
+	hook.Limits = l
+	hook.limiter.setLimits(l)
+	return s.storeClient(hook.client)
+}
+
+// GetStats returns the global counters and the counters of each hook
+func (s *Server) GetStats() (Stats, []HookStats) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hooks := make([]HookStats, 0, len(s.Hooks))
+	for _, h := range s.Hooks {
+		hooks = append(hooks, HookStats{
+			Client:     h.client.Name,
+			Identifier: h.Identifier,
+			UUID:       h.UUID,
+			Stats:      h.limiter.getStats(),
+		})
+	}
+	return s.limiter.getStats(), hooks
+}
+
+// acquire checks all limits for a call of w. If the call is allowed, release has to be called after it is handled
+func (s *Server) acquire(w *Webhook, req *http.Request) (func(), error) {
+	maxBody := s.limiter.getLimits().MaxBodySize
+	if hookMax := w.limiter.getLimits().MaxBodySize; hookMax > 0 && (maxBody <= 0 || hookMax < maxBody) {
+		maxBody = hookMax
+	}
+
+	if maxBody > 0 && req.ContentLength > maxBody {
+		s.countTooLarge(w)
+		return nil, &ErrRequestTooLarge{Limit: maxBody}
+	}
+
+	global, err := s.limiter.reserve()
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := w.limiter.reserve()
+	if err != nil {
+		cancel(global)
+		return nil, err
+	}
+
+	if err = s.limiter.enter(); err != nil {
+		cancel(global, hook)
+		return nil, err
+	}
+
+	if err = w.limiter.enter(); err != nil {
+		s.limiter.leave()
+		cancel(global, hook)
+		return nil, err
+	}
+
+	if maxBody > 0 && req.Body != nil {
+		req.Body = &limitedBody{ReadCloser: req.Body, remaining: maxBody, limit: maxBody}
+	}
+
+	return func() {
+		w.limiter.leave()
+		s.limiter.leave()
+	}, nil
+}
+
+func (s *Server) countAccepted(w *Webhook) {
+	inc := func(st *Stats) { st.Accepted++ }
+	s.limiter.count(inc)
+	w.limiter.count(inc)
+}
+
+func (s *Server) countTooLarge(w *Webhook) {
+	inc := func(st *Stats) { st.TooLarge++ }
+	s.limiter.count(inc)
+	w.limiter.count(inc)
+}
+
+func (s *Server) countFilter(w *Webhook, matched bool) {
+	inc := func(st *Stats) { st.Filtered++ }
+	if matched {
+		inc = func(st *Stats) { st.FilterMatched++ }
+	}
+	s.limiter.count(inc)
+	w.limiter.count(inc)
+}
+
+func cancel(reservations ...*rate.Reservation) {
+	for _, r := range reservations {
+		if r != nil {
+			r.Cancel()
+		}
+	}
+}
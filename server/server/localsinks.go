@@ -0,0 +1,312 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExecTimeout     = 30
+	defaultExecConcurrency = 1
+	defaultFileMaxSize     = 10 * 1024 * 1024
+	defaultFileMaxFiles    = 5
+	defaultSocketTimeout   = 5
+)
+
+// ExecSink runs a command for every call. The body is passed on stdin, everything else in environment variables:
+// CAPTAINHOOK_DELIVERY, CAPTAINHOOK_HOOK, CAPTAINHOOK_HOOK_UUID, CAPTAINHOOK_CLIENT, CAPTAINHOOK_METHOD,
+// CAPTAINHOOK_PATH, CAPTAINHOOK_QUERY and CAPTAINHOOK_HEADER_<NAME> for every header, e.g. CAPTAINHOOK_HEADER_CONTENT_TYPE
+type ExecSink struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Dir is the working directory of the command. Defaults to the one of the server
+	Dir string `json:"dir,omitempty"`
+	// Timeout in seconds after which the command is killed. Defaults to 30
+	Timeout int `json:"timeout"`
+	// Concurrency is the number of commands running at once, further calls wait. Defaults to 1
+	Concurrency int `json:"concurrency"`
+}
+
+// FileSink appends every call as a line of JSON to a file. Full files are rotated to <path>.1, <path>.2 and so on
+type FileSink struct {
+	Path string `json:"path"`
+	// MaxSize is the size in bytes after which the file is rotated. Defaults to 10 MiB
+	MaxSize int64 `json:"maxSize"`
+	// MaxFiles is the number of rotated files kept besides the current one. Defaults to 5
+	MaxFiles int `json:"maxFiles"`
+}
+
+// SocketSink writes every call as a line of JSON to a Unix socket. The connection is kept open and reestablished if
+// it breaks
+type SocketSink struct {
+	Path string `json:"path"`
+	// Timeout in seconds for connecting and writing a call. Defaults to 5
+	Timeout int `json:"timeout"`
+}
+
+type execDriver struct {
+	cfg *ExecSink
+	// slots limits how many commands run at once
+	slots chan struct{}
+}
+
+type fileDriver struct {
+	cfg  *FileSink
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+type socketDriver struct {
+	cfg  *SocketSink
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newExecDriver(sink *Sink) (sinkDriver, error) {
+	cfg := sink.Exec
+	if cfg == nil || cfg.Command == "" {
+		return nil, &ErrInvalidSink{Message: "an exec sink needs a command"}
+	}
+	if cfg.Timeout < 0 || cfg.Concurrency < 0 {
+		return nil, &ErrInvalidSink{Message: "timeout and concurrency must not be negative"}
+	}
+	if _, err := exec.LookPath(cfg.Command); err != nil {
+		return nil, &ErrInvalidSink{Message: "command '" + cfg.Command + "' not found"}
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultExecTimeout
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultExecConcurrency
+	}
+	return &execDriver{cfg: cfg, slots: make(chan struct{}, cfg.Concurrency)}, nil
+}
+
+func (e *execDriver) send(call *SinkCall) error {
+	e.slots <- struct{}{}
+	defer func() { <-e.slots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.cfg.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.cfg.Command, e.cfg.Args...)
+	cmd.Dir = e.cfg.Dir
+	cmd.Env = append(os.Environ(), call.environment()...)
+	cmd.Stdin = bytes.NewReader(call.body)
+
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("killed after %d seconds", e.cfg.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), lastLine(out))
+	}
+	return nil
+}
+
+func (e *execDriver) close() error {
+	return nil
+}
+
+// environment returns the metadata of the call as environment variables for commands
+func (c *SinkCall) environment() []string {
+	env := []string{
+		"CAPTAINHOOK_DELIVERY=" + c.Delivery,
+		"CAPTAINHOOK_HOOK=" + c.Hook,
+		"CAPTAINHOOK_HOOK_UUID=" + c.HookUUID,
+		"CAPTAINHOOK_CLIENT=" + c.Client,
+		"CAPTAINHOOK_METHOD=" + c.Method,
+		"CAPTAINHOOK_PATH=" + c.Path,
+		"CAPTAINHOOK_QUERY=" + c.Query,
+	}
+	for name, values := range c.Header {
+		name = strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		env = append(env, "CAPTAINHOOK_HEADER_"+name+"="+strings.Join(values, ", "))
+	}
+	return env
+}
+
+// lastLine returns the last line a failed command printed, which usually tells why
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1]
+}
+
+func newFileDriver(sink *Sink) (sinkDriver, error) {
+	cfg := sink.File
+	if cfg == nil || cfg.Path == "" {
+		return nil, &ErrInvalidSink{Message: "a file sink needs a path"}
+	}
+	if cfg.MaxSize < 0 || cfg.MaxFiles < 0 {
+		return nil, &ErrInvalidSink{Message: "maxSize and maxFiles must not be negative"}
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = defaultFileMaxSize
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = defaultFileMaxFiles
+	}
+
+	f := &fileDriver{cfg: cfg}
+	err := f.open()
+	if err != nil {
+		return nil, &ErrInvalidSink{Message: err.Error()}
+	}
+	return f, nil
+}
+
+func (f *fileDriver) open() error {
+	err := os.MkdirAll(filepath.Dir(f.cfg.Path), 0750)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *fileDriver) send(call *SinkCall) error {
+	line, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(line)) > f.cfg.MaxSize {
+		err = f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate moves the full file to <path>.1 and the older ones one number up, dropping the oldest
+func (f *fileDriver) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	for i := f.cfg.MaxFiles - 1; i > 0; i-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", f.cfg.Path, i), fmt.Sprintf("%s.%d", f.cfg.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = os.Rename(f.cfg.Path, f.cfg.Path+".1")
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *fileDriver) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func newSocketDriver(sink *Sink) (sinkDriver, error) {
+	cfg := sink.Socket
+	if cfg == nil || cfg.Path == "" {
+		return nil, &ErrInvalidSink{Message: "a socket sink needs a path"}
+	}
+	if cfg.Timeout < 0 {
+		return nil, &ErrInvalidSink{Message: "timeout must not be negative"}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultSocketTimeout
+	}
+	// the socket may be created after the sink, so it is connected on the first call
+	return &socketDriver{cfg: cfg}, nil
+}
+
+func (s *socketDriver) send(call *SinkCall) error {
+	line, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a connection the other side closed meanwhile fails on the first write, so it is tried once more
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout("unix", s.cfg.Path, time.Duration(s.cfg.Timeout)*time.Second)
+			if err != nil {
+				s.conn = nil
+				return err
+			}
+		}
+
+		err = s.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.cfg.Timeout) * time.Second))
+		if err == nil {
+			_, err = s.conn.Write(line)
+		}
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *socketDriver) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
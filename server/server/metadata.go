@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const maxDescriptionLength = 1024
+const maxLabelLength = 63
+
+// labelPattern allows keys like team, jira.project or github/repo
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// providerPattern allows provider types like github, jira or gitlab-ci
+var providerPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// HookMetadata describes what a hook is used for. Fields that are nil are left unchanged, labels are merged into the
+// existing ones and a label with an empty value is removed
+type HookMetadata struct {
+	Description *string           `json:"description"`
+	Labels      map[string]string `json:"labels"`
+	Provider    *string           `json:"provider"`
+}
+
+// HookFilter restricts which hooks are listed. Empty fields match everything
+type HookFilter struct {
+	Client   string
+	Provider string
+	Labels   map[string]string
+	// Search is matched case insensitive against identifier and description
+	Search string
+}
+
+func (f *HookFilter) matches(h *Webhook) bool {
+	if f.Client != "" && h.client.Name != f.Client {
+		return false
+	}
+	if f.Provider != "" && h.Provider != f.Provider {
+		return false
+	}
+	for k, v := range f.Labels {
+		if l, ok := h.Labels[k]; !ok || (v != "" && l != v) {
+			return false
+		}
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(h.Identifier), search) && !strings.Contains(strings.ToLower(h.Description), search) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientHook is a hook together with the name of the client it belongs to
+type ClientHook struct {
+	Client string `json:"client"`
+	*Webhook
+}
+
+// FindHooks returns all hooks matching the filter, sorted by client and identifier
+func (s *Server) FindHooks(filter HookFilter) []ClientHook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hooks := make([]ClientHook, 0)
+	for _, c := range s.Clients {
+		for _, h := range c.Hooks {
+			if filter.matches(h) {
+				hooks = append(hooks, ClientHook{Client: c.Name, Webhook: h})
+			}
+		}
+	}
+
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Client != hooks[j].Client {
+			return hooks[i].Client < hooks[j].Client
+		}
+		return hooks[i].Identifier < hooks[j].Identifier
+	})
+
+	return hooks
+}
+
+// UpdateHookMetadata changes description, labels and provider of the hook identified by identifier of the given client
+func (s *Server) UpdateHookMetadata(clientname, identifier string, meta HookMetadata) (*Webhook, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = validateMetadata(meta); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	hook.applyMetadata(meta)
+	return hook, s.storeClient(hook.client)
+}
+
+func validateMetadata(meta HookMetadata) error {
+	if meta.Description != nil && len(*meta.Description) > maxDescriptionLength {
+		return &ErrInvalidMetadata{Message: "the description is longer than 1024 characters"}
+	}
+
+	if meta.Provider != nil && *meta.Provider != "" && !providerPattern.MatchString(*meta.Provider) {
+		return &ErrInvalidMetadata{Message: "provider '" + *meta.Provider + "' may only contain lowercase letters, digits, '-' and '_'"}
+	}
+
+	for k, v := range meta.Labels {
+		if len(k) > maxLabelLength || !labelPattern.MatchString(k) {
+			return &ErrInvalidMetadata{Message: "'" + k + "' is not a valid label"}
+		}
+		if len(v) > maxLabelLength || strings.ContainsAny(v, "\r\n") {
+			return &ErrInvalidMetadata{Message: "the value of label '" + k + "' is invalid"}
+		}
+	}
+
+	return nil
+}
+
+func (w *Webhook) applyMetadata(meta HookMetadata) {
+	if meta.Description != nil {
+		w.Description = *meta.Description
+	}
+	if meta.Provider != nil {
+		w.Provider = *meta.Provider
+	}
+
+	for k, v := range meta.Labels {
+		if v == "" {
+			delete(w.Labels, k)
+			continue
+		}
+		if w.Labels == nil {
+			w.Labels = make(map[string]string)
+		}
+		w.Labels[k] = v
+	}
+}
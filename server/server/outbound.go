@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// privateNetworks are only reached by requests to addresses clients chose if AllowPrivateNetworks is set
+var privateNetworks, _ = parseNetworks([]string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"})
+
+// ConfigureOutbound sets whether push targets, subscribers and WebSub hubs may be in private networks. ports are the
+// ports the server listens on, they are never reached on an address of this host
+func (s *Server) ConfigureOutbound(allowPrivate bool, ports ...int) {
+	s.allowPrivate = allowPrivate
+	s.listenPorts = make(map[int]bool)
+	for _, p := range ports {
+		if p > 0 {
+			s.listenPorts[p] = true
+		}
+	}
+}
+
+// dialer returns a dialer for requests to addresses clients chose. Addresses are checked after the host name is
+// resolved, so a name can't point to an address that is not allowed
+func (s *Server) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   s.checkOutbound,
+	}
+}
+
+// checkOutbound refuses connections to loopback, link-local, multicast and unspecified addresses, to private networks
+// unless they are allowed and to the listeners of the server
+func (s *Server) checkOutbound(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return &ErrForbiddenAddress{Address: address}
+	}
+	if !s.allowPrivate && containsIP(privateNetworks, ip) {
+		return &ErrForbiddenAddress{Address: address}
+	}
+
+	n, _ := strconv.Atoi(port)
+	if s.listenPorts[n] && isLocalAddress(ip) {
+		return &ErrForbiddenAddress{Address: address}
+	}
+	return nil
+}
+
+// isLocalAddress tells whether ip belongs to a network interface of this host. If the interfaces can't be read, every
+// address is treated as local
+func isLocalAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return true
+	}
+
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
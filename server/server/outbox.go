@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// outboxPrefix is the key prefix of calls waiting to be passed on to a broker, followed by the sink ID
+const outboxPrefix = delimeter + "Outbox" + delimeter
+
+const (
+	// outboxBatch is the number of calls read from an outbox at once
+	outboxBatch      = 100
+	minOutboxBackoff = time.Second
+	maxOutboxBackoff = time.Minute
+)
+
+// outbox passes the calls of a sink to a broker one after another. Calls are stored before they are passed on and
+// removed once the broker accepted them, so every call arrives at least once, even if the broker is down for a while or
+// the server restarts. A call that fails blocks the ones after it until it is passed on, keeping their order
+type outbox struct {
+	// wake signals calls that were added
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// outboxEntry is a call waiting in the outbox of a sink
+type outboxEntry struct {
+	// Delivery is the key of the delivery record the result is added to
+	Delivery   string    `json:"delivery"`
+	Hook       string    `json:"hook"`
+	HookUUID   string    `json:"hookUuid"`
+	Client     string    `json:"client"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Message    []byte    `json:"message"`
+}
+
+// openOutbox starts passing on the calls in the outbox of a sink to a broker. Other sinks have no outbox
+func (s *Server) openOutbox(sink *Sink) {
+	if !durableSinks[sink.Type] {
+		return
+	}
+	sink.outbox = &outbox{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go s.drainOutbox(sink)
+}
+
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) close() {
+	o.closeOnce.Do(func() { close(o.done) })
+}
+
+// enqueueSink puts a call into the outbox of its sink
+func (s *Server) enqueueSink(r *sinkRun) {
+	err := s.DB.appendJSON(outboxPrefix+r.sink.ID+delimeter, r.entry())
+	if err != nil {
+		log.Errorf("Could not store call for sink %s: %s", r.sink.ID, err.Error())
+		s.recordSinkResult(r.deliveryKey, SinkResult{Sink: r.sink.ID, Type: r.sink.Type, Outcome: OutcomeFailed, Error: err.Error()})
+		return
+	}
+	r.sink.outbox.notify()
+}
+
+// drainOutbox passes on the calls in the outbox of the sink until it is closed. After a failure it waits before trying
+// again, doubling the time up to a minute
+func (s *Server) drainOutbox(sink *Sink) {
+	backoff := minOutboxBackoff
+	for {
+		n, err := s.publishOutbox(sink)
+		if err == nil && n == outboxBatch {
+			// there might be more
+			continue
+		}
+
+		if err == nil {
+			backoff = minOutboxBackoff
+			select {
+			case <-sink.outbox.wake:
+			case <-sink.outbox.done:
+				return
+			}
+			continue
+		}
+
+		retry := time.NewTimer(backoff)
+		select {
+		case <-retry.C:
+		case <-sink.outbox.done:
+			retry.Stop()
+			return
+		}
+		backoff *= 2
+		if backoff > maxOutboxBackoff {
+			backoff = maxOutboxBackoff
+		}
+	}
+}
+
+// publishOutbox passes on a batch of calls from the outbox of the sink, oldest first, and returns how many it read.
+// It stops at the first call the broker didn't accept
+func (s *Server) publishOutbox(sink *Sink) (int, error) {
+	keys, entries, err := s.DB.Outbox(sink.ID, outboxBatch)
+	if err != nil {
+		log.Errorf("Could not read outbox of sink %s: %s", sink.ID, err.Error())
+		return 0, err
+	}
+
+	for i, e := range entries {
+		select {
+		case <-sink.outbox.done:
+			return i, nil
+		default:
+		}
+
+		result := SinkResult{Sink: sink.ID, Type: sink.Type, Outcome: OutcomeDelivered}
+		call, err := e.call()
+		if err != nil {
+			// the call can't be read, trying again won't change that
+			result.Outcome = OutcomeFailed
+			result.Error = err.Error()
+		} else if err = sink.driver.send(call); err != nil {
+			log.Warnf("Sink %s of %s failed, keeping the call: %s", sink.Type, e.Hook, err.Error())
+			s.recordSinkResult(e.Delivery, SinkResult{Sink: sink.ID, Type: sink.Type, Outcome: OutcomeQueued, Error: err.Error()})
+			return i, err
+		}
+
+		err = s.DB.Dequeue(keys[i])
+		if err != nil {
+			log.Errorf("Could not remove call from outbox of sink %s: %s", sink.ID, err.Error())
+			return i, err
+		}
+		s.recordSinkResult(e.Delivery, result)
+	}
+	return len(entries), nil
+}
+
+// Outbox returns the keys and up to limit calls in the outbox of a sink, oldest first
+func (db *DB) Outbox(sinkID string, limit int) ([]string, []*outboxEntry, error) {
+	keys := make([]string, 0)
+	entries := make([]*outboxEntry, 0)
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(outboxPrefix + sinkID + delimeter)
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && len(entries) < limit; it.Next() {
+			e := new(outboxEntry)
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, e)
+			})
+			if err != nil {
+				return err
+			}
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return keys, entries, err
+}
+
+// DeleteOutbox removes all calls in the outbox of a sink
+func (db *DB) DeleteOutbox(sinkID string) error {
+	return db.deletePrefix(outboxPrefix + sinkID + delimeter)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sinkFake is the type of sinks that pass calls on to a fakeDriver
+const sinkFake = "fake"
+
+// fakeDriver is a broker that refuses calls while it is down and records the deliveries of the calls it accepted
+type fakeDriver struct {
+	mu       sync.Mutex
+	down     bool
+	failures int
+	received []string
+}
+
+func (f *fakeDriver) send(call *SinkCall) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		f.failures++
+		return errors.New("broker is down")
+	}
+	f.received = append(f.received, call.Delivery)
+	return nil
+}
+
+func (f *fakeDriver) close() error {
+	return nil
+}
+
+func (f *fakeDriver) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+// calls returns the number of failed attempts and the deliveries of the accepted calls
+func (f *fakeDriver) calls() (int, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failures, append([]string(nil), f.received...)
+}
+
+// openFakeSinks makes sinks of type fake durable sinks passing their calls on to the returned driver
+func openFakeSinks(t *testing.T) *fakeDriver {
+	driver := &fakeDriver{}
+	sinkTypes[sinkFake] = func(sink *Sink) (sinkDriver, error) {
+		return driver, nil
+	}
+	durableSinks[sinkFake] = true
+	t.Cleanup(func() {
+		delete(sinkTypes, sinkFake)
+		delete(durableSinks, sinkFake)
+	})
+	return driver
+}
+
+// fakeSinkHook returns a hook of a new client with a fake sink
+func fakeSinkHook(t *testing.T, s *Server) (*Webhook, *Sink) {
+	_, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := s.AddHook("testclient", "test", HookOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks, err := s.SetHookSinks("testclient", "test", []*Sink{{Type: sinkFake}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook, sinks[0]
+}
+
+// callHooks calls the hook n times and returns the deliveries of the calls in order
+func callHooks(t *testing.T, s *Server, hook *Webhook, n int) []string {
+	deliveries := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "/"+hook.UUID, strings.NewReader(`{"n":`+strconv.Itoa(i)+`}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "127.0.0.1:49152"
+		_, err := s.HandleHook(hook.UUID, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveries = append(deliveries, req.Header.Get(DeliveryHeader))
+	}
+	return deliveries
+}
+
+// waitFor polls cond until it holds or testWait passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(testWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// outboxLength returns the number of calls left in the outbox of the sink
+func outboxLength(t *testing.T, s *Server, sink *Sink) int {
+	_, entries, err := s.DB.Outbox(sink.ID, outboxBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+// checkReceived checks that the driver accepted exactly the sent deliveries, in the order they were sent
+func checkReceived(t *testing.T, driver *fakeDriver, sent []string) {
+	_, received := driver.calls()
+	if strings.Join(received, ",") != strings.Join(sent, ",") {
+		t.Errorf("received %v, sent %v", received, sent)
+	}
+}
+
+// waitPassedOn waits until the driver accepted the sent calls, the outbox of the sink is empty and the deliveries of the
+// hook recorded it, then checks the order the calls arrived in
+func waitPassedOn(t *testing.T, s *Server, hook *Webhook, sink *Sink, driver *fakeDriver, sent []string) {
+	waitFor(t, "the calls to be passed on", func() bool {
+		_, received := driver.calls()
+		return len(received) == len(sent) && outboxLength(t, s, sink) == 0
+	})
+	waitFor(t, "the deliveries to be recorded", func() bool {
+		deliveries, err := s.DB.Deliveries(hook.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range deliveries {
+			if len(d.Sinks) != 1 || d.Sinks[0].Outcome != OutcomeDelivered {
+				return false
+			}
+		}
+		return len(deliveries) == len(sent)
+	})
+	checkReceived(t, driver, sent)
+}
+
+// TestOutboxBlocksOnFailure checks that calls wait in the outbox while the broker is down and are passed on in order
+// once it is back
+func TestOutboxBlocksOnFailure(t *testing.T) {
+	driver := openFakeSinks(t)
+	s := testServer(t.TempDir())
+	defer s.Stop()
+	hook, sink := fakeSinkHook(t, s)
+
+	driver.setDown(true)
+	sent := callHooks(t, s, hook, 3)
+	waitFor(t, "a failed attempt", func() bool {
+		failures, _ := driver.calls()
+		return failures > 0
+	})
+
+	// the first call failed, the others wait behind it
+	_, received := driver.calls()
+	if len(received) != 0 {
+		t.Errorf("received %v while the broker is down", received)
+	}
+	if n := outboxLength(t, s, sink); n != 3 {
+		t.Errorf("got %d calls in the outbox, want 3", n)
+	}
+
+	driver.setDown(false)
+	waitPassedOn(t, s, hook, sink, driver, sent)
+
+	// later calls go straight through
+	sent = append(sent, callHooks(t, s, hook, 2)...)
+	waitPassedOn(t, s, hook, sink, driver, sent)
+}
+
+// TestOutboxResumesAfterRestart checks that calls left in the outbox when the server stops are passed on in order after
+// it started again
+func TestOutboxResumesAfterRestart(t *testing.T) {
+	driver := openFakeSinks(t)
+	dir := t.TempDir()
+	s := testServer(dir)
+	hook, sink := fakeSinkHook(t, s)
+
+	driver.setDown(true)
+	sent := callHooks(t, s, hook, 3)
+	waitFor(t, "a failed attempt", func() bool {
+		failures, _ := driver.calls()
+		return failures > 0
+	})
+	s.Stop()
+
+	driver.setDown(false)
+	s = testServer(dir)
+	defer s.Stop()
+	s.Load()
+	waitPassedOn(t, s, hook, sink, driver, sent)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// OutputRaw passes calls on as raw HTTP requests
+	OutputRaw = "raw"
+	// OutputCloudEvents wraps calls in CloudEvents 1.0 structured mode JSON events
+	OutputCloudEvents = "cloudevents"
+)
+
+// FormatQuery is the query parameter of the ConnectPath that chooses the output format of a connection. It overrides
+// the output format of the hooks
+const FormatQuery = "format"
+
+// CloudEventsSpecVersion is the version of the CloudEvents specification the events follow
+const CloudEventsSpecVersion = "1.0"
+
+// defaultEventType is the type of CloudEvents for calls without any of the type headers
+const defaultEventType = "io.captainhook.call"
+
+// defaultTypeHeaders are the headers of common providers that name the event of a call
+var defaultTypeHeaders = []string{"X-GitHub-Event", "X-Gitlab-Event", "X-Gitea-Event", "X-Event-Key"}
+
+// Output decides in which format calls of a hook are passed on to connections that didn't choose one
+type Output struct {
+	// Format is raw or cloudevents. Defaults to raw
+	Format string `json:"format"`
+	// TypeHeaders are the headers the type of a CloudEvent is taken from, the first one that is set wins. Defaults to
+	// the event headers of GitHub, GitLab, Gitea and Bitbucket
+	TypeHeaders []string `json:"typeHeaders,omitempty"`
+	// TypePrefix is prepended to the value of the type header, e.g. com.github.
+	TypePrefix string `json:"typePrefix,omitempty"`
+}
+
+// CloudEvent is a call of a hook in CloudEvents 1.0 structured mode. JSON bodies are passed on as data, all other
+// bodies as data_base64
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+// Payload returns the body of the call the event wraps
+func (e *CloudEvent) Payload() ([]byte, error) {
+	if e.DataBase64 != "" {
+		return base64.StdEncoding.DecodeString(e.DataBase64)
+	}
+	return e.Data, nil
+}
+
+// SetHookOutput replaces the output format of the webhook identified by identifier of the given client. nil passes
+// calls on as raw requests
+func (s *Server) SetHookOutput(clientname, identifier string, output *Output) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	if output != nil {
+		if output.Format == "" {
+			output.Format = OutputRaw
+		}
+		if !ValidOutputFormat(output.Format) {
+			err = &ErrInvalidOutput{Message: "unknown format '" + output.Format + "'"}
+			log.Error(err)
+			return err
+		}
+		for _, h := range output.TypeHeaders {
+			if strings.TrimSpace(h) == "" {
+				err = &ErrInvalidOutput{Message: "type headers must not be empty"}
+				log.Error(err)
+				return err
+			}
+		}
+	}
+
+	hook.Output = output
+	return s.storeClient(hook.client)
+}
+
+// ValidOutputFormat tells whether calls can be passed on in the given format
+func ValidOutputFormat(format string) bool {
+	return format == OutputRaw || format == OutputCloudEvents
+}
+
+// outputFormat returns the format calls of the hook are passed on in to connections that didn't choose one
+func (w *Webhook) outputFormat() string {
+	if w.Output == nil || w.Output.Format == "" {
+		return OutputRaw
+	}
+	return w.Output.Format
+}
+
+// format returns msg, a serialized request of the hook, in the given format
+func (w *Webhook) format(msg []byte, format string) ([]byte, error) {
+	if format != OutputCloudEvents {
+		return msg, nil
+	}
+
+	event, err := w.cloudEvent(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(event)
+}
+
+// cloudEvent wraps a serialized request of the hook. The id is the delivery ID and the source the URL of the hook
+func (w *Webhook) cloudEvent(msg []byte) (*CloudEvent, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(msg)))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	event := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              req.Header.Get(DeliveryHeader),
+		Source:          w.URL,
+		Type:            w.eventType(req.Header),
+		DataContentType: req.Header.Get("Content-Type"),
+	}
+
+	if len(body) == 0 {
+		return event, nil
+	}
+	if isJSON(event.DataContentType) && json.Valid(body) {
+		event.Data = body
+	} else {
+		event.DataBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	return event, nil
+}
+
+// eventType derives the type of a CloudEvent from the headers of the call. Events of CaptainHook itself, like an
+// expired hook, are typed io.captainhook.<event>
+func (w *Webhook) eventType(header http.Header) string {
+	if event := header.Get(EventHeader); event != "" {
+		return "io.captainhook." + event
+	}
+
+	headers := defaultTypeHeaders
+	prefix := ""
+	if w.Output != nil {
+		if len(w.Output.TypeHeaders) > 0 {
+			headers = w.Output.TypeHeaders
+		}
+		prefix = w.Output.TypePrefix
+	}
+
+	for _, h := range headers {
+		if v := header.Get(h); v != "" {
+			return prefix + v
+		}
+	}
+	return defaultEventType
+}
+
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
@@ -0,0 +1,210 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// bufferPrefix is the key prefix of requests buffered while a hook is paused, followed by the hook uuid
+const bufferPrefix = delimeter + "Buffer" + delimeter
+
+const (
+	// PauseReject answers calls of a paused hook with the configured status
+	PauseReject = "reject"
+	// PauseBuffer accepts calls of a paused hook and passes them on when it is resumed
+	PauseBuffer = "buffer"
+)
+
+const (
+	// OutcomePaused means the hook was paused and the call was rejected
+	OutcomePaused = "paused"
+	// OutcomeBuffered means the hook was paused and the call waits until it is resumed
+	OutcomeBuffered = "buffered"
+)
+
+// Pause describes whether a hook is paused and what happens to its calls meanwhile
+type Pause struct {
+	Paused bool `json:"paused"`
+	// Action is reject or buffer. Defaults to reject
+	Action string `json:"action"`
+	// Status is sent to callers if calls are rejected. Defaults to 503
+	Status int       `json:"status"`
+	Since  time.Time `json:"since"`
+}
+
+// ResumeResult tells how many buffered calls were passed on after a hook was resumed
+type ResumeResult struct {
+	Replayed int `json:"replayed"`
+}
+
+// PauseHook pauses the hook identified by identifier of the given client. Its UUID and URL stay the same
+func (s *Server) PauseHook(clientname, identifier string, pause Pause) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	switch pause.Action {
+	case "":
+		pause.Action = PauseReject
+	case PauseReject, PauseBuffer:
+	default:
+		err = &ErrInvalidPolicy{Message: "unknown pause action '" + pause.Action + "'"}
+		log.Error(err)
+		return err
+	}
+
+	if pause.Status == 0 {
+		pause.Status = http.StatusServiceUnavailable
+	}
+	if pause.Status < 400 || pause.Status > 599 {
+		err = &ErrInvalidPolicy{Message: "status " + strconv.Itoa(pause.Status) + " of a paused hook has to be an error status"}
+		log.Error(err)
+		return err
+	}
+
+	// a running resume finishes first, it would clear the pause otherwise
+	hook.resumeMu.Lock()
+	defer hook.resumeMu.Unlock()
+	hook.pauseMu.Lock()
+	defer hook.pauseMu.Unlock()
+
+	pause.Paused = true
+	pause.Since = time.Now()
+	if hook.Pause.Paused {
+		pause.Since = hook.Pause.Since
+	}
+
+	hook.Pause = pause
+	return s.storeClient(hook.client)
+}
+
+// ResumeHook resumes the hook identified by identifier of the given client and passes on all calls buffered meanwhile.
+// The hook keeps buffering until the buffer is drained, so live calls cannot overtake buffered ones
+func (s *Server) ResumeHook(clientname, identifier string) (*ResumeResult, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	hook.resumeMu.Lock()
+	defer hook.resumeMu.Unlock()
+
+	hook.pauseMu.Lock()
+	if hook.Pause.Paused {
+		hook.Pause.Action = PauseBuffer
+	}
+	hook.pauseMu.Unlock()
+
+	prefix := bufferPrefix + hook.UUID + delimeter
+	replayed := 0
+	for {
+		keys, requests, err := s.DB.requests(prefix)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+
+		for i, q := range requests {
+			s.replay(hook, q)
+			replayed++
+
+			err = s.DB.Dequeue(keys[i])
+			if err != nil {
+				log.Errorf("Could not remove buffered request: %s", err.Error())
+			}
+		}
+
+		// calls are buffered under pauseMu, so an empty buffer stays empty once the pause is cleared
+		hook.pauseMu.Lock()
+		n, err := s.DB.countPrefix(prefix)
+		if err != nil {
+			hook.pauseMu.Unlock()
+			log.Error(err)
+			return nil, err
+		}
+		if n == 0 {
+			hook.Pause = Pause{}
+			err = s.storeClient(hook.client)
+			hook.pauseMu.Unlock()
+			if err != nil {
+				log.Error(err)
+				return nil, err
+			}
+			return &ResumeResult{Replayed: replayed}, nil
+		}
+		hook.pauseMu.Unlock()
+	}
+}
+
+// pause returns the current pause of w
+func (w *Webhook) pause() Pause {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	return w.Pause
+}
+
+// buffer stores a call of a paused hook until it is resumed
+func (s *Server) buffer(hook *Webhook, d *Delivery, msg []byte) error {
+	prefix := bufferPrefix + hook.UUID + delimeter
+	n, err := s.DB.countPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	if s.queueLimit > 0 && n >= s.queueLimit {
+		return &ErrQueueFull{Client: hook.client.Name, Limit: s.queueLimit}
+	}
+
+	return s.DB.appendRequest(prefix, &queuedRequest{
+		Delivery: d.key,
+		Message:  msg,
+	})
+}
+
+// replay passes on a buffered call. Its caller was answered already, so without receiver it is queued or dropped
+func (s *Server) replay(hook *Webhook, q *queuedRequest) {
+	outcome := OutcomeDelivered
+	receivers, pushes, missed := hook.broadcast(q.Message, q.Delivery)
+	// the time the call was received at is read from its delivery below
+	sinks := hook.sinkRuns(q.Message, q.Delivery, time.Time{})
+	queued := s.enqueueAll(queuedFor(s.noReceiverPolicy(hook), missed), q.Delivery, q.Message)
+	if receivers == 0 {
+		outcome = OutcomeDropped
+		if len(pushes) > 0 || len(sinks) > 0 {
+			outcome = OutcomePushing
+		} else if queued > 0 {
+			outcome = OutcomeQueued
+		}
+	}
+
+	err := s.DB.UpdateDelivery(q.Delivery, func(d *Delivery) {
+		d.Outcome = outcome
+		d.Receivers = receivers
+		if len(pushes) > 0 {
+			d.Targets = pendingResults(pushes)
+		}
+		if len(sinks) > 0 {
+			d.Sinks = pendingSinkResults(sinks)
+		}
+		for _, r := range sinks {
+			r.receivedAt = d.ReceivedAt
+		}
+	})
+	if err != nil {
+		log.Errorf("Could not update delivery: %s", err.Error())
+	}
+	s.startPushes(pushes)
+	s.startSinks(sinks)
+}
+
+// DeleteBuffer removes all calls buffered for a hook
+func (db *DB) DeleteBuffer(hookUUID string) error {
+	return db.deletePrefix(bufferPrefix + hookUUID + delimeter)
+}
@@ -0,0 +1,512 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// SignatureHeader contains the signature of a pushed request, sha256= followed by the hex encoded HMAC of the
+// TimestampHeader, a dot and the body, keyed with the secret of the target. See Sign
+const SignatureHeader = "X-CaptainHook-Signature"
+
+// TimestampHeader contains the unix time a request was pushed at
+const TimestampHeader = "X-CaptainHook-Timestamp"
+
+// OutcomePushing means the request is being pushed to the targets of the clients
+const OutcomePushing = "pushing"
+
+const (
+	defaultPushTimeout  = 10
+	defaultPushAttempts = 5
+	defaultPushBackoff  = 1
+	maxPushAttempts     = 20
+	// maxPushBackoff is the longest time a push waits between its attempts in total
+	maxPushBackoff = time.Hour
+)
+
+// hopHeaders are not passed on to targets, they belong to the connection of the caller
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// Target is a URL calls are pushed to by HTTP, for clients that can't keep a websocket open. Every call is signed
+// with the secret of the target, see SignatureHeader
+type Target struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Format is raw or cloudevents. Defaults to the output format of the hook
+	Format string `json:"format,omitempty"`
+	// Timeout of a single attempt in seconds. Defaults to 10
+	Timeout int `json:"timeout"`
+	// Attempts is how often a call is tried, including the first try. Defaults to 5
+	Attempts int `json:"attempts"`
+	// Backoff is the time in seconds before the first retry, it doubles with every further retry. Defaults to 1.
+	// A push gives up before it would wait more than an hour in total
+	Backoff int `json:"backoff"`
+	// InsecureSkipVerify accepts any certificate of the target
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CACert contains PEM encoded certificates the target is verified with besides the system ones
+	CACert string `json:"caCert,omitempty"`
+	// Secret signs the pushed calls. It is generated if it is not set
+	Secret string `json:"secret"`
+	http   *http.Client
+}
+
+// TargetResult records what happened to a call pushed to a target
+type TargetResult struct {
+	Target   string `json:"target"`
+	URL      string `json:"url"`
+	Outcome  string `json:"outcome"`
+	Status   int    `json:"status,omitempty"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// push is a call on its way to a target of a client
+type push struct {
+	client *Client
+	target *Target
+	hook   *Webhook
+	msg    []byte
+	// deliveryKey is the key of the delivery record the result is added to, empty for events of CaptainHook itself
+	deliveryKey string
+	// attempts were made before the server restarted
+	attempts int
+}
+
+// AddTarget adds a push target to the given client and returns it with its secret
+func (s *Server) AddTarget(clientname string, target Target) (*Target, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	err := target.prepare(s.dialer())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	target.ID = id.String()
+
+	err = target.generateSecret()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// calls being passed on may still read the old list
+	targets := make([]*Target, 0, len(client.Targets)+1)
+	client.Targets = append(append(targets, client.Targets...), &target)
+	return &target, s.DB.Store(client)
+}
+
+// RemoveTarget removes the push target identified by id from the given client
+func (s *Server) RemoveTarget(clientname, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return err
+	}
+
+	targets := make([]*Target, 0, len(client.Targets))
+	for _, t := range client.Targets {
+		if t.ID != id {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == len(client.Targets) {
+		err := &ErrTargetNotExists{ID: id}
+		log.Error(err)
+		return err
+	}
+
+	client.Targets = targets
+	return s.DB.Store(client)
+}
+
+// GetTargets returns the push targets of the given client
+func (s *Server) GetTargets(clientname string) ([]*Target, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	return append(make([]*Target, 0, len(client.Targets)), client.Targets...), nil
+}
+
+// generateSecret sets a random secret if the target has none
+func (t *Target) generateSecret() error {
+	if t.Secret != "" {
+		return nil
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return err
+	}
+	t.Secret = secret
+	return nil
+}
+
+// randomSecret returns a random secret to sign requests with
+func randomSecret() (string, error) {
+	b := make([]byte, secretByteLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", &ErrSecretGenerationFailed{Message: err.Error()}
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// prepare validates the target, fills in the defaults and sets up its http client, which connects with dialer
+func (t *Target) prepare(dialer *net.Dialer) error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ErrInvalidTarget{Message: "'" + t.URL + "' needs a http or https scheme and a host"}
+	}
+	if t.Format != "" && !ValidOutputFormat(t.Format) {
+		return &ErrInvalidTarget{Message: "unknown format '" + t.Format + "'"}
+	}
+	if t.Timeout < 0 || t.Attempts < 0 || t.Backoff < 0 {
+		return &ErrInvalidTarget{Message: "timeout, attempts and backoff must not be negative"}
+	}
+	if t.Attempts > maxPushAttempts {
+		return &ErrInvalidTarget{Message: "at most " + strconv.Itoa(maxPushAttempts) + " attempts are allowed"}
+	}
+	if time.Duration(t.Backoff)*time.Second > maxPushBackoff {
+		return &ErrInvalidTarget{Message: "backoff must not be longer than " + maxPushBackoff.String()}
+	}
+
+	if t.Timeout == 0 {
+		t.Timeout = defaultPushTimeout
+	}
+	if t.Attempts == 0 {
+		t.Attempts = defaultPushAttempts
+	}
+	if t.Backoff == 0 {
+		t.Backoff = defaultPushBackoff
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(t.CACert)) {
+			return &ErrInvalidTarget{Message: "caCert contains no PEM encoded certificate"}
+		}
+		cfg.RootCAs = pool
+	}
+
+	t.http = &http.Client{
+		Timeout:   time.Duration(t.Timeout) * time.Second,
+		Transport: &http.Transport{TLSClientConfig: cfg, DialContext: dialer.DialContext},
+		// a redirect would lose the signature of the call
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return nil
+}
+
+// prepareTargets sets up the targets of all clients after loading them. Targets that became invalid are dropped. The
+// caller holds s.mu
+func (s *Server) prepareTargets() {
+	for _, c := range s.Clients {
+		targets := make([]*Target, 0, len(c.Targets))
+		for _, t := range c.Targets {
+			err := t.prepare(s.dialer())
+			if err != nil {
+				log.Warnf("Dropping target %s of %s: %s", t.ID, c.Name, err.Error())
+				continue
+			}
+			targets = append(targets, t)
+		}
+		c.Targets = targets
+	}
+}
+
+// pushes returns a push to every target of the client for msg, a serialized request of hook
+func (c *Client) pushes(hook *Webhook, msg []byte, deliveryKey string) []*push {
+	pushes := make([]*push, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		pushes = append(pushes, &push{client: c, target: t, hook: hook, msg: msg, deliveryKey: deliveryKey})
+	}
+	return pushes
+}
+
+// pendingResults returns the results of pushes that just started
+func pendingResults(pushes []*push) []TargetResult {
+	results := make([]TargetResult, 0, len(pushes))
+	for _, p := range pushes {
+		results = append(results, TargetResult{Target: p.target.ID, URL: p.target.URL, Outcome: OutcomePushing})
+	}
+	return results
+}
+
+// startPushes sends the pushes in the background
+func (s *Server) startPushes(pushes []*push) {
+	for _, p := range pushes {
+		go s.push(p)
+	}
+}
+
+// push sends a call to its target until it is accepted, the attempts are used up or the backoff would get too long.
+// Every attempt is recorded, so pushes that are cut short by a restart continue with the attempts that are left
+func (s *Server) push(p *push) {
+	result := TargetResult{Target: p.target.ID, URL: p.target.URL, Outcome: OutcomePushing, Attempts: p.attempts}
+
+	for result.Outcome == OutcomePushing {
+		if result.Attempts > 0 {
+			delay, ok := p.target.retryDelay(result.Attempts)
+			if !ok {
+				log.Warnf("Giving up push of %s to %s after %d attempts, the backoff would exceed %s", p.hook.Identifier, p.target.URL, result.Attempts, maxPushBackoff)
+				result.Outcome = OutcomeFailed
+				s.recordPush(p, result)
+				break
+			}
+			time.Sleep(delay)
+		}
+		result.Attempts++
+
+		status, err := p.send()
+		result.Status = status
+		result.Error = ""
+		if err == nil && status >= 200 && status < 300 {
+			result.Outcome = OutcomeDelivered
+		} else {
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Error = http.StatusText(status)
+			}
+			log.Warnf("Push of %s to %s failed (attempt %d of %d): %s", p.hook.Identifier, p.target.URL, result.Attempts, p.target.Attempts, result.Error)
+
+			// the target refused the call, trying again won't change that
+			if result.Attempts >= p.target.Attempts || (err == nil && refused(status)) {
+				result.Outcome = OutcomeFailed
+			}
+		}
+
+		s.recordPush(p, result)
+	}
+}
+
+// recordPush stores the result of an attempt in the delivery of the push
+func (s *Server) recordPush(p *push, result TargetResult) {
+	if p.deliveryKey == "" {
+		return
+	}
+	err := s.DB.UpdateDelivery(p.deliveryKey, func(d *Delivery) {
+		d.recordPush(result)
+	})
+	if err != nil {
+		log.Errorf("Could not update delivery: %s", err.Error())
+	}
+}
+
+// retryDelay returns the time to wait before the next attempt after attempts failed ones. It is false if the push
+// would wait longer than maxPushBackoff in total
+func (t *Target) retryDelay(attempts int) (time.Duration, bool) {
+	backoff := time.Duration(t.Backoff) * time.Second
+	var waited time.Duration
+	for i := 1; i < attempts; i++ {
+		waited += backoff
+		backoff *= 2
+		if waited > maxPushBackoff {
+			return 0, false
+		}
+	}
+	return backoff, waited+backoff <= maxPushBackoff
+}
+
+// resumePushes continues pushing the calls that were on their way to targets when the server stopped, with the
+// attempts that are left. Pushes to targets that are gone are marked failed. The caller holds s.mu
+func (s *Server) resumePushes() {
+	for _, h := range s.Hooks {
+		deliveries, err := s.DB.Deliveries(h.UUID)
+		if err != nil {
+			log.Errorf("Could not read deliveries of %s: %s", h.Identifier, err.Error())
+			continue
+		}
+
+		for _, d := range deliveries {
+			pushes := make([]*push, 0)
+			for _, r := range d.Targets {
+				if r.Outcome != OutcomePushing {
+					continue
+				}
+				client, target := h.target(r.Target)
+				if target == nil || d.Request == nil {
+					r.Outcome = OutcomeFailed
+					r.Error = "the target was removed"
+					s.recordPush(&push{deliveryKey: d.key}, r)
+					continue
+				}
+				pushes = append(pushes, &push{client: client, target: target, hook: h, msg: d.Request, deliveryKey: d.key, attempts: r.Attempts})
+			}
+			s.startPushes(pushes)
+		}
+	}
+}
+
+// target returns the target identified by id of one of the clients of the hook together with its client
+func (w *Webhook) target(id string) (*Client, *Target) {
+	for _, c := range w.clients() {
+		for _, t := range c.Targets {
+			if t.ID == id {
+				return c, t
+			}
+		}
+	}
+	return nil, nil
+}
+
+// refused tells whether a target answered with a status that won't change if the request is tried again
+func refused(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests && status != http.StatusRequestTimeout
+}
+
+// recordPush adds the result of a push to the delivery. The delivery failed if no receiver got it and nothing is left
+// to pass it on to
+func (d *Delivery) recordPush(result TargetResult) {
+	for i := range d.Targets {
+		if d.Targets[i].Target == result.Target && d.Targets[i].Outcome == OutcomePushing {
+			d.Targets[i] = result
+		}
+	}
+
+	if result.Outcome == OutcomeDelivered {
+		d.Outcome = OutcomeDelivered
+		d.Receivers++
+	} else {
+		d.settle()
+	}
+}
+
+// send makes a single attempt to push the call and returns the status the target answered with
+func (p *push) send() (int, error) {
+	req, err := p.request()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := p.target.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// request builds the signed request to the target. Raw calls keep their method, headers and query parameters,
+// CloudEvents are posted
+func (p *push) request() (*http.Request, error) {
+	call, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(p.msg)))
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(p.target.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	format := p.target.Format
+	if format == "" {
+		format = p.hook.outputFormat()
+	}
+
+	var req *http.Request
+	var body []byte
+	if format == OutputCloudEvents {
+		body, err = p.hook.format(p.msg, format)
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+		req.Header.Set(DeliveryHeader, call.Header.Get(DeliveryHeader))
+	} else {
+		body, err = ioutil.ReadAll(call.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		q := u.Query()
+		for k, values := range call.URL.Query() {
+			for _, v := range values {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+
+		req, err = http.NewRequest(call.Method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = call.Header.Clone()
+		for _, h := range hopHeaders {
+			req.Header.Del(h)
+		}
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(p.target.Secret, timestamp, body))
+	return req, nil
+}
+
+// Sign returns the SignatureHeader of a pushed request with the given TimestampHeader and body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
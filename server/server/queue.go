@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gin-gonic/gin"
+	"github.com/olahol/melody"
+)
+
+// queuePrefix is the key prefix of queued requests, followed by the client ID
+const queuePrefix = delimeter + "Queue" + delimeter
+
+// defaultMessageBuffer is the number of messages a connection buffers besides the queued ones
+const defaultMessageBuffer = 256
+
+var queueSequence uint32
+
+// updateRetries is how often an update of a delivery is retried if it conflicts with another one
+const updateRetries = 5
+
+// queuedRequest is a request that waits for a receiver of the client
+type queuedRequest struct {
+	Delivery string `json:"delivery"`
+	Message  []byte `json:"message"`
+}
+
+// Connect opens a websocket for the client. Requests that were queued while nobody was connected are sent first.
+// format is the output format of the connection, or empty to use the one of each hook
+func (s *Server) Connect(client *Client, con *gin.Context, format string) {
+	client.OpenWebsocket(con, s.queueLimit+defaultMessageBuffer, format, func(sess *melody.Session) {
+		s.flushQueue(client, format, func(q *queuedRequest) error {
+			return sess.Write(q.Message)
+		})
+	})
+}
+
+// enqueue stores a request until a receiver of the client connects. deliveryKey is the key of its delivery record
+func (s *Server) enqueue(client *Client, deliveryKey string, msg []byte) error {
+	n, err := s.DB.QueueLength(client.ID)
+	if err != nil {
+		return err
+	}
+	if s.queueLimit > 0 && n >= s.queueLimit {
+		return &ErrQueueFull{Client: client.Name, Limit: s.queueLimit}
+	}
+
+	return s.DB.Enqueue(client.ID, &queuedRequest{
+		Delivery: deliveryKey,
+		Message:  msg,
+	})
+}
+
+// enqueueAll queues a request for each of the clients independently and returns for how many it was queued
+func (s *Server) enqueueAll(clients []*Client, deliveryKey string, msg []byte) int {
+	queued := 0
+	for _, c := range clients {
+		err := s.enqueue(c, deliveryKey, msg)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		queued++
+	}
+	return queued
+}
+
+// flushQueue sends all queued requests of the client to a new connection in its format using write and marks their
+// deliveries as delivered
+func (s *Server) flushQueue(client *Client, format string, write func(q *queuedRequest) error) {
+	keys, requests, err := s.DB.Queue(client.ID)
+	if err != nil {
+		log.Errorf("Could not read queue of %s: %s", client.Name, err.Error())
+		return
+	}
+
+	for i, q := range requests {
+		err = write(&queuedRequest{Delivery: q.Delivery, Message: s.formatQueued(q, format)})
+		if err != nil {
+			log.Errorf("Could not send queued request: %s", err.Error())
+			return
+		}
+
+		err = s.DB.Dequeue(keys[i])
+		if err != nil {
+			log.Errorf("Could not remove queued request: %s", err.Error())
+		}
+
+		err = s.DB.UpdateDelivery(q.Delivery, func(d *Delivery) {
+			d.Outcome = OutcomeDelivered
+			d.Receivers++
+		})
+		if err != nil {
+			log.Errorf("Could not update delivery: %s", err.Error())
+		}
+	}
+
+	if len(requests) > 0 {
+		log.Infof("Sent %d queued requests to %s", len(requests), client.Name)
+	}
+}
+
+// formatQueued returns a queued request in the given format, or in the format of its hook if format is empty. Requests
+// of hooks that were removed in the meantime are sent raw
+func (s *Server) formatQueued(q *queuedRequest, format string) []byte {
+	hook := s.queuedHook(q)
+	if hook == nil {
+		return q.Message
+	}
+
+	if format == "" {
+		format = hook.outputFormat()
+	}
+	msg, err := hook.format(q.Message, format)
+	if err != nil {
+		log.Errorf("Could not format queued request as %s: %s", format, err.Error())
+		return q.Message
+	}
+	return msg
+}
+
+// queuedHook returns the hook a request was sent to, or nil if it was removed or the request is an event without delivery
+func (s *Server) queuedHook(q *queuedRequest) *Webhook {
+	if q.Delivery == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Hooks[firstSegment(strings.TrimPrefix(q.Delivery, deliveryPrefix))]
+}
+
+// Enqueue appends a request to the queue of a client
+func (db *DB) Enqueue(clientID string, q *queuedRequest) error {
+	return db.appendRequest(queuePrefix+clientID+delimeter, q)
+}
+
+// Queue returns the keys and the queued requests of a client, oldest first
+func (db *DB) Queue(clientID string) ([]string, []*queuedRequest, error) {
+	return db.requests(queuePrefix + clientID + delimeter)
+}
+
+// QueueLength returns the number of queued requests of a client
+func (db *DB) QueueLength(clientID string) (int, error) {
+	return db.countPrefix(queuePrefix + clientID + delimeter)
+}
+
+// Dequeue removes a queued or buffered request
+func (db *DB) Dequeue(key string) error {
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+// DeleteQueue removes all queued requests of a client
+func (db *DB) DeleteQueue(clientID string) error {
+	return db.deletePrefix(queuePrefix + clientID + delimeter)
+}
+
+// appendRequest stores a request under prefix, ordered by the time it was stored
+func (db *DB) appendRequest(prefix string, q *queuedRequest) error {
+	return db.appendJSON(prefix, q)
+}
+
+// appendJSON stores v as JSON under prefix, ordered by the time it was stored
+func (db *DB) appendJSON(prefix string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	seq := atomic.AddUint32(&queueSequence, 1) % 1000000
+	key := fmt.Sprintf("%s%020d%06d", prefix, time.Now().UnixNano(), seq)
+
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), b)
+	})
+}
+
+// requests returns the keys and the requests stored under prefix, oldest first
+func (db *DB) requests(p string) ([]string, []*queuedRequest, error) {
+	keys := make([]string, 0)
+	requests := make([]*queuedRequest, 0)
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(p)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			q := new(queuedRequest)
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, q)
+			})
+			if err != nil {
+				return err
+			}
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+			requests = append(requests, q)
+		}
+		return nil
+	})
+	return keys, requests, err
+}
+
+// countPrefix returns the number of keys starting with prefix
+func (db *DB) countPrefix(p string) (int, error) {
+	n := 0
+	err := db.bdb.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(p)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// UpdateDelivery changes the delivery record stored at key
+func (db *DB) UpdateDelivery(key string, update func(d *Delivery)) error {
+	err := db.updateDelivery(key, update)
+	// pushes to several targets finish at the same time
+	for i := 0; err == badger.ErrConflict && i < updateRetries; i++ {
+		err = db.updateDelivery(key, update)
+	}
+	return err
+}
+
+func (db *DB) updateDelivery(key string, update func(d *Delivery)) error {
+	return db.bdb.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err == badger.ErrKeyNotFound {
+			// the record might be pruned already
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		d := new(Delivery)
+		err = item.Value(func(v []byte) error {
+			return json.Unmarshal(v, d)
+		})
+		if err != nil {
+			return err
+		}
+
+		update(d)
+		return setJSON(txn, key, d)
+	})
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response is the answer the caller of a hook receives after the request was passed on.
+// Some providers expect a specific status, header or body
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// defaultResponse is sent if a hook has no response configured
+var defaultResponse = &Response{Status: http.StatusOK}
+
+// SetHookResponse sets the response the callers of the hook identified by identifier of the given client receive. nil restores the empty 200
+func (s *Server) SetHookResponse(clientname, identifier string, resp *Response) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	if resp != nil {
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		if err = validateResponse(resp); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	hook.Response = resp
+	s.mu.Unlock()
+	return s.storeClient(hook.client)
+}
+
+func validateResponse(resp *Response) error {
+	if resp.Status < 100 || resp.Status > 599 {
+		return &ErrInvalidResponse{Message: "status " + strconv.Itoa(resp.Status) + " is not a valid http status"}
+	}
+
+	for k, v := range resp.Headers {
+		if k == "" || strings.ContainsAny(k, " :\r\n") {
+			return &ErrInvalidResponse{Message: "'" + k + "' is not a valid header name"}
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return &ErrInvalidResponse{Message: "header '" + k + "' contains a line break"}
+		}
+	}
+
+	return nil
+}
+
+// response returns the response for the caller of the hook. Responses are replaced as a whole, never changed in place
+func (s *Server) response(w *Webhook) *Response {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if w.Response == nil {
+		return defaultResponse
+	}
+	return w.Response
+}
@@ -7,12 +7,18 @@ It is licensed under the MIT License
 package server
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // VersionPath is the base path for most API calls. This has to be changed if there are major changes in the API that break compatibility
@@ -30,6 +36,93 @@ const HookPath = VersionPath + "/hooks"
 // ConnectPath is the REST-path to where clients can connect to a websocket to receive webhooks
 const ConnectPath = VersionPath + "/connect"
 
+// TargetPath is the REST-path where clients manage the targets calls are pushed to by HTTP
+const TargetPath = VersionPath + "/targets"
+
+// TargetsPath is appended to the path of a client to manage the targets its calls are pushed to by HTTP
+const TargetsPath = "/targets"
+
+// StreamPath is the REST-path where clients receive their webhooks as server-sent events
+const StreamPath = VersionPath + "/stream"
+
+// PollPath is the REST-path where clients wait for their webhooks by long-polling
+const PollPath = VersionPath + "/deliveries"
+
+// RewriteURLsPath is the REST-path to update the URLs of all hooks after the public base URL changed
+const RewriteURLsPath = VersionPath + "/rewriteURLs"
+
+// AuditPath is the REST-path to query the audit log
+const AuditPath = VersionPath + "/audit"
+
+// FsckPath is the REST-path to check the database for orphaned keys and remove them
+const FsckPath = VersionPath + "/db/fsck"
+
+// StatsPath is the REST-path to get the counters of the external hook endpoint
+const StatsPath = VersionPath + "/stats"
+
+// LimitsPath is appended to the path of a hook to manage its limits
+const LimitsPath = "/limits"
+
+// SlugPath is appended to the path of a hook to manage its vanity path
+const SlugPath = "/slug"
+
+// AliasesPath is appended to the path of a hook to remove the aliases of previous slugs
+const AliasesPath = "/aliases"
+
+// ResponsePath is appended to the path of a hook to manage the response its callers receive
+const ResponsePath = "/response"
+
+// FilterPath is appended to the path of a hook to manage which calls are passed on
+const FilterPath = "/filter"
+
+// TransformsPath is appended to the path of a hook to manage how calls are changed before they are passed on
+const TransformsPath = "/transforms"
+
+// DryRunPath is appended to the TransformsPath of a hook to test transforms against a stored delivery
+const DryRunPath = "/dryrun"
+
+// OutputPath is appended to the path of a hook to manage the format calls are passed on in
+const OutputPath = "/output"
+
+// SinksPath is appended to the path of a hook or client to manage the sinks its calls are passed on to besides the
+// receivers, like commands on the server or message brokers. It is only available on the internal API, as sinks run
+// commands and write files on the server
+const SinksPath = "/sinks"
+
+// NoReceiverPath is appended to the path of a hook to manage what happens to calls while no receiver is connected
+const NoReceiverPath = "/noreceiver"
+
+// DeliveriesPath is appended to the path of a hook to get its recent calls and their outcome
+const DeliveriesPath = "/deliveries"
+
+// PausePath is appended to the path of a hook to pause and resume it
+const PausePath = "/pause"
+
+// SubscribersPath is appended to the path of a hook to share it with other clients, or to the path of a topic to
+// manage the URLs its events are posted to
+const SubscribersPath = "/subscribers"
+
+// TopicPath is the REST-path where clients publish events to their topics and manage the subscribers
+const TopicPath = VersionPath + "/topics"
+
+// TopicsPath is appended to the path of a client to get its topics and their events
+const TopicsPath = "/topics"
+
+// EventsPath is appended to the path of a topic to publish events and get the recent ones with their delivery
+const EventsPath = "/events"
+
+// DeadLettersPath is appended to the path of a topic to get the events a subscriber did not accept
+const DeadLettersPath = "/deadletters"
+
+// RedeliverPath is appended to the path of an event to post it again to the subscribers that did not accept it
+const RedeliverPath = "/redeliver"
+
+// WebSubPath is appended to the path of a hook to subscribe it to a topic of a WebSub hub
+const WebSubPath = "/websub"
+
+// IPFilterPath is appended to the path of a hook to manage its ip filter
+const IPFilterPath = "/ipfilter"
+
 // ExternalHookPath is the path external applications will call to notify a webhook
 const ExternalHookPath = "/h"
 
@@ -38,25 +131,34 @@ const ApplicationVersionPath = "/version"
 
 // SetupAPI will set up the HTTP-REST-API server without SSL. extPort is the Port the public interfaces will listen to, intPort will be used for private connection, e.g. the CLI
 func SetupAPI(hostname string, extPort, intPort int, server *Server) {
-	setupExternalRouter(hostname, extPort, 0, server, "", "")
+	setupExternalRouter(hostname, extPort, 0, server, nil, nil)
 	setupInternalRouter(intPort, server)
 }
 
 // SetupSSLAPI will set up the HTTP-REST-API server with SSL. extPort will redirect everything to HTTPS,
-// extSSLPort is the Port the public interfaces will listen to, intPort will be used for private connection, e.g. the CLI
-func SetupSSLAPI(hostname string, extPort, extSSLPort, intPort int, server *Server, sslCertFile, sslKeyFile string) {
-	setupExternalRouter(hostname, extPort, extSSLPort, server, sslCertFile, sslKeyFile)
+// extSSLPort is the Port the public interfaces will listen to, intPort will be used for private connection, e.g. the CLI.
+// grpcPort serves the gRPC API with the same certificates, 0 disables it
+func SetupSSLAPI(hostname string, extPort, extSSLPort, intPort, grpcPort int, server *Server, sslCertFile, sslKeyFile string) {
+	cfg, manager, err := tlsConfig(sslCertFile, sslKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	setupExternalRouter(hostname, extPort, extSSLPort, server, cfg, manager)
 	setupInternalRouter(intPort, server)
+	setupGRPC(hostname, grpcPort, server, cfg)
 }
 
 func setupInternalRouter(internalPort int, server *Server) {
 	intRouter := gin.New()
-	intRouter.Use(getGinLogger(), gin.Recovery())
+	intRouter.Use(getGinLogger(), gin.Recovery(), auditLogger(server, true))
 
 	// get all clients
 	intRouter.GET(ClientPath, func(c *gin.Context) {
+		server.mu.RLock()
 		_, v := clientMapToSlice(server.Clients)
-		c.JSON(http.StatusOK, v)
+		server.mu.RUnlock()
+		writeClients(c, server, v)
 	})
 
 	// create a new client or fail if name exists
@@ -104,6 +206,40 @@ func setupInternalRouter(internalPort int, server *Server) {
 		c.String(http.StatusOK, secret)
 	})
 
+	// rename, disable or enable a client or change its contact information
+	intRouter.PUT(ClientPath+"/:name", func(c *gin.Context) {
+		update := ClientUpdate{}
+		if err := c.ShouldBindJSON(&update); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		client, err := server.UpdateClient(c.Param("name"), update)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrClientAlreadyExists, *ErrInvalidClientName:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		writeClients(c, server, client)
+	})
+
 	// delete a client
 	intRouter.DELETE(ClientPath+"/:name", func(c *gin.Context) {
 		clientname := c.Param("name")
@@ -127,17 +263,15 @@ func setupInternalRouter(internalPort int, server *Server) {
 		c.Status(http.StatusOK)
 	})
 
-	//create a new hook
-	intRouter.PUT(HookPath+"/:client/:identifier", func(c *gin.Context) {
-
-		identifier := c.Param("identifier")
-		hook, err := server.AddHook(c.Param("client"), identifier)
+	// get the push targets of a client
+	intRouter.GET(ClientPath+"/:name"+TargetsPath, func(c *gin.Context) {
+		targets, err := server.GetTargets(c.Param("name"))
 		if err != nil {
 			log.Error(err)
 			switch err.(type) {
-			case *ErrHookAlreadyExists:
+			case *ErrClientNotExists:
 				{
-					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					c.JSON(http.StatusNotFound, errorToStruct(err))
 					return
 				}
 			default:
@@ -148,27 +282,32 @@ func setupInternalRouter(internalPort int, server *Server) {
 			}
 		}
 
-		c.JSON(http.StatusCreated, hook)
+		c.JSON(http.StatusOK, targets)
 	})
 
-	// delete any hook by uuid
-	intRouter.DELETE(HookByUUIDPath+"/:uuid", func(c *gin.Context) {
-		uuid, err := url.QueryUnescape(c.Param("uuid"))
-		if err != nil {
+	// add a push target to a client
+	intRouter.POST(ClientPath+"/:name"+TargetsPath, func(c *gin.Context) {
+		var target Target
+		if err := c.ShouldBindJSON(&target); err != nil {
 			log.Error(err)
 			c.JSON(http.StatusBadRequest, errorToStruct(err))
 			return
 		}
 
-		err = server.DeleteHookByUUID(uuid)
+		added, err := server.AddTarget(c.Param("name"), target)
 		if err != nil {
 			log.Error(err)
 			switch err.(type) {
-			case *ErrHookNotExists:
+			case *ErrClientNotExists:
 				{
 					c.JSON(http.StatusNotFound, errorToStruct(err))
 					return
 				}
+			case *ErrInvalidTarget:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
 			default:
 				{
 					c.JSON(http.StatusInternalServerError, errorToStruct(err))
@@ -177,153 +316,2289 @@ func setupInternalRouter(internalPort int, server *Server) {
 			}
 		}
 
-		c.Status(http.StatusOK)
-	})
-
-	intRouter.GET(ApplicationVersionPath, func(c *gin.Context) {
-		c.String(http.StatusOK, ApplicationName+" "+FullVersion)
+		c.JSON(http.StatusCreated, added)
 	})
 
-	go func() {
-		err := intRouter.Run("127.0.0.1:" + strconv.Itoa(internalPort))
+	// remove a push target of a client
+	intRouter.DELETE(ClientPath+"/:name"+TargetsPath+"/:target", func(c *gin.Context) {
+		err := server.RemoveTarget(c.Param("name"), c.Param("target"))
 		if err != nil {
 			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrTargetNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
 		}
-	}()
 
-}
-
-func setupExternalRouter(hostname string, extPort, extSSLPort int, server *Server, certFile, keyFile string) {
-	extRouter := gin.New()
-	extRouter.Use(getGinLogger(), gin.Recovery())
-
-	// get all hooks for client
-	extRouter.GET(HookPath, func(c *gin.Context) {
-		if client, authorized := auth(c, server); authorized {
-			_, h := clientHookMapToSlice(client.Hooks)
-			c.JSON(http.StatusOK, h)
-		}
+		c.Status(http.StatusOK)
 	})
 
-	// create a new hook or return existing
-	extRouter.PUT(HookPath+"/:identifier", func(c *gin.Context) {
-		if client, authorized := auth(c, server); authorized {
-
-			identifier := c.Param("identifier")
-			hook, err := server.AddHook(client.Name, identifier)
-			if err != nil {
-				log.Error(err)
-				switch err.(type) {
-				case *ErrHookAlreadyExists:
-					{
-						c.JSON(http.StatusBadRequest, errorToStruct(err))
-						return
-					}
-				default:
-					{
-						c.JSON(http.StatusInternalServerError, errorToStruct(err))
-						return
-					}
+	// get the sinks the calls of a client are passed on to
+	intRouter.GET(ClientPath+"/:name"+SinksPath, func(c *gin.Context) {
+		sinks, err := server.GetClientSinks(c.Param("name"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
 				}
 			}
-
-			c.JSON(http.StatusCreated, hook)
 		}
+
+		c.JSON(http.StatusOK, sinks)
 	})
 
-	// delete a hook by identifier
-	extRouter.DELETE(HookPath+"/:identifier", func(c *gin.Context) {
-		if client, authorized := auth(c, server); authorized {
+	// replace the sinks the calls of a client are passed on to
+	intRouter.PUT(ClientPath+"/:name"+SinksPath, func(c *gin.Context) {
+		sinks := make([]*Sink, 0)
+		if err := c.ShouldBindJSON(&sinks); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
 
-			identifier := c.Param("identifier")
-			err := server.DeleteHook(client.Name, identifier)
-			if err != nil {
-				log.Error(err)
-				switch err.(type) {
-				case *ErrHookNotExists:
-					{
-						c.JSON(http.StatusNotFound, errorToStruct(err))
-						return
-					}
-				default:
-					{
-						c.JSON(http.StatusInternalServerError, errorToStruct(err))
-						return
-					}
+		sinks, err := server.SetClientSinks(c.Param("name"), sinks)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidSink:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
 				}
 			}
-
-			c.Status(http.StatusOK)
 		}
+
+		c.JSON(http.StatusOK, sinks)
 	})
 
-	// handle webhooks
-	extRouter.POST(ExternalHookPath+"/:hook", func(c *gin.Context) {
-		if err := server.HandleHook(c.Param("hook"), c.Request); err != nil {
-			c.Status(http.StatusBadGateway)
-			return
+	// remove all sinks of a client
+	intRouter.DELETE(ClientPath+"/:name"+SinksPath, func(c *gin.Context) {
+		_, err := server.SetClientSinks(c.Param("name"), nil)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
 		}
 
 		c.Status(http.StatusOK)
 	})
 
-	extRouter.GET(ConnectPath, func(c *gin.Context) {
-		if client, authorized := auth(c, server); authorized {
-			client.OpenWebsocket(c)
+	// get the topics of a client with their subscribers
+	intRouter.GET(ClientPath+"/:name"+TopicsPath, func(c *gin.Context) {
+		topics, err := server.GetTopics(c.Param("name"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
 		}
-	})
-
-	start(extRouter, hostname, extPort, extSSLPort, certFile, keyFile)
-}
 
-func start(extRouter *gin.Engine, hostname string, extPort, extSSLPort int, certFile, keyFile string) {
-	if certFile != "" && keyFile != "" {
-		httpRouter := gin.Default()
-		httpRouter.Any("*path", func(c *gin.Context) {
-			u := c.Request.URL
-			u.Host = strings.Split(c.Request.Host, ":")[0] + ":" + strconv.Itoa(extSSLPort)
-			u.Scheme = "https"
-			c.Redirect(307, u.String())
-		})
+		c.JSON(http.StatusOK, topics)
+	})
 
-		go func() {
-			err := httpRouter.Run(hostname + ":" + strconv.Itoa(extPort))
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
-		go func() {
-			err := extRouter.RunTLS(hostname+":"+strconv.Itoa(extSSLPort), certFile, keyFile)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
-	} else {
-		go func() {
-			err := extRouter.Run(hostname + ":" + strconv.Itoa(extPort))
-			if err != nil {
-				log.Fatal(err)
+	// get the recent events a client published to a topic
+	intRouter.GET(ClientPath+"/:name"+TopicsPath+"/:topic"+EventsPath, func(c *gin.Context) {
+		events, err := server.GetEvents(c.Param("name"), c.Param("topic"), false)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
 			}
-		}()
-	}
-}
-
-func auth(c *gin.Context, server *Server) (*Client, bool) {
-	clientsecret := c.GetHeader("Authorization")
-
-	if strings.HasPrefix(clientsecret, "Bearer ") {
-		client := server.validateClient(strings.TrimPrefix(clientsecret, "Bearer "))
-		if client == nil {
-			c.Status(http.StatusForbidden)
-			return nil, false
 		}
 
-		return client, true
-	}
+		c.JSON(http.StatusOK, events)
+	})
 
-	c.Status(http.StatusForbidden)
+	// get the events of a topic of a client a subscriber did not accept
+	intRouter.GET(ClientPath+"/:name"+TopicsPath+"/:topic"+DeadLettersPath, func(c *gin.Context) {
+		events, err := server.GetEvents(c.Param("name"), c.Param("topic"), true)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, events)
+	})
+
+	// post an event of a client again to the subscribers that did not accept it
+	intRouter.POST(ClientPath+"/:name"+TopicsPath+"/:topic"+EventsPath+"/:event"+RedeliverPath, func(c *gin.Context) {
+		event, err := server.Redeliver(c.Param("name"), c.Param("topic"), c.Param("event"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrEventNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusAccepted, event)
+	})
+
+	// get all hooks matching the filter
+	intRouter.GET(HookPath, func(c *gin.Context) {
+		filter := hookFilterFromQuery(c)
+		filter.Client = c.Query("client")
+		c.JSON(http.StatusOK, server.FindHooks(filter))
+	})
+
+	//create a new hook
+	intRouter.PUT(HookPath+"/:client/:identifier", func(c *gin.Context) {
+
+		var opts HookOptions
+		if err := bindOptionalJSON(c, &opts); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		identifier := c.Param("identifier")
+		hook, err := server.AddHook(c.Param("client"), identifier, opts)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrHookAlreadyExists, *ErrInvalidMetadata, *ErrInvalidExpiry:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusCreated, hook)
+	})
+
+	// change description, labels and provider of any hook
+	intRouter.PATCH(HookPath+"/:client/:identifier", func(c *gin.Context) {
+		var meta HookMetadata
+		if err := c.ShouldBindJSON(&meta); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		hook, err := server.UpdateHookMetadata(c.Param("client"), c.Param("identifier"), meta)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidMetadata:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, hook)
+	})
+
+	// set the ip filter of any hook
+	intRouter.PUT(HookPath+"/:client/:identifier"+IPFilterPath, func(c *gin.Context) {
+		var filter IPFilter
+		if err := c.ShouldBindJSON(&filter); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookIPFilter(c.Param("client"), c.Param("identifier"), filter)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidNetwork, *ErrUnknownPreset:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// set the limits of any hook
+	intRouter.PUT(HookPath+"/:client/:identifier"+LimitsPath, func(c *gin.Context) {
+		var limits Limits
+		if err := c.ShouldBindJSON(&limits); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookLimits(c.Param("client"), c.Param("identifier"), limits)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidLimits:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// get the counters of the external hook endpoint
+	intRouter.GET(StatsPath, func(c *gin.Context) {
+		global, hooks := server.GetStats()
+		c.JSON(http.StatusOK, StatsResponse{
+			Global: global,
+			Hooks:  hooks,
+		})
+	})
+
+	// update the urls of all hooks to the current public base url
+	intRouter.POST(RewriteURLsPath, func(c *gin.Context) {
+		changes, err := server.RewriteHookURLs(c.Query("dryRun") == "true")
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusInternalServerError, errorToStruct(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, changes)
+	})
+
+	// check the database for orphaned keys and remove them if fix is set
+	intRouter.POST(FsckPath, func(c *gin.Context) {
+		report, err := server.Fsck(c.Query("fix") == "true")
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusInternalServerError, errorToStruct(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	})
+
+	// set the vanity path of any hook
+	intRouter.PUT(HookPath+"/:client/:identifier"+SlugPath, func(c *gin.Context) {
+		var req SlugRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		hook, err := server.SetHookSlug(c.Param("client"), c.Param("identifier"), req.Slug, req.KeepAlias)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidSlug, *ErrSlugAlreadyExists:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, hook)
+	})
+
+	// remove the aliases of previous slugs of any hook
+	intRouter.DELETE(HookPath+"/:client/:identifier"+AliasesPath, func(c *gin.Context) {
+		err := server.RemoveHookAliases(c.Param("client"), c.Param("identifier"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// set the response the callers of any hook receive
+	intRouter.PUT(HookPath+"/:client/:identifier"+ResponsePath, func(c *gin.Context) {
+		resp := new(Response)
+		if err := c.ShouldBindJSON(resp); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookResponse(c.Param("client"), c.Param("identifier"), resp)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidResponse:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// restore the default response of any hook
+	intRouter.DELETE(HookPath+"/:client/:identifier"+ResponsePath, func(c *gin.Context) {
+		err := server.SetHookResponse(c.Param("client"), c.Param("identifier"), nil)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// set which calls of any hook are passed on
+	intRouter.PUT(HookPath+"/:client/:identifier"+FilterPath, func(c *gin.Context) {
+		filter := new(Filter)
+		if err := c.ShouldBindJSON(filter); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookFilter(c.Param("client"), c.Param("identifier"), filter)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidFilter:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// pass on all calls of any hook
+	intRouter.DELETE(HookPath+"/:client/:identifier"+FilterPath, func(c *gin.Context) {
+		err := server.SetHookFilter(c.Param("client"), c.Param("identifier"), nil)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// set how calls of any hook are changed before they are passed on
+	intRouter.PUT(HookPath+"/:client/:identifier"+TransformsPath, func(c *gin.Context) {
+		transforms := make([]Transform, 0)
+		if err := c.ShouldBindJSON(&transforms); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookTransforms(c.Param("client"), c.Param("identifier"), transforms)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidTransform:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// test transforms against a stored delivery of any hook
+	intRouter.POST(HookPath+"/:client/:identifier"+TransformsPath+DryRunPath, func(c *gin.Context) {
+		dryRun := TransformDryRun{}
+		if err := c.ShouldBindJSON(&dryRun); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		result, err := server.DryRunTransforms(c.Param("client"), c.Param("identifier"), dryRun)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists, *ErrDeliveryNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidTransform:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	// set in which format calls of any hook are passed on
+	intRouter.PUT(HookPath+"/:client/:identifier"+OutputPath, func(c *gin.Context) {
+		output := new(Output)
+		if err := c.ShouldBindJSON(output); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookOutput(c.Param("client"), c.Param("identifier"), output)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidOutput:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// pass on calls of any hook as raw requests
+	intRouter.DELETE(HookPath+"/:client/:identifier"+OutputPath, func(c *gin.Context) {
+		err := server.SetHookOutput(c.Param("client"), c.Param("identifier"), nil)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// replace the sinks on the server calls of any hook are passed on to
+	intRouter.PUT(HookPath+"/:client/:identifier"+SinksPath, func(c *gin.Context) {
+		sinks := make([]*Sink, 0)
+		if err := c.ShouldBindJSON(&sinks); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		sinks, err := server.SetHookSinks(c.Param("client"), c.Param("identifier"), sinks)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidSink:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, sinks)
+	})
+
+	// remove all sinks of any hook
+	intRouter.DELETE(HookPath+"/:client/:identifier"+SinksPath, func(c *gin.Context) {
+		_, err := server.SetHookSinks(c.Param("client"), c.Param("identifier"), nil)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// set what happens to calls of any hook while no receiver is connected
+	intRouter.PUT(HookPath+"/:client/:identifier"+NoReceiverPath, func(c *gin.Context) {
+		var policy NoReceiverPolicy
+		if err := c.ShouldBindJSON(&policy); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.SetHookNoReceiverPolicy(c.Param("client"), c.Param("identifier"), policy)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidPolicy:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// get the recent calls of any hook and their outcome
+	intRouter.GET(HookPath+"/:client/:identifier"+DeliveriesPath, func(c *gin.Context) {
+		limit := 0
+		if l := c.Query("limit"); l != "" {
+			var err error
+			limit, err = strconv.Atoi(l)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+		}
+
+		deliveries, err := server.GetDeliveries(c.Param("client"), c.Param("identifier"), limit, c.Query("withRequest") == "true")
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, deliveries)
+	})
+
+	// get the WebSub subscription of any hook
+	intRouter.GET(HookPath+"/:client/:identifier"+WebSubPath, func(c *gin.Context) {
+		websub, err := server.GetWebSub(c.Param("client"), c.Param("identifier"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists, *ErrWebSubNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, websub)
+	})
+
+	// subscribe any hook to a topic of a WebSub hub
+	intRouter.PUT(HookPath+"/:client/:identifier"+WebSubPath, func(c *gin.Context) {
+		var websub WebSub
+		if err := c.ShouldBindJSON(&websub); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		subscribed, err := server.SubscribeWebSub(c.Param("client"), c.Param("identifier"), websub)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidWebSub:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			case *ErrHubFailed:
+				{
+					c.JSON(http.StatusBadGateway, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusAccepted, subscribed)
+	})
+
+	// unsubscribe any hook from its WebSub hub
+	intRouter.DELETE(HookPath+"/:client/:identifier"+WebSubPath, func(c *gin.Context) {
+		err := server.UnsubscribeWebSub(c.Param("client"), c.Param("identifier"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists, *ErrWebSubNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrHubFailed:
+				{
+					c.JSON(http.StatusBadGateway, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusAccepted)
+	})
+
+	// pause any hook
+	intRouter.PUT(HookPath+"/:client/:identifier"+PausePath, func(c *gin.Context) {
+		var pause Pause
+		if err := bindOptionalJSON(c, &pause); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err := server.PauseHook(c.Param("client"), c.Param("identifier"), pause)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidPolicy:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	// resume any hook and pass on the calls buffered meanwhile
+	intRouter.DELETE(HookPath+"/:client/:identifier"+PausePath, func(c *gin.Context) {
+		result, err := server.ResumeHook(c.Param("client"), c.Param("identifier"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	// share any hook with another client
+	intRouter.PUT(HookPath+"/:client/:identifier"+SubscribersPath+"/:subscriber", func(c *gin.Context) {
+		hook, err := server.GrantHook(c.Param("client"), c.Param("identifier"), c.Param("subscriber"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidSubscriber:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, hook)
+	})
+
+	// stop sharing any hook with another client
+	intRouter.DELETE(HookPath+"/:client/:identifier"+SubscribersPath+"/:subscriber", func(c *gin.Context) {
+		hook, err := server.RevokeHook(c.Param("client"), c.Param("identifier"), c.Param("subscriber"))
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrClientNotExists, *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			case *ErrInvalidSubscriber:
+				{
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, hook)
+	})
+
+	// query the audit log
+	intRouter.GET(AuditPath, func(c *gin.Context) {
+		filter := AuditFilter{
+			Actor:  c.Query("actor"),
+			Action: c.Query("action"),
+		}
+
+		var err error
+		if since := c.Query("since"); since != "" {
+			filter.Since, err = time.Parse(time.RFC3339, since)
+		}
+		if until := c.Query("until"); err == nil && until != "" {
+			filter.Until, err = time.Parse(time.RFC3339, until)
+		}
+		if limit := c.Query("limit"); err == nil && limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+		}
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		entries, err := server.DB.AuditEntries(filter)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusInternalServerError, errorToStruct(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// delete any hook by uuid
+	intRouter.DELETE(HookByUUIDPath+"/:uuid", func(c *gin.Context) {
+		uuid, err := url.QueryUnescape(c.Param("uuid"))
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errorToStruct(err))
+			return
+		}
+
+		err = server.DeleteHookByUUID(uuid)
+		if err != nil {
+			log.Error(err)
+			switch err.(type) {
+			case *ErrHookNotExists:
+				{
+					c.JSON(http.StatusNotFound, errorToStruct(err))
+					return
+				}
+			default:
+				{
+					c.JSON(http.StatusInternalServerError, errorToStruct(err))
+					return
+				}
+			}
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	intRouter.GET(ApplicationVersionPath, func(c *gin.Context) {
+		c.String(http.StatusOK, ApplicationName+" "+FullVersion)
+	})
+
+	go func() {
+		err := intRouter.Run("127.0.0.1:" + strconv.Itoa(internalPort))
+		if err != nil {
+			log.Error(err)
+		}
+	}()
+
+}
+
+func setupExternalRouter(hostname string, extPort, extSSLPort int, server *Server, cfg *tls.Config, manager *autocert.Manager) {
+	extRouter := gin.New()
+	extRouter.Use(getGinLogger(), gin.Recovery(), auditLogger(server, false))
+
+	// get all hooks for client matching the filter
+	extRouter.GET(HookPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			filter := hookFilterFromQuery(c)
+			filter.Client = client.Name
+
+			found := server.FindHooks(filter)
+			h := make([]*Webhook, 0, len(found))
+			for _, f := range found {
+				h = append(h, f.Webhook)
+			}
+			c.JSON(http.StatusOK, h)
+		}
+	})
+
+	// create a new hook or return existing
+	extRouter.PUT(HookPath+"/:identifier", func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var opts HookOptions
+			if err := bindOptionalJSON(c, &opts); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			identifier := c.Param("identifier")
+			hook, err := server.AddHook(client.Name, identifier, opts)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookAlreadyExists, *ErrInvalidMetadata, *ErrInvalidExpiry:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusCreated, hook)
+		}
+	})
+
+	// change description, labels and provider of a hook
+	extRouter.PATCH(HookPath+"/:identifier", func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var meta HookMetadata
+			if err := c.ShouldBindJSON(&meta); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			hook, err := server.UpdateHookMetadata(client.Name, c.Param("identifier"), meta)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidMetadata:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, hook)
+		}
+	})
+
+	// delete a hook by identifier
+	extRouter.DELETE(HookPath+"/:identifier", func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			identifier := c.Param("identifier")
+			err := server.DeleteHook(client.Name, identifier)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// set the ip filter of a hook
+	extRouter.PUT(HookPath+"/:identifier"+IPFilterPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var filter IPFilter
+			if err := c.ShouldBindJSON(&filter); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.SetHookIPFilter(client.Name, c.Param("identifier"), filter)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidNetwork, *ErrUnknownPreset:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// set the vanity path of a hook
+	extRouter.PUT(HookPath+"/:identifier"+SlugPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var req SlugRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			hook, err := server.SetHookSlug(client.Name, c.Param("identifier"), req.Slug, req.KeepAlias)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidSlug, *ErrSlugAlreadyExists:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, hook)
+		}
+	})
+
+	// remove the aliases of previous slugs of a hook
+	extRouter.DELETE(HookPath+"/:identifier"+AliasesPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.RemoveHookAliases(client.Name, c.Param("identifier"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// set the response the callers of a hook receive
+	extRouter.PUT(HookPath+"/:identifier"+ResponsePath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			resp := new(Response)
+			if err := c.ShouldBindJSON(resp); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.SetHookResponse(client.Name, c.Param("identifier"), resp)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidResponse:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// restore the default response of a hook
+	extRouter.DELETE(HookPath+"/:identifier"+ResponsePath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.SetHookResponse(client.Name, c.Param("identifier"), nil)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// set which calls of a hook are passed on
+	extRouter.PUT(HookPath+"/:identifier"+FilterPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			filter := new(Filter)
+			if err := c.ShouldBindJSON(filter); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.SetHookFilter(client.Name, c.Param("identifier"), filter)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidFilter:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// pass on all calls of a hook
+	extRouter.DELETE(HookPath+"/:identifier"+FilterPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.SetHookFilter(client.Name, c.Param("identifier"), nil)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// set how calls of a hook are changed before they are passed on
+	extRouter.PUT(HookPath+"/:identifier"+TransformsPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			transforms := make([]Transform, 0)
+			if err := c.ShouldBindJSON(&transforms); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.SetHookTransforms(client.Name, c.Param("identifier"), transforms)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidTransform:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// test transforms against a stored delivery of a hook
+	extRouter.POST(HookPath+"/:identifier"+TransformsPath+DryRunPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			dryRun := TransformDryRun{}
+			if err := c.ShouldBindJSON(&dryRun); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			result, err := server.DryRunTransforms(client.Name, c.Param("identifier"), dryRun)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists, *ErrDeliveryNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidTransform:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, result)
+		}
+	})
+
+	// set in which format calls of a hook are passed on
+	extRouter.PUT(HookPath+"/:identifier"+OutputPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			output := new(Output)
+			if err := c.ShouldBindJSON(output); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.SetHookOutput(client.Name, c.Param("identifier"), output)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidOutput:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// pass on calls of a hook as raw requests
+	extRouter.DELETE(HookPath+"/:identifier"+OutputPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.SetHookOutput(client.Name, c.Param("identifier"), nil)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// set what happens to calls of a hook while no receiver is connected
+	extRouter.PUT(HookPath+"/:identifier"+NoReceiverPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var policy NoReceiverPolicy
+			if err := c.ShouldBindJSON(&policy); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.SetHookNoReceiverPolicy(client.Name, c.Param("identifier"), policy)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidPolicy:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// get the recent calls of a hook and their outcome
+	extRouter.GET(HookPath+"/:identifier"+DeliveriesPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			limit := 0
+			if l := c.Query("limit"); l != "" {
+				var err error
+				limit, err = strconv.Atoi(l)
+				if err != nil {
+					log.Error(err)
+					c.JSON(http.StatusBadRequest, errorToStruct(err))
+					return
+				}
+			}
+
+			deliveries, err := server.GetDeliveries(client.Name, c.Param("identifier"), limit, c.Query("withRequest") == "true")
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, deliveries)
+		}
+	})
+
+	// get the WebSub subscription of a hook
+	extRouter.GET(HookPath+"/:identifier"+WebSubPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			websub, err := server.GetWebSub(client.Name, c.Param("identifier"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists, *ErrWebSubNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, websub)
+		}
+	})
+
+	// subscribe a hook to a topic of a WebSub hub
+	extRouter.PUT(HookPath+"/:identifier"+WebSubPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var websub WebSub
+			if err := c.ShouldBindJSON(&websub); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			subscribed, err := server.SubscribeWebSub(client.Name, c.Param("identifier"), websub)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidWebSub:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				case *ErrHubFailed:
+					{
+						c.JSON(http.StatusBadGateway, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusAccepted, subscribed)
+		}
+	})
+
+	// unsubscribe a hook from its WebSub hub
+	extRouter.DELETE(HookPath+"/:identifier"+WebSubPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.UnsubscribeWebSub(client.Name, c.Param("identifier"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists, *ErrWebSubNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrHubFailed:
+					{
+						c.JSON(http.StatusBadGateway, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusAccepted)
+		}
+	})
+
+	// pause a hook
+	extRouter.PUT(HookPath+"/:identifier"+PausePath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var pause Pause
+			if err := bindOptionalJSON(c, &pause); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			err := server.PauseHook(client.Name, c.Param("identifier"), pause)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				case *ErrInvalidPolicy:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// resume a hook and pass on the calls buffered meanwhile
+	extRouter.DELETE(HookPath+"/:identifier"+PausePath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			result, err := server.ResumeHook(client.Name, c.Param("identifier"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrHookNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, result)
+		}
+	})
+
+	// answer the verification of intent of WebSub hubs
+	extRouter.GET(ExternalHookPath+"/*hook", func(c *gin.Context) {
+		challenge, err := server.VerifyWebSub(c.Param("hook"), c.Request)
+		if err != nil {
+			c.Error(err)
+			switch err.(type) {
+			case *ErrIPNotAllowed:
+				{
+					c.Status(http.StatusForbidden)
+					return
+				}
+			case *ErrInvalidWebSub:
+				{
+					c.Status(http.StatusBadRequest)
+					return
+				}
+			default:
+				{
+					c.Status(http.StatusNotFound)
+					return
+				}
+			}
+		}
+
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(challenge))
+	})
+
+	// handle webhooks, hooks are reachable by uuid or slug
+	extRouter.POST(ExternalHookPath+"/*hook", func(c *gin.Context) {
+		resp, err := server.HandleHook(c.Param("hook"), c.Request)
+		if err != nil {
+			switch e := err.(type) {
+			case *ErrIPNotAllowed:
+				{
+					// record the rejection in the request log
+					c.Error(err)
+					c.Status(http.StatusForbidden)
+					return
+				}
+			case *ErrRequestTooLarge:
+				{
+					c.Error(err)
+					c.Status(http.StatusRequestEntityTooLarge)
+					return
+				}
+			case *ErrRateLimited:
+				{
+					c.Error(err)
+					c.Header("Retry-After", retryAfter(e.RetryAfter))
+					c.Status(http.StatusTooManyRequests)
+					return
+				}
+			case *ErrTooManyInFlight:
+				{
+					c.Error(err)
+					c.Header("Retry-After", retryAfter(time.Second))
+					c.Status(http.StatusTooManyRequests)
+					return
+				}
+			case *ErrHookPaused:
+				{
+					c.Error(err)
+					c.Status(e.Status)
+					return
+				}
+			case *ErrNoReceiver:
+				{
+					c.Error(err)
+					if e.RetryAfter > 0 {
+						c.Header("Retry-After", retryAfter(e.RetryAfter))
+					}
+					c.Status(http.StatusServiceUnavailable)
+					return
+				}
+			default:
+				{
+					c.Status(http.StatusBadGateway)
+					return
+				}
+			}
+		}
+
+		writeResponse(c, resp)
+	})
+
+	// get the push targets of the client
+	extRouter.GET(TargetPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			targets, err := server.GetTargets(client.Name)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusInternalServerError, errorToStruct(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, targets)
+		}
+	})
+
+	// add a push target to the client
+	extRouter.POST(TargetPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var target Target
+			if err := c.ShouldBindJSON(&target); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			added, err := server.AddTarget(client.Name, target)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrInvalidTarget:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusCreated, added)
+		}
+	})
+
+	// remove a push target of the client
+	extRouter.DELETE(TargetPath+"/:target", func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.RemoveTarget(client.Name, c.Param("target"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrTargetNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// get the topics of the client with their subscribers
+	extRouter.GET(TopicPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			topics, err := server.GetTopics(client.Name)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusInternalServerError, errorToStruct(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, topics)
+		}
+	})
+
+	// get the subscribers of a topic of the client
+	extRouter.GET(TopicPath+"/:topic"+SubscribersPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			subscribers, err := server.GetSubscribers(client.Name, c.Param("topic"))
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusInternalServerError, errorToStruct(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, subscribers)
+		}
+	})
+
+	// subscribe a URL to a topic of the client
+	extRouter.POST(TopicPath+"/:topic"+SubscribersPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			var subscriber Subscriber
+			if err := c.ShouldBindJSON(&subscriber); err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			added, err := server.AddSubscriber(client.Name, c.Param("topic"), subscriber)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrInvalidTopic, *ErrInvalidTarget:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusCreated, added)
+		}
+	})
+
+	// remove a subscriber of a topic of the client
+	extRouter.DELETE(TopicPath+"/:topic"+SubscribersPath+"/:subscriber", func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			err := server.RemoveSubscriber(client.Name, c.Param("topic"), c.Param("subscriber"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrSubscriberNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.Status(http.StatusOK)
+		}
+	})
+
+	// publish an event to the subscribers of a topic of the client
+	extRouter.POST(TopicPath+"/:topic"+EventsPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			event, err := server.Publish(client.Name, c.Param("topic"), c.GetHeader("Content-Type"), c.Request.Body)
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrInvalidTopic:
+					{
+						c.JSON(http.StatusBadRequest, errorToStruct(err))
+						return
+					}
+				case *ErrRequestTooLarge:
+					{
+						c.JSON(http.StatusRequestEntityTooLarge, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusAccepted, event)
+		}
+	})
+
+	// get the recent events the client published to a topic
+	extRouter.GET(TopicPath+"/:topic"+EventsPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			events, err := server.GetEvents(client.Name, c.Param("topic"), false)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusInternalServerError, errorToStruct(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, events)
+		}
+	})
+
+	// get the events of a topic of the client a subscriber did not accept
+	extRouter.GET(TopicPath+"/:topic"+DeadLettersPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			events, err := server.GetEvents(client.Name, c.Param("topic"), true)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusInternalServerError, errorToStruct(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, events)
+		}
+	})
+
+	// get an event of the client with its delivery to the subscribers
+	extRouter.GET(TopicPath+"/:topic"+EventsPath+"/:event", func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			event, err := server.GetEvent(client.Name, c.Param("topic"), c.Param("event"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrEventNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusOK, event)
+		}
+	})
+
+	// post an event of the client again to the subscribers that did not accept it
+	extRouter.POST(TopicPath+"/:topic"+EventsPath+"/:event"+RedeliverPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+
+			event, err := server.Redeliver(client.Name, c.Param("topic"), c.Param("event"))
+			if err != nil {
+				log.Error(err)
+				switch err.(type) {
+				case *ErrEventNotExists:
+					{
+						c.JSON(http.StatusNotFound, errorToStruct(err))
+						return
+					}
+				default:
+					{
+						c.JSON(http.StatusInternalServerError, errorToStruct(err))
+						return
+					}
+				}
+			}
+
+			c.JSON(http.StatusAccepted, event)
+		}
+	})
+
+	extRouter.GET(ConnectPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			if format, ok := formatFromQuery(c); ok {
+				server.Connect(client, c, format)
+			}
+		}
+	})
+
+	// receive calls as server-sent events, for environments without websockets
+	extRouter.GET(StreamPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			if format, ok := formatFromQuery(c); ok {
+				server.Stream(client, c, format)
+			}
+		}
+	})
+
+	// wait for calls and receive them in the answer, for environments without websockets or streams
+	extRouter.GET(PollPath, func(c *gin.Context) {
+		if client, authorized := auth(c, server); authorized {
+			format, ok := formatFromQuery(c)
+			if !ok {
+				return
+			}
+
+			wait, err := ParseWait(c.Query(WaitQuery))
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errorToStruct(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, server.Poll(client, c, format, wait, c.Query(AckQuery)))
+		}
+	})
+
+	start(extRouter, hostname, extPort, extSSLPort, cfg, manager)
+}
+
+func start(extRouter *gin.Engine, hostname string, extPort, extSSLPort int, cfg *tls.Config, manager *autocert.Manager) {
+	if cfg != nil {
+		httpRouter := gin.Default()
+		httpRouter.Any("*path", func(c *gin.Context) {
+			u := c.Request.URL
+			u.Host = strings.Split(c.Request.Host, ":")[0] + ":" + strconv.Itoa(extSSLPort)
+			u.Scheme = "https"
+			c.Redirect(307, u.String())
+		})
+
+		// ACME HTTP-01 challenges are answered on the redirecting listener
+		var httpHandler http.Handler = httpRouter
+		if manager != nil {
+			httpHandler = manager.HTTPHandler(httpRouter)
+		}
+
+		go func() {
+			err := http.ListenAndServe(hostname+":"+strconv.Itoa(extPort), httpHandler)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		srv := &http.Server{
+			Addr:      hostname + ":" + strconv.Itoa(extSSLPort),
+			Handler:   extRouter,
+			TLSConfig: cfg,
+		}
+
+		go func() {
+			// the certificates are provided by the tls config
+			err := srv.ListenAndServeTLS("", "")
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	} else {
+		go func() {
+			err := extRouter.Run(hostname + ":" + strconv.Itoa(extPort))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+}
+
+func auth(c *gin.Context, server *Server) (*Client, bool) {
+	client := server.authenticate(c.Request.TLS, c.GetHeader("Authorization"))
+	if client == nil {
+		c.Status(http.StatusForbidden)
+		return nil, false
+	}
+
+	c.Set(contextClientKey, client.Name)
+	return client, true
+}
+
+// authenticate returns the client of a verified client certificate or of the secret in authorization, a bearer token.
+// Returns nil if neither identifies an enabled client
+func (s *Server) authenticate(state *tls.ConnectionState, authorization string) *Client {
+	if client := s.validateClientCertificate(state); client != nil {
+		return client
+	}
+
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return s.validateClient(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return nil
+}
+
+// StatsResponse contains the global counters and the counters of each hook
+type StatsResponse struct {
+	Global Stats       `json:"global"`
+	Hooks  []HookStats `json:"hooks"`
+}
+
+// hookFilterFromQuery reads the filter for hook lists. Labels are given as label=key=value, label=key matches any value
+func hookFilterFromQuery(c *gin.Context) HookFilter {
+	filter := HookFilter{
+		Provider: c.Query("provider"),
+		Search:   c.Query("q"),
+		Labels:   make(map[string]string),
+	}
+	for _, l := range c.QueryArray("label") {
+		split := strings.SplitN(l, "=", 2)
+		if len(split) == 2 {
+			filter.Labels[split[0]] = split[1]
+		} else {
+			filter.Labels[split[0]] = ""
+		}
+	}
+	return filter
+}
+
+// bindOptionalJSON binds the JSON body to v. An empty body leaves v unchanged
+func bindOptionalJSON(c *gin.Context, v interface{}) error {
+	err := c.ShouldBindJSON(v)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// writeClients answers with clients as JSON. Their hooks are serialized while they cannot change
+func writeClients(c *gin.Context, server *Server, clients interface{}) {
+	server.mu.RLock()
+	body, err := json.Marshal(clients)
+	server.mu.RUnlock()
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, errorToStruct(err))
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// writeResponse sends the configured response to the caller of a hook
+func writeResponse(c *gin.Context, resp *Response) {
+	contentType := "text/plain; charset=utf-8"
+	for k, v := range resp.Headers {
+		c.Header(k, v)
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			contentType = v
+		}
+	}
+
+	if resp.Body == "" {
+		c.Status(resp.Status)
+		return
+	}
+
+	c.Data(resp.Status, contentType, []byte(resp.Body))
+}
 
-	return nil, false
+// retryAfter formats d as seconds for the Retry-After header, rounded up
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
 }
 
 // Error is a simple error message struct used to represent an error in the api
@@ -347,12 +2622,14 @@ func clientMapToSlice(inmap map[string]*Client) ([]string, []*Client) {
 	return keys, values
 }
 
-func clientHookMapToSlice(inmap map[string]*Webhook) ([]string, []*Webhook) {
-	keys := make([]string, 0)
-	values := make([]*Webhook, 0)
-	for k, v := range inmap {
-		keys = append(keys, k)
-		values = append(values, v)
+// formatFromQuery reads the output format a client chose for its connection. An unknown format is answered with 400
+func formatFromQuery(c *gin.Context) (string, bool) {
+	format := c.Query(FormatQuery)
+	if format != "" && !ValidOutputFormat(format) {
+		err := &ErrInvalidOutput{Message: "unknown format '" + format + "'"}
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, errorToStruct(err))
+		return "", false
 	}
-	return keys, values
+	return format, true
 }
@@ -8,8 +8,11 @@ package server
 
 import (
 	"crypto/sha256"
+	"errors"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -32,21 +35,36 @@ const FullVersion = VersionMajor + "." + VersionMinor + "." + VersionPatch
 
 // Server contains all the information about clients and webhooks
 type Server struct {
-	Clients        map[string]*Client
-	Hooks          map[string]*Webhook
-	DB             *DB
-	hostname, port string
+	Clients           map[string]*Client
+	Hooks             map[string]*Webhook
+	DB                *DB
+	slugs             map[string]*Webhook
+	baseURL           string
+	presets           map[string][]*net.IPNet
+	trustForwardedFor bool
+	trustedProxies    []*net.IPNet
+	limiter           *limiter
+	audit             auditLog
+	deliveryRetention int
+	queueLimit        int
+	eventRetention    int
+	allowPrivate      bool
+	listenPorts       map[int]bool
+	// mu guards Clients, Hooks, slugs and the hooks and topics of every client
+	mu sync.RWMutex
 }
 
-// NewServer creates a new CaptainHook Server
-func NewServer(host, port string) *Server {
+// NewServer creates a new CaptainHook Server. publicBaseURL is the URL the external API is reachable at, see PublicBaseURL
+func NewServer(publicBaseURL string) *Server {
 
 	return &Server{
-		Clients:  make(map[string]*Client),
-		Hooks:    make(map[string]*Webhook),
-		DB:       Open(""),
-		hostname: host,
-		port:     port,
+		Clients: make(map[string]*Client),
+		Hooks:   make(map[string]*Webhook),
+		DB:      Open(""),
+		slugs:   make(map[string]*Webhook),
+		baseURL: publicBaseURL,
+		presets: make(map[string][]*net.IPNet),
+		limiter: newLimiter(Limits{}),
 	}
 }
 
@@ -56,14 +74,37 @@ func (s *Server) Load() {
 	if err != nil {
 		log.Fatalf("Error reading database: %s", err.Error())
 	}
-	s.Clients = cli
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Clients = make(map[string]*Client)
+
+	for _, c := range cli {
+		s.Clients[c.Name] = c
+		for _, h := range c.Hooks {
+			h.client = c
+			h.limiter = newLimiter(h.Limits)
+			s.indexHook(h)
+		}
+	}
+
+	s.resolveSubscribers()
+	s.prepareTargets()
+	s.prepareSinks()
+	s.prepareSubscribers()
+	s.resumeDispatches()
+	s.resumePushes()
 }
 
 // Stop stops the server
 func (s *Server) Stop() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	for _, c := range s.Clients {
-		for _, ws := range c.ws {
-			ws.Close()
+		c.Destroy()
+		closeSinks(c.Sinks)
+		for _, h := range c.Hooks {
+			closeSinks(h.Sinks)
 		}
 	}
 	s.DB.bdb.Close()
@@ -85,13 +126,22 @@ func (s *Server) AddClient(name string) (string, error) {
 		return "", err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.Clients[name] != nil {
 		err := &ErrClientAlreadyExists{Name: name}
 		log.Error(err)
 		return "", err
 	}
 
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
 	c := &Client{
+		ID:         id.String(),
 		Name:       name,
 		CreatedAt:  time.Now(),
 		LastAction: time.Now(),
@@ -112,6 +162,8 @@ func (s *Server) AddClient(name string) (string, error) {
 
 // RemoveClient will delete a client
 func (s *Server) RemoveClient(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.Clients[name] == nil {
 		err := &ErrClientNotExists{Name: name}
 		log.Error(err)
@@ -119,16 +171,32 @@ func (s *Server) RemoveClient(name string) error {
 	}
 
 	s.Clients[name].Destroy()
+	s.unsubscribeEverywhere(s.Clients[name])
+
+	s.removeSinks(s.Clients[name].Sinks)
+	for _, h := range s.Clients[name].Hooks {
+		s.removeSinks(h.Sinks)
+		s.unindexHook(h)
+		s.DB.DeleteDeliveries(h.UUID)
+		s.DB.DeleteBuffer(h.UUID)
+	}
+	s.DB.DeleteEvents(s.Clients[name].ID)
 
-	s.DB.Delete(name)
+	err := s.DB.Delete(s.Clients[name].ID)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
 
 	delete(s.Clients, name)
 
 	return nil
 }
 
-// AddHook will add a hook identified by identifier to the given client
-func (s *Server) AddHook(clientname, identifier string) (*Webhook, error) {
+// AddHook will add a hook identified by identifier to the given client. opts describe what the hook is used for and when it expires
+func (s *Server) AddHook(clientname, identifier string, opts HookOptions) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	if s.Clients[clientname] == nil {
 		err := &ErrClientNotExists{Name: clientname}
@@ -142,6 +210,18 @@ func (s *Server) AddHook(clientname, identifier string) (*Webhook, error) {
 		return nil, err
 	}
 
+	err := validateMetadata(opts.HookMetadata)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	expiresAt, err := opts.expiry(time.Now())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
 	url, uuid, err := s.generateURL()
 	if err != nil {
 		log.Error(err)
@@ -154,10 +234,14 @@ func (s *Server) AddHook(clientname, identifier string) (*Webhook, error) {
 		Identifier: identifier,
 		URL:        url,
 		UUID:       uuid,
+		ExpiresAt:  expiresAt,
+		MaxCalls:   opts.MaxCalls,
 		client:     s.Clients[clientname],
+		limiter:    newLimiter(Limits{}),
 	}
+	w.applyMetadata(opts.HookMetadata)
 
-	s.Hooks[uuid] = w
+	s.indexHook(w)
 	s.Clients[clientname].Hooks[identifier] = w
 	s.DB.Store(s.Clients[clientname])
 
@@ -166,33 +250,33 @@ func (s *Server) AddHook(clientname, identifier string) (*Webhook, error) {
 
 // DeleteHook removes the webhook identified by identifier from the given client
 func (s *Server) DeleteHook(clientname, identifier string) error {
-	if s.Clients[clientname] == nil {
-		err := &ErrClientNotExists{Name: clientname}
-		log.Error(err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, err := s.findHook(clientname, identifier)
+	if err != nil {
 		return err
 	}
 
-	if s.Clients[clientname].Hooks[identifier] == nil {
-		err := &ErrHookNotExists{Identifier: identifier}
+	err = s.removeHook(hook)
+	if err != nil {
 		log.Error(err)
-		return err
 	}
-
-	delete(s.Hooks, s.Clients[clientname].Hooks[identifier].UUID)
-	delete(s.Clients[clientname].Hooks, identifier)
-
-	return nil
+	return err
 }
 
 // DeleteHookByUUID will remove the webhook identified by the uuid from the CaptainHook instance
 func (s *Server) DeleteHookByUUID(uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, c := range s.Clients {
 		for _, h := range c.Hooks {
 			if h.UUID == uuid {
-				delete(c.Hooks, h.Identifier)
-				delete(s.Hooks, h.UUID)
-				s.DB.Store(c)
-				return nil
+				err := s.removeHook(h)
+				if err != nil {
+					log.Error(err)
+				}
+				return err
 			}
 
 		}
@@ -204,24 +288,174 @@ func (s *Server) DeleteHookByUUID(uuid string) error {
 	return err
 }
 
-// HandleHook will proxy the http request sent by the 3rd party to the client this webhook belongs to
-func (s *Server) HandleHook(uuid string, req *http.Request) error {
-	if s.Hooks[uuid] == nil {
-		return &ErrHookNotExists{Identifier: uuid}
+// HandleHook will proxy the http request sent by the 3rd party to the client this webhook belongs to.
+// path is the UUID, slug or an alias of the hook. Returns the response for the caller
+func (s *Server) HandleHook(path string, req *http.Request) (*Response, error) {
+	hook := s.resolveHook(path)
+	if hook == nil || !hook.reserveCall(time.Now()) {
+		return nil, &ErrHookNotExists{Identifier: path}
+	}
+	// runs last, after the call was recorded
+	defer s.expireExhausted(hook)
+	// calls that were not accepted don't count
+	counted := false
+	defer func() {
+		if !counted {
+			hook.finishCall(false)
+		}
+	}()
+
+	delivery := newDelivery(hook, req)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	// only CaptainHook itself sends events about hooks
+	req.Header.Del(EventHeader)
+	// pushes and sinks report to the delivery record, so they start once it is stored
+	defer func() {
+		s.startPushes(delivery.pushes)
+		s.startSinks(delivery.sinks)
+	}()
+	defer s.recordDelivery(delivery)
+
+	err := s.checkIP(hook, req)
+	if err != nil {
+		log.Warn(err)
+		delivery.Outcome = OutcomeForbidden
+		return nil, err
+	}
+
+	if pause := hook.pause(); pause.Paused && pause.Action != PauseBuffer {
+		delivery.Outcome = OutcomePaused
+		return nil, &ErrHookPaused{Identifier: hook.Identifier, Status: pause.Status}
 	}
 
-	err := s.Hooks[uuid].Handle(req)
+	release, err := s.acquire(hook, req)
 	if err != nil {
-		log.Error(err)
-		return err
+		log.Warn(err)
+		delivery.Outcome = OutcomeLimited
+		return nil, err
+	}
+	defer release()
+
+	signed, err := s.checkWebSubSignature(hook, delivery, req)
+	if err != nil {
+		return nil, s.readFailed(hook, delivery, err)
+	}
+	if !signed {
+		return s.response(hook), nil
+	}
+
+	forward, err := s.applyFilter(hook, delivery, req)
+	if err != nil {
+		return nil, s.readFailed(hook, delivery, err)
+	}
+	if !forward {
+		return s.response(hook), nil
+	}
+
+	// a resume clears the pause only once the buffer is empty, it waits for the call to be buffered
+	hook.pauseMu.Lock()
+	if hook.Pause.Paused {
+		defer hook.pauseMu.Unlock()
+		resp, err := s.handlePaused(hook, delivery, req)
+		counted = delivery.Outcome == OutcomeBuffered
+		return resp, err
+	}
+	hook.pauseMu.Unlock()
+
+	missed, err := hook.Handle(req, delivery)
+	if err != nil {
+		return nil, s.readFailed(hook, delivery, err)
 	}
 
+	policy := s.noReceiverPolicy(hook)
+	if delivery.Receivers > 0 {
+		delivery.Outcome = OutcomeDelivered
+		// clients the hook is shared with get their copy once they connect
+		s.enqueueAll(queuedFor(policy, missed), delivery.key, delivery.Request)
+	} else if len(delivery.pushes) > 0 || len(delivery.sinks) > 0 {
+		delivery.Outcome = OutcomePushing
+		s.enqueueAll(queuedFor(policy, missed), delivery.key, delivery.Request)
+	} else if err = s.handleNoReceiver(hook, policy, delivery, delivery.Request); err != nil {
+		return nil, err
+	}
+	s.countAccepted(hook)
+	hook.finishCall(true)
+	counted = true
+
 	//persist LastCall for webhook
-	return s.DB.Store(s.Hooks[uuid].client)
+	return s.response(hook), s.storeClient(hook.client)
+}
+
+// handlePaused buffers a call of a paused hook until it is resumed and counts it once it is buffered. The caller holds
+// hook.pauseMu
+func (s *Server) handlePaused(hook *Webhook, d *Delivery, req *http.Request) (*Response, error) {
+	err := hook.prepare(req, d)
+	if err != nil {
+		return nil, s.readFailed(hook, d, err)
+	}
+
+	err = s.buffer(hook, d, d.Request)
+	if err != nil {
+		log.Warn(err)
+		d.Outcome = OutcomePaused
+		return nil, &ErrHookPaused{Identifier: hook.Identifier, Status: http.StatusServiceUnavailable}
+	}
+	d.Outcome = OutcomeBuffered
+	s.countAccepted(hook)
+	hook.finishCall(true)
+
+	return s.response(hook), s.storeClient(hook.client)
+}
+
+// readFailed records why a call could not be read and returns the error for the caller
+func (s *Server) readFailed(hook *Webhook, d *Delivery, err error) error {
+	var tooLarge *ErrRequestTooLarge
+	if errors.As(err, &tooLarge) {
+		s.countTooLarge(hook)
+		log.Warn(tooLarge)
+		d.Outcome = OutcomeLimited
+		return tooLarge
+	}
+	log.Error(err)
+	d.Outcome = OutcomeFailed
+	return err
+}
+
+// handleNoReceiver applies the policy of the hook to a call nobody received. Clients that poll get it with their next
+// poll whatever the policy is
+func (s *Server) handleNoReceiver(hook *Webhook, policy NoReceiverPolicy, d *Delivery, msg []byte) error {
+	retry := &ErrNoReceiver{
+		Client:     hook.client.Name,
+		RetryAfter: time.Duration(policy.RetryAfter) * time.Second,
+	}
+
+	if policy.Action != NoReceiverQueue && s.enqueueAll(pollingClients(hook.clients()), d.key, msg) > 0 {
+		d.Outcome = OutcomeQueued
+		return nil
+	}
+
+	switch policy.Action {
+	case NoReceiverReject:
+		log.Warn(retry)
+		d.Outcome = OutcomeRejected
+		return retry
+	case NoReceiverQueue:
+		if s.enqueueAll(hook.clients(), d.key, msg) == 0 {
+			d.Outcome = OutcomeRejected
+			return retry
+		}
+		d.Outcome = OutcomeQueued
+	default:
+		d.Outcome = OutcomeDropped
+	}
+
+	return nil
 }
 
 // RegenerateClientSecret will recreate a secret for the given client and invalidate the old one
 func (s *Server) RegenerateClientSecret(clientname string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.Clients[clientname] == nil {
 		err := &ErrClientNotExists{Name: clientname}
 		log.Error(err)
@@ -243,6 +477,150 @@ func (s *Server) RegenerateClientSecret(clientname string) (string, error) {
 	return secret, nil
 }
 
+// UpdateClient renames, disables or enables the client or changes its contact information. Disabling a client closes
+// its connections, its hooks and their URLs stay unchanged
+func (s *Server) UpdateClient(clientname string, update ClientUpdate) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.Clients[clientname]
+	if c == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	if update.Name != nil && *update.Name != clientname {
+		if *update.Name == "" || strings.Contains(*update.Name, delimeter) {
+			err := &ErrInvalidClientName{Name: *update.Name}
+			log.Error(err)
+			return nil, err
+		}
+
+		if s.Clients[*update.Name] != nil {
+			err := &ErrClientAlreadyExists{Name: *update.Name}
+			log.Error(err)
+			return nil, err
+		}
+
+		delete(s.Clients, clientname)
+		c.Name = *update.Name
+		s.Clients[c.Name] = c
+	}
+
+	if update.Owner != nil {
+		c.Owner = *update.Owner
+	}
+	if update.Contact != nil {
+		c.Contact = *update.Contact
+	}
+	if update.Disabled != nil {
+		c.Disabled = *update.Disabled
+		if c.Disabled {
+			c.Destroy()
+		}
+	}
+
+	c.LastAction = time.Now()
+	return c, s.DB.Store(c)
+}
+
+// URLChange describes the URL of a hook that changed because the public base URL changed
+type URLChange struct {
+	Client     string `json:"client"`
+	Identifier string `json:"identifier"`
+	Old        string `json:"old"`
+	New        string `json:"new"`
+}
+
+// RewriteHookURLs updates the stored URLs of all hooks to the current public base URL. If dryRun is set, nothing is changed.
+// Returns all hooks whose URL is different
+func (s *Server) RewriteHookURLs(dryRun bool) ([]URLChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := make([]URLChange, 0)
+	for _, c := range s.Clients {
+		changed := false
+		for _, h := range c.Hooks {
+			if h.Slug != "" {
+				h.SlugURL = s.hookURL(h.Slug)
+			}
+
+			url := s.hookURL(h.UUID)
+			if h.URL == url {
+				continue
+			}
+
+			changes = append(changes, URLChange{
+				Client:     c.Name,
+				Identifier: h.Identifier,
+				Old:        h.URL,
+				New:        url,
+			})
+
+			if !dryRun {
+				h.URL = url
+				changed = true
+			}
+		}
+
+		if changed {
+			err := s.DB.Store(c)
+			if err != nil {
+				log.Error(err)
+				return changes, err
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// getHook returns the webhook identified by identifier of the given client
+func (s *Server) getHook(clientname, identifier string) (*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findHook(clientname, identifier)
+}
+
+// findHook returns the webhook identified by identifier of the given client. The caller holds s.mu
+func (s *Server) findHook(clientname, identifier string) (*Webhook, error) {
+	if s.Clients[clientname] == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+
+	if s.Clients[clientname].Hooks[identifier] == nil {
+		err := &ErrHookNotExists{Identifier: identifier}
+		log.Error(err)
+		return nil, err
+	}
+
+	return s.Clients[clientname].Hooks[identifier], nil
+}
+
+// getClient returns the client with the given name
+func (s *Server) getClient(clientname string) (*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client := s.Clients[clientname]
+	if client == nil {
+		err := &ErrClientNotExists{Name: clientname}
+		log.Error(err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// storeClient persists the client and its hooks, which must not change meanwhile. The caller must not hold s.mu
+func (s *Server) storeClient(c *Client) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.DB.Store(c)
+}
+
 func (s *Server) validateClient(secret string) *Client {
 	split := strings.Split(secret, ":")
 	if len(split) != 2 {
@@ -250,22 +628,38 @@ func (s *Server) validateClient(secret string) *Client {
 		return nil
 	}
 
-	if s.Clients[split[0]] == nil {
+	client := s.clientByID(split[0])
+	if client == nil {
 		log.Infof("Client '%s' does not exist", split[0])
 		return nil
 	}
 
-	sec := s.Clients[split[0]].Secret
-	_ = sec
-	if string(s.Clients[split[0]].Secret) != string(sha256.New().Sum([]byte(secret))) {
+	if string(client.Secret) != string(sha256.New().Sum([]byte(secret))) {
 		log.Info("Clientsecret does not match")
 		return nil
 	}
 
-	return s.Clients[split[0]]
+	if client.Disabled {
+		log.Infof("Client '%s' is disabled", client.Name)
+		return nil
+	}
+
+	return client
+}
+
+// clientByID returns the client with the given ID or nil
+func (s *Server) clientByID(id string) *Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, c := range s.Clients {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
 }
 
-// generateURL returns fullUrl (https://host:port/h/UUID), UUID
+// generateURL returns fullUrl (https://host:port/prefix/h/UUID), UUID
 func (s *Server) generateURL() (string, string, error) {
 
 	u4, err := uuid.NewV4()
@@ -273,5 +667,10 @@ func (s *Server) generateURL() (string, string, error) {
 		log.Error(err)
 		return "", "", err
 	}
-	return "http://" + s.hostname + ":" + s.port + "/h/" + u4.String(), u4.String(), nil
+	return s.hookURL(u4.String()), u4.String(), nil
+}
+
+// hookURL returns the public URL of the hook reachable at path
+func (s *Server) hookURL(path string) string {
+	return s.baseURL + ExternalHookPath + "/" + path
 }
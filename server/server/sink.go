@@ -0,0 +1,436 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// SinkExec runs a command for every call
+	SinkExec = "exec"
+	// SinkFile appends every call to a JSON-lines file
+	SinkFile = "file"
+	// SinkSocket writes every call as a line of JSON to a Unix socket
+	SinkSocket = "socket"
+	// SinkNATS publishes every call to a NATS subject
+	SinkNATS = "nats"
+	// SinkKafka produces every call to a Kafka topic
+	SinkKafka = "kafka"
+	// SinkAMQP publishes every call to an AMQP 0.9.1 exchange like the ones of RabbitMQ
+	SinkAMQP = "amqp"
+	// SinkRedis adds every call to a Redis stream
+	SinkRedis = "redis"
+)
+
+// Sink passes the calls of a hook or client on to a destination besides the connected clients, e.g. a script on the
+// server or a message broker. Only the field matching the type is used
+type Sink struct {
+	ID string `json:"id"`
+	// Type is exec, file, socket, nats, kafka, amqp or redis
+	Type   string      `json:"type"`
+	Exec   *ExecSink   `json:"exec,omitempty"`
+	File   *FileSink   `json:"file,omitempty"`
+	Socket *SocketSink `json:"socket,omitempty"`
+	NATS   *NATSSink   `json:"nats,omitempty"`
+	Kafka  *KafkaSink  `json:"kafka,omitempty"`
+	AMQP   *AMQPSink   `json:"amqp,omitempty"`
+	Redis  *RedisSink  `json:"redis,omitempty"`
+	driver sinkDriver
+	// outbox is set for sinks to brokers, which get every call at least once
+	outbox *outbox
+}
+
+// SinkCall is a call as sinks get it. JSON bodies are passed on as body, all other bodies as bodyBase64
+type SinkCall struct {
+	Delivery   string          `json:"delivery"`
+	Hook       string          `json:"hook"`
+	HookUUID   string          `json:"hookUuid"`
+	Client     string          `json:"client"`
+	ReceivedAt time.Time       `json:"receivedAt"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Query      string          `json:"query,omitempty"`
+	Header     http.Header     `json:"header"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	BodyBase64 string          `json:"bodyBase64,omitempty"`
+	body       []byte
+}
+
+// SinkResult records what happened to a call passed on to a sink
+type SinkResult struct {
+	Sink    string `json:"sink"`
+	Type    string `json:"type"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// sinkDriver passes calls on to the destination of a sink
+type sinkDriver interface {
+	send(call *SinkCall) error
+	close() error
+}
+
+// sinkTypes create the driver of each type of sink, validating its configuration and filling in the defaults
+var sinkTypes = map[string]func(sink *Sink) (sinkDriver, error){
+	SinkExec:   newExecDriver,
+	SinkFile:   newFileDriver,
+	SinkSocket: newSocketDriver,
+	SinkNATS:   newNATSDriver,
+	SinkKafka:  newKafkaDriver,
+	SinkAMQP:   newAMQPDriver,
+	SinkRedis:  newRedisDriver,
+}
+
+// durableSinks keep calls in an outbox until they were passed on, see outbox
+var durableSinks = map[string]bool{
+	SinkNATS:  true,
+	SinkKafka: true,
+	SinkAMQP:  true,
+	SinkRedis: true,
+}
+
+// sinkRun is a call on its way to a sink of a hook or of one of its clients
+type sinkRun struct {
+	sink       *Sink
+	hook       *Webhook
+	msg        []byte
+	receivedAt time.Time
+	// deliveryKey is the key of the delivery record the result is added to
+	deliveryKey string
+}
+
+// SetHookSinks replaces the sinks of the webhook identified by identifier of the given client and returns them with
+// their IDs. The sinks that are replaced are closed, calls left in their outboxes are dropped
+func (s *Server) SetHookSinks(clientname, identifier string, sinks []*Sink) ([]*Sink, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.openSinks(sinks)
+	if err != nil {
+		return nil, err
+	}
+
+	s.removeSinks(hook.Sinks)
+	hook.Sinks = sinks
+	return sinks, s.storeClient(hook.client)
+}
+
+// SetClientSinks replaces the sinks of the given client and returns them with their IDs. They get the calls of all
+// hooks of the client and of the hooks shared with it. The sinks that are replaced are closed, calls left in their
+// outboxes are dropped
+func (s *Server) SetClientSinks(clientname string, sinks []*Sink) ([]*Sink, error) {
+	client, err := s.getClient(clientname)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.openSinks(sinks)
+	if err != nil {
+		return nil, err
+	}
+
+	s.removeSinks(client.Sinks)
+	client.Sinks = sinks
+	return sinks, s.storeClient(client)
+}
+
+// GetClientSinks returns the sinks of the given client
+func (s *Server) GetClientSinks(clientname string) ([]*Sink, error) {
+	client, err := s.getClient(clientname)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Sinks == nil {
+		return make([]*Sink, 0), nil
+	}
+	return client.Sinks, nil
+}
+
+// openSinks assigns IDs to new sinks and opens them. If one of them is invalid, none is opened
+func (s *Server) openSinks(sinks []*Sink) error {
+	for _, sink := range sinks {
+		id, err := uuid.NewV4()
+		if err != nil {
+			closeSinks(sinks)
+			return &ErrCreatingUUID{Message: err.Error()}
+		}
+		sink.ID = id.String()
+
+		err = sink.prepare()
+		if err != nil {
+			log.Error(err)
+			closeSinks(sinks)
+			return err
+		}
+	}
+
+	for _, sink := range sinks {
+		s.openOutbox(sink)
+	}
+	return nil
+}
+
+// prepare validates the sink and opens its driver
+func (sink *Sink) prepare() error {
+	newDriver, ok := sinkTypes[sink.Type]
+	if !ok {
+		return &ErrInvalidSink{Message: "unknown type '" + sink.Type + "'"}
+	}
+
+	driver, err := newDriver(sink)
+	if err != nil {
+		return err
+	}
+	sink.driver = driver
+	return nil
+}
+
+// prepareSinks opens the sinks of all clients and hooks after loading them. Sinks that became invalid are dropped,
+// calls left in the outboxes of the others are passed on. The caller holds s.mu
+func (s *Server) prepareSinks() {
+	for _, c := range s.Clients {
+		c.Sinks = s.reopenSinks(c.Name, c.Sinks)
+		for _, h := range c.Hooks {
+			h.Sinks = s.reopenSinks(h.Identifier, h.Sinks)
+		}
+	}
+}
+
+// reopenSinks opens loaded sinks of owner and returns the valid ones
+func (s *Server) reopenSinks(owner string, loaded []*Sink) []*Sink {
+	sinks := make([]*Sink, 0, len(loaded))
+	for _, sink := range loaded {
+		err := sink.prepare()
+		if err != nil {
+			log.Warnf("Dropping sink %s of %s: %s", sink.ID, owner, err.Error())
+			continue
+		}
+		s.openOutbox(sink)
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// closeSinks stops passing calls on to the sinks
+func closeSinks(sinks []*Sink) {
+	for _, sink := range sinks {
+		if sink.outbox != nil {
+			sink.outbox.close()
+		}
+		if sink.driver == nil {
+			continue
+		}
+		err := sink.driver.close()
+		if err != nil {
+			log.Warnf("Could not close sink %s: %s", sink.ID, err.Error())
+		}
+	}
+}
+
+// removeSinks closes sinks that are not used anymore and drops the calls left in their outboxes
+func (s *Server) removeSinks(sinks []*Sink) {
+	closeSinks(sinks)
+	for _, sink := range sinks {
+		if !durableSinks[sink.Type] {
+			continue
+		}
+		err := s.DB.DeleteOutbox(sink.ID)
+		if err != nil {
+			log.Errorf("Could not remove outbox of sink %s: %s", sink.ID, err.Error())
+		}
+	}
+}
+
+// findSink returns the sink of a client or hook identified by id, or nil. The caller holds s.mu
+func (s *Server) findSink(id string) *Sink {
+	for _, c := range s.Clients {
+		for _, sink := range c.Sinks {
+			if sink.ID == id {
+				return sink
+			}
+		}
+		for _, h := range c.Hooks {
+			for _, sink := range h.Sinks {
+				if sink.ID == id {
+					return sink
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sinkRuns returns a run of every sink of the hook and of its clients for msg, a serialized request received at
+// receivedAt
+func (w *Webhook) sinkRuns(msg []byte, deliveryKey string, receivedAt time.Time) []*sinkRun {
+	sinks := append(make([]*Sink, 0, len(w.Sinks)), w.Sinks...)
+	for _, c := range w.clients() {
+		sinks = append(sinks, c.Sinks...)
+	}
+
+	runs := make([]*sinkRun, 0, len(sinks))
+	for _, sink := range sinks {
+		runs = append(runs, &sinkRun{sink: sink, hook: w, msg: msg, receivedAt: receivedAt, deliveryKey: deliveryKey})
+	}
+	return runs
+}
+
+// pendingSinkResults returns the results of sink runs that just started
+func pendingSinkResults(runs []*sinkRun) []SinkResult {
+	results := make([]SinkResult, 0, len(runs))
+	for _, r := range runs {
+		results = append(results, SinkResult{Sink: r.sink.ID, Type: r.sink.Type, Outcome: OutcomePushing})
+	}
+	return results
+}
+
+// startSinks passes calls on to their sinks in the background. Calls to brokers are put into the outbox of their sink
+func (s *Server) startSinks(runs []*sinkRun) {
+	for _, r := range runs {
+		if r.sink.outbox != nil {
+			s.enqueueSink(r)
+			continue
+		}
+		go s.runSink(r)
+	}
+}
+
+// runSink passes a call on to its sink and records the result
+func (s *Server) runSink(r *sinkRun) {
+	result := SinkResult{Sink: r.sink.ID, Type: r.sink.Type, Outcome: OutcomeDelivered}
+
+	call, err := r.entry().call()
+	if err == nil {
+		err = r.sink.driver.send(call)
+	}
+	if err != nil {
+		result.Outcome = OutcomeFailed
+		result.Error = err.Error()
+		log.Warnf("Sink %s of %s failed: %s", r.sink.Type, r.hook.Identifier, err.Error())
+	}
+
+	s.recordSinkResult(r.deliveryKey, result)
+}
+
+// recordSinkResult adds the result of a sink to the delivery record at deliveryKey
+func (s *Server) recordSinkResult(deliveryKey string, result SinkResult) {
+	if deliveryKey == "" {
+		return
+	}
+	err := s.DB.UpdateDelivery(deliveryKey, func(d *Delivery) {
+		d.recordSink(result)
+	})
+	if err != nil {
+		log.Errorf("Could not update delivery: %s", err.Error())
+	}
+}
+
+// recordSink adds the result of a sink run to the delivery. A call to a broker that failed stays queued in the outbox
+// of its sink until it is passed on. The delivery failed if no receiver got it and nothing is left to pass it on to
+func (d *Delivery) recordSink(result SinkResult) {
+	for i := range d.Sinks {
+		if d.Sinks[i].Sink == result.Sink && (d.Sinks[i].Outcome == OutcomePushing || d.Sinks[i].Outcome == OutcomeQueued) {
+			d.Sinks[i] = result
+		}
+	}
+
+	if result.Outcome == OutcomeDelivered {
+		d.Outcome = OutcomeDelivered
+		d.Receivers++
+	} else {
+		d.settle()
+	}
+}
+
+// settle sets the outcome of a delivery nobody received yet once nothing is pushed or passed on to a sink anymore.
+// It is queued if a sink still has it in its outbox and failed otherwise
+func (d *Delivery) settle() {
+	if d.Outcome != OutcomePushing || d.pending() {
+		return
+	}
+	d.Outcome = OutcomeFailed
+	for _, sink := range d.Sinks {
+		if sink.Outcome == OutcomeQueued {
+			d.Outcome = OutcomeQueued
+		}
+	}
+}
+
+// pending tells whether the delivery is still being pushed to a target or passed on to a sink
+func (d *Delivery) pending() bool {
+	for _, t := range d.Targets {
+		if t.Outcome == OutcomePushing {
+			return true
+		}
+	}
+	for _, sink := range d.Sinks {
+		if sink.Outcome == OutcomePushing {
+			return true
+		}
+	}
+	return false
+}
+
+// entry returns the run as it is stored in the outbox of its sink
+func (r *sinkRun) entry() *outboxEntry {
+	return &outboxEntry{
+		Delivery:   r.deliveryKey,
+		Hook:       r.hook.Identifier,
+		HookUUID:   r.hook.UUID,
+		Client:     r.hook.client.Name,
+		ReceivedAt: r.receivedAt,
+		Message:    r.msg,
+	}
+}
+
+// call reads the serialized request of the entry
+func (e *outboxEntry) call() (*SinkCall, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(e.Message)))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	call := &SinkCall{
+		Delivery:   req.Header.Get(DeliveryHeader),
+		Hook:       e.Hook,
+		HookUUID:   e.HookUUID,
+		Client:     e.Client,
+		ReceivedAt: e.ReceivedAt,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Query:      req.URL.RawQuery,
+		Header:     req.Header,
+		body:       body,
+	}
+
+	if len(body) == 0 {
+		return call, nil
+	}
+	if isJSON(req.Header.Get("Content-Type")) && json.Valid(body) {
+		call.Body = body
+	} else {
+		call.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	return call, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"regexp"
+	"strings"
+)
+
+const maxSlugLength = 128
+
+// slugPattern allows lowercase path segments like acme/github-push
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*(/[a-z0-9][a-z0-9_-]*)*$`)
+
+// SlugRequest sets the vanity path of a hook. If KeepAlias is set, the previous slug stays reachable as an alias
+type SlugRequest struct {
+	Slug      string `json:"slug"`
+	KeepAlias bool   `json:"keepAlias"`
+}
+
+// SetHookSlug sets the vanity path the hook identified by identifier of the given client is reachable at in addition to its UUID.
+// An empty slug removes it. If keepAlias is set, the previous slug stays reachable until the aliases are removed.
+// Returns the updated hook
+func (s *Server) SetHookSlug(clientname, identifier, slug string, keepAlias bool) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, err := s.findHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	slug = strings.Trim(slug, "/")
+	if slug == hook.Slug {
+		return hook, nil
+	}
+
+	if slug != "" {
+		if err = s.validateSlug(hook, slug); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	if hook.Slug != "" {
+		if keepAlias {
+			hook.Aliases = append(hook.Aliases, hook.Slug)
+		} else {
+			delete(s.slugs, hook.Slug)
+		}
+	}
+
+	// a previous alias might become the slug again
+	aliases := make([]string, 0, len(hook.Aliases))
+	for _, a := range hook.Aliases {
+		if a != slug {
+			aliases = append(aliases, a)
+		}
+	}
+	hook.Aliases = aliases
+
+	hook.Slug = slug
+	hook.SlugURL = ""
+	if slug != "" {
+		hook.SlugURL = s.hookURL(slug)
+		s.slugs[slug] = hook
+	}
+
+	return hook, s.DB.Store(hook.client)
+}
+
+// RemoveHookAliases makes the previous slugs of the hook identified by identifier of the given client unreachable
+func (s *Server) RemoveHookAliases(clientname, identifier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, err := s.findHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range hook.Aliases {
+		delete(s.slugs, a)
+	}
+	hook.Aliases = nil
+
+	return s.DB.Store(hook.client)
+}
+
+// validateSlug checks whether hook may be reachable at slug. The caller holds s.mu
+func (s *Server) validateSlug(hook *Webhook, slug string) error {
+	if len(slug) > maxSlugLength {
+		return &ErrInvalidSlug{Slug: slug, Message: "it is longer than 128 characters"}
+	}
+
+	if !slugPattern.MatchString(slug) {
+		return &ErrInvalidSlug{Slug: slug, Message: "only lowercase letters, digits, '-' and '_' separated by '/' are allowed"}
+	}
+
+	if other := s.slugs[slug]; other != nil && other != hook {
+		return &ErrSlugAlreadyExists{Slug: slug}
+	}
+
+	if s.Hooks[slug] != nil {
+		return &ErrSlugAlreadyExists{Slug: slug}
+	}
+
+	return nil
+}
+
+// resolveHook returns the hook reachable at path, which is either its UUID, slug or one of its aliases
+func (s *Server) resolveHook(path string) *Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	path = strings.Trim(path, "/")
+	if s.Hooks[path] != nil {
+		return s.Hooks[path]
+	}
+	return s.slugs[path]
+}
+
+// indexHook makes a hook reachable. The caller holds s.mu
+func (s *Server) indexHook(h *Webhook) {
+	s.Hooks[h.UUID] = h
+	if h.Slug != "" {
+		h.SlugURL = s.hookURL(h.Slug)
+		s.slugs[h.Slug] = h
+	}
+	for _, a := range h.Aliases {
+		s.slugs[a] = h
+	}
+}
+
+// unindexHook makes a hook unreachable. The caller holds s.mu
+func (s *Server) unindexHook(h *Webhook) {
+	delete(s.Hooks, h.UUID)
+	if h.Slug != "" {
+		delete(s.slugs, h.Slug)
+	}
+	for _, a := range h.Aliases {
+		delete(s.slugs, a)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// SSEEventRequest is the event of a raw call on the event stream, its data is the base64 encoded request
+	SSEEventRequest = "request"
+	// SSEEventCloudEvent is the event of a call passed on as CloudEvent, its data is the JSON event
+	SSEEventCloudEvent = "cloudevent"
+)
+
+// WaitQuery is the query parameter of the PollPath with the time to wait for calls, e.g. 30s
+const WaitQuery = "wait"
+
+// AckQuery is the query parameter of the PollPath with the cursor of the previous poll, whose calls were received
+const AckQuery = "ack"
+
+const (
+	defaultPollWait = 30 * time.Second
+	maxPollWait     = 2 * time.Minute
+	// pollTimeout is the time a poll waits for its acknowledgement and calls are queued for a client after its poll
+	pollTimeout = 2 * time.Minute
+	// keepAliveInterval is the time between comments on idle event streams, so proxies don't close them
+	keepAliveInterval = 15 * time.Second
+)
+
+// Poll is the answer to a long-poll, the calls in the order they arrived. Raw calls are serialized requests,
+// CloudEvents their JSON. Cursor acknowledges the calls with the AckQuery of the next poll
+type Poll struct {
+	Messages [][]byte `json:"messages"`
+	Cursor   string   `json:"cursor,omitempty"`
+}
+
+// Stream sends the calls of the client as server-sent events until the caller disconnects. Requests that were queued
+// while nobody was connected are sent first. format is the output format of the stream, or empty to use the one of
+// each hook
+func (s *Server) Stream(client *Client, con *gin.Context, format string) {
+	stream := client.openStream(s.queueLimit+defaultMessageBuffer, format)
+	defer client.removeConnection(stream)
+
+	header := con.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// nginx buffers responses otherwise
+	header.Set("X-Accel-Buffering", "no")
+	con.Status(http.StatusOK)
+
+	write := func(q *queuedRequest) error {
+		_, err := con.Writer.WriteString(sseEvent(q.Message))
+		con.Writer.Flush()
+		return err
+	}
+
+	s.flushQueue(client, format, write)
+	// the headers are sent even if nothing was queued
+	con.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case q := <-stream.messages:
+			if write(q) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			_, err := con.Writer.WriteString(": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			con.Writer.Flush()
+		case <-stream.closed:
+			return
+		case <-con.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// Poll waits up to wait for calls of the client and returns them. Requests that were queued while nobody was connected
+// are returned right away, calls arriving between two polls are queued for the next one. ack is the cursor of the
+// previous poll, its calls are acknowledged. Calls of polls that are not acknowledged within 2 minutes, or whose caller
+// left before the answer, are queued again. format is the output format, or empty to use the one of each hook
+func (s *Server) Poll(client *Client, con *gin.Context, format string, wait time.Duration, ack string) *Poll {
+	if ack != "" {
+		client.ackPoll(ack)
+	}
+
+	poll := &Poll{Messages: make([][]byte, 0)}
+	calls := make([]*queuedRequest, 0)
+	stream := client.openStream(s.queueLimit+defaultMessageBuffer, format)
+
+	s.flushQueue(client, format, func(q *queuedRequest) error {
+		calls = append(calls, q)
+		return nil
+	})
+
+	if len(calls) == 0 {
+		timeout := time.NewTimer(wait)
+		select {
+		case q := <-stream.messages:
+			calls = append(calls, q)
+		case <-timeout.C:
+		case <-stream.closed:
+		case <-con.Request.Context().Done():
+		}
+		timeout.Stop()
+	}
+
+	// calls that arrived meanwhile are part of this poll
+	client.removeConnection(stream)
+	for pending := true; pending; {
+		select {
+		case q := <-stream.messages:
+			calls = append(calls, q)
+		default:
+			pending = false
+		}
+	}
+
+	unacked := &unackedCalls{calls: make(map[string]*queuedRequest)}
+	for _, q := range calls {
+		poll.Messages = append(poll.Messages, q.Message)
+		if q.Delivery != "" {
+			unacked.add(q.Delivery, q)
+		}
+	}
+
+	if con.Request.Context().Err() != nil {
+		s.requeueUnacked(client, unacked, stream)
+	} else if len(unacked.calls) > 0 {
+		poll.Cursor = client.addPoll(func() {
+			s.requeueUnacked(client, unacked, stream)
+		})
+	}
+	client.polled(time.Now())
+	return poll
+}
+
+// addPoll remembers an answered poll until it is acknowledged and returns its cursor. requeue queues its calls again,
+// it is called if the poll is not acknowledged in time
+func (c *Client) addPoll(requeue func()) string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Errorf("Could not create poll cursor: %s", err.Error())
+		// without cursor the calls can't be acknowledged, they are sent again
+		requeue()
+		return ""
+	}
+	cursor := id.String()
+
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	if c.polls == nil {
+		c.polls = make(map[string]*time.Timer)
+	}
+	c.polls[cursor] = time.AfterFunc(pollTimeout, func() {
+		c.wsMu.Lock()
+		delete(c.polls, cursor)
+		c.wsMu.Unlock()
+		requeue()
+	})
+	return cursor
+}
+
+// ackPoll forgets the calls of the poll with the given cursor. Unknown cursors are ignored, their calls were queued
+// again already
+func (c *Client) ackPoll(cursor string) {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	if timer := c.polls[cursor]; timer != nil && timer.Stop() {
+		delete(c.polls, cursor)
+	}
+}
+
+// polled records the end of a poll
+func (c *Client) polled(now time.Time) {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	c.polledAt = now
+}
+
+// polling tells whether the client polled recently and is expected to poll again
+func (c *Client) polling(now time.Time) bool {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	return !c.polledAt.IsZero() && now.Sub(c.polledAt) < pollTimeout
+}
+
+// pollingClients returns the clients that poll
+func pollingClients(clients []*Client) []*Client {
+	now := time.Now()
+	polling := make([]*Client, 0)
+	for _, c := range clients {
+		if c.polling(now) {
+			polling = append(polling, c)
+		}
+	}
+	return polling
+}
+
+// queuedFor returns the clients a call they missed is queued for, all of them if the policy of its hook queues calls
+// and else the ones that poll
+func queuedFor(policy NoReceiverPolicy, clients []*Client) []*Client {
+	if policy.Action == NoReceiverQueue {
+		return clients
+	}
+	return pollingClients(clients)
+}
+
+// ParseWait reads the WaitQuery of a poll, a duration like 30s or a number of seconds. Empty returns the default of
+// 30 seconds, longer waits are cut to 2 minutes
+func ParseWait(wait string) (time.Duration, error) {
+	if wait == "" {
+		return defaultPollWait, nil
+	}
+
+	d, err := time.ParseDuration(wait)
+	if err != nil {
+		seconds, serr := strconv.Atoi(wait)
+		if serr != nil {
+			return 0, &ErrInvalidWait{Wait: wait}
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < 0 {
+		return 0, &ErrInvalidWait{Wait: wait}
+	}
+	if d > maxPollWait {
+		d = maxPollWait
+	}
+	return d, nil
+}
+
+// sseEvent encodes a call as server-sent event. CloudEvents are a single line of JSON, raw requests contain line
+// breaks and are base64 encoded
+func sseEvent(msg []byte) string {
+	if len(msg) > 0 && msg[0] == '{' {
+		return fmt.Sprintf("event: %s\ndata: %s\n\n", SSEEventCloudEvent, msg)
+	}
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", SSEEventRequest, base64.StdEncoding.EncodeToString(msg))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testPoll polls for the calls of the client without waiting. gone tells whether the caller left before the answer
+func testPoll(s *Server, client *Client, ack string, gone bool) *Poll {
+	con, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if gone {
+		cancel()
+	}
+	con.Request, _ = http.NewRequestWithContext(ctx, http.MethodGet, PollPath, nil)
+	return s.Poll(client, con, OutputRaw, 0, ack)
+}
+
+// expirePolls lets the unacknowledged polls of the client time out and waits until their calls are queued again
+func expirePolls(t *testing.T, s *Server, client *Client) {
+	client.wsMu.Lock()
+	for _, timer := range client.polls {
+		timer.Reset(0)
+	}
+	client.wsMu.Unlock()
+
+	waitFor(t, "the polls to time out", func() bool {
+		client.wsMu.Lock()
+		defer client.wsMu.Unlock()
+		return len(client.polls) == 0
+	})
+}
+
+// TestPollQueuesBetweenPolls checks that calls arriving between two polls are queued for the next one whatever the
+// policy of their hook is, and that the calls of a poll are queued again until they are acknowledged
+func TestPollQueuesBetweenPolls(t *testing.T) {
+	s := testServer(t.TempDir())
+	defer s.Stop()
+
+	_, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := s.Clients["testclient"]
+	hook, err := s.AddHook("testclient", "test", HookOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetHookNoReceiverPolicy("testclient", "test", NoReceiverPolicy{Action: NoReceiverReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if poll := testPoll(s, client, "", false); len(poll.Messages) != 0 || poll.Cursor != "" {
+		t.Fatalf("got %d calls and cursor '%s' before the hook was called", len(poll.Messages), poll.Cursor)
+	}
+	callHooks(t, s, hook, 2)
+
+	tables := []struct {
+		ack     bool
+		gone    bool
+		timeout bool
+		calls   int
+	}{
+		// the caller left, the calls are queued again right away
+		{false, true, false, 2},
+		{false, false, false, 2},
+		// the previous poll was not acknowledged, its calls are queued again once it timed out
+		{false, false, false, 0},
+		{false, false, true, 2},
+		{true, false, false, 0},
+	}
+
+	cursor := ""
+	for i, table := range tables {
+		if table.timeout {
+			expirePolls(t, s, client)
+		}
+		ack := ""
+		if table.ack {
+			ack = cursor
+		}
+
+		poll := testPoll(s, client, ack, table.gone)
+		if len(poll.Messages) != table.calls {
+			t.Errorf("poll %d: got %d calls, want %d", i, len(poll.Messages), table.calls)
+		}
+		if (poll.Cursor != "") != (table.calls > 0 && !table.gone) {
+			t.Errorf("poll %d: got cursor '%s' for %d calls", i, poll.Cursor, len(poll.Messages))
+		}
+		if poll.Cursor != "" {
+			cursor = poll.Cursor
+		}
+	}
+
+	if n, _ := s.DB.QueueLength(client.ID); n != 0 {
+		t.Errorf("%d calls are left in the queue", n)
+	}
+	deliveries, err := s.DB.Deliveries(hook.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deliveries {
+		if d.Outcome != OutcomeDelivered {
+			t.Errorf("delivery %s: got outcome %s, want %s", d.ID, d.Outcome, OutcomeDelivered)
+		}
+	}
+}
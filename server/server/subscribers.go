@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+// GrantHook shares the hook identified by identifier of the given client with the client named subscriber. Calls of the
+// hook are delivered to the connections of both clients independently
+func (s *Server) GrantHook(clientname, identifier, subscriber string) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, err := s.findHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := s.Clients[subscriber]
+	if sub == nil {
+		err = &ErrClientNotExists{Name: subscriber}
+		log.Error(err)
+		return nil, err
+	}
+
+	if sub == hook.client {
+		err = &ErrInvalidSubscriber{Client: subscriber}
+		log.Error(err)
+		return nil, err
+	}
+
+	for _, c := range hook.subscribed {
+		if c == sub {
+			return hook, nil
+		}
+	}
+
+	hook.subscribed = append(hook.subscribed, sub)
+	hook.Subscribers = append(hook.Subscribers, sub.ID)
+	return hook, s.DB.Store(hook.client)
+}
+
+// RevokeHook stops sharing the hook identified by identifier of the given client with the client named subscriber
+func (s *Server) RevokeHook(clientname, identifier, subscriber string) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, err := s.findHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := s.Clients[subscriber]
+	if sub == nil {
+		err = &ErrClientNotExists{Name: subscriber}
+		log.Error(err)
+		return nil, err
+	}
+
+	if !hook.unsubscribe(sub) {
+		return hook, nil
+	}
+	return hook, s.DB.Store(hook.client)
+}
+
+// unsubscribe removes the client from the subscribers of the hook and returns whether it was subscribed
+func (w *Webhook) unsubscribe(c *Client) bool {
+	for i, sub := range w.subscribed {
+		if sub == c {
+			w.subscribed = append(w.subscribed[:i], w.subscribed[i+1:]...)
+			w.Subscribers = subscriberIDs(w.subscribed)
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSubscribers links the subscribers of all hooks after loading them. IDs of clients that don't exist anymore are
+// dropped. The caller holds s.mu
+func (s *Server) resolveSubscribers() {
+	clients := make(map[string]*Client)
+	for _, c := range s.Clients {
+		clients[c.ID] = c
+	}
+
+	for _, c := range s.Clients {
+		for _, h := range c.Hooks {
+			h.subscribed = make([]*Client, 0, len(h.Subscribers))
+			for _, id := range h.Subscribers {
+				if clients[id] == nil {
+					log.Warnf("Hook %s of %s is shared with unknown client %s", h.Identifier, c.Name, id)
+					continue
+				}
+				h.subscribed = append(h.subscribed, clients[id])
+			}
+			h.Subscribers = subscriberIDs(h.subscribed)
+		}
+	}
+}
+
+// unsubscribeEverywhere removes a client from the subscribers of all hooks it was granted. The caller holds s.mu
+func (s *Server) unsubscribeEverywhere(client *Client) {
+	for _, c := range s.Clients {
+		changed := false
+		for _, h := range c.Hooks {
+			if h.unsubscribe(client) {
+				changed = true
+			}
+		}
+
+		if changed {
+			err := s.DB.Store(c)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+func subscriberIDs(clients []*Client) []string {
+	ids := make([]string, 0, len(clients))
+	for _, c := range clients {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
@@ -0,0 +1,236 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certificateCheckInterval is the minimum time between two checks whether the certificate files changed
+const certificateCheckInterval = 10 * time.Second
+
+// SSLEnabled returns true if the external API is served with SSL, either with static certificate files or ACME
+func SSLEnabled() bool {
+	return viper.GetBool("ACME") || (viper.GetString("SSLCertificate") != "" && viper.GetString("SSLKey") != "")
+}
+
+// tlsConfig creates the TLS configuration for the external listener. Certificates are either obtained via ACME or loaded from
+// certFile and keyFile. If neither is configured, nil is returned. If ACME is used, the returned manager has to answer HTTP-01 challenges.
+// If a client CA bundle is configured, clients may authenticate with a certificate signed by one of these CAs instead of their secret
+func tlsConfig(certFile, keyFile string) (*tls.Config, *autocert.Manager, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	var manager *autocert.Manager
+	if viper.GetBool("ACME") {
+		var err error
+		manager, err = acmeManager()
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.GetCertificate = manager.GetCertificate
+		cfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	} else if certFile != "" && keyFile != "" {
+		reloader, err := newCertificateReloader(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.GetCertificate = reloader.GetCertificate
+	} else {
+		return nil, nil, nil
+	}
+
+	caFile := viper.GetString("ClientCAFile")
+	if caFile == "" {
+		return cfg, manager, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, &ErrInvalidCertificate{Message: "no certificates found in " + caFile}
+	}
+
+	cfg.ClientCAs = pool
+	// webhook senders do not have a certificate, so the client certificate stays optional
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return cfg, manager, nil
+}
+
+// acmeManager creates the autocert manager for the configured ACME directory, e.g. Let's Encrypt or a local Pebble
+func acmeManager() (*autocert.Manager, error) {
+	domains := viper.GetStringSlice("ACMEDomains")
+	if len(domains) == 0 {
+		return nil, &ErrInvalidCertificate{Message: "ACME requires at least one domain in ACMEDomains"}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile := viper.GetString("ACMECAFile"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, &ErrInvalidCertificate{Message: "no certificates found in " + caFile}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(viper.GetString("ACMECacheDir")),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      viper.GetString("ACMEEmail"),
+		Client: &acme.Client{
+			DirectoryURL: viper.GetString("ACMEDirectoryURL"),
+			HTTPClient:   &http.Client{Transport: transport},
+		},
+	}, nil
+}
+
+// certificateReloader serves a certificate from files and reloads it when the files change, so renewed certificates
+// are used without restarting the server
+type certificateReloader struct {
+	mu                sync.Mutex
+	certFile, keyFile string
+	cert              *tls.Certificate
+	modTime           time.Time
+	lastCheck         time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	return r, r.reload()
+}
+
+func (r *certificateReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *certificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate returns the current certificate. It is meant to be used in tls.Config
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < certificateCheckInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = time.Now()
+
+	modTime, err := r.latestModTime()
+	if err != nil || !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	// while a certificate is replaced the pair might not match yet, keep the old one until both files are valid
+	if err = r.reload(); err != nil {
+		log.Warnf("Could not reload certificate: %s", err.Error())
+		return r.cert, nil
+	}
+	log.Infof("Reloaded certificate %s", r.certFile)
+
+	return r.cert, nil
+}
+
+// certificateIdentities returns all identities of a certificate that may be mapped to a client.
+// These are the subjects common name and all DNS, email and URI subject alternative names
+func certificateIdentities(cert *x509.Certificate) []string {
+	ids := make([]string, 0)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+// validateClientCertificate returns the client the verified peer certificate belongs to or nil.
+// An identity is either mapped to a client using ClientCertificateMap or has to match the clients name or ID
+func (s *Server) validateClientCertificate(state *tls.ConnectionState) *Client {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	// viper lowercases map keys, so identities are compared case insensitive
+	mapping := viper.GetStringMapString("ClientCertificateMap")
+
+	for _, id := range certificateIdentities(state.VerifiedChains[0][0]) {
+		name := id
+		if mapped, ok := mapping[strings.ToLower(id)]; ok {
+			name = mapped
+		}
+
+		s.mu.RLock()
+		client := s.Clients[name]
+		s.mu.RUnlock()
+		if client == nil {
+			client = s.clientByID(name)
+		}
+		if client == nil {
+			continue
+		}
+
+		if client.Disabled {
+			log.Infof("Client '%s' is disabled", client.Name)
+			return nil
+		}
+		return client
+	}
+
+	log.Infof("No client matches certificate '%s'", state.VerifiedChains[0][0].Subject.String())
+	return nil
+}
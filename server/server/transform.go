@@ -0,0 +1,461 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+const (
+	// TransformHeaderAdd sets the header Name to Value
+	TransformHeaderAdd = "header.add"
+	// TransformHeaderRemove removes the header Name
+	TransformHeaderRemove = "header.remove"
+	// TransformHeaderRename moves the values of header Name to header To
+	TransformHeaderRename = "header.rename"
+	// TransformJSONPick keeps only the body fields listed in Fields
+	TransformJSONPick = "json.pick"
+	// TransformJSONRename moves the body field Name to To
+	TransformJSONRename = "json.rename"
+	// TransformJSONRedact replaces the body field Name with Value, or with REDACTED if Value is empty
+	TransformJSONRedact = "json.redact"
+	// TransformTemplate replaces the body with the result of the Go template Template
+	TransformTemplate = "template"
+	// TransformFormToJSON turns a form encoded body into a JSON object. If Name is set, the body is replaced with
+	// the value of that form field instead, like the payload field of form encoded GitHub hooks
+	TransformFormToJSON = "form.json"
+)
+
+// redacted replaces redacted body fields if the transform has no value
+const redacted = "REDACTED"
+
+// Transform is a single step that changes a call of a hook before it is passed on. The fields used depend on the type
+type Transform struct {
+	Type string `json:"type"`
+	// Name is the header or the path of a body field like $.sender.login or commits[0].author
+	Name     string   `json:"name,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Value    string   `json:"value,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Template string   `json:"template,omitempty"`
+}
+
+// TemplateData is available in body templates, e.g. {{ .Body.repository.name }} or {{ .Header.Get "X-GitHub-Event" }}
+type TemplateData struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Body is the decoded JSON body or nil
+	Body    interface{}
+	RawBody string
+}
+
+// TransformDryRun selects a stored delivery the transforms are tested against. Without transforms the ones of the hook are used
+type TransformDryRun struct {
+	Delivery   string      `json:"delivery"`
+	Transforms []Transform `json:"transforms"`
+}
+
+// TransformResult is the call as it would be passed on after the transforms
+type TransformResult struct {
+	Request string `json:"request"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// SetHookTransforms replaces the transforms of the webhook identified by identifier of the given client. They are
+// applied in order, an empty list passes calls on unchanged
+func (s *Server) SetHookTransforms(clientname, identifier string, transforms []Transform) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	if err = validateTransforms(transforms); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	hook.Transforms = transforms
+	return s.storeClient(hook.client)
+}
+
+// DryRunTransforms applies transforms to the call recorded by a delivery of the hook identified by identifier of the
+// given client without passing it on
+func (s *Server) DryRunTransforms(clientname, identifier string, dryRun TransformDryRun) (*TransformResult, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	transforms := dryRun.Transforms
+	if transforms == nil {
+		transforms = hook.Transforms
+	}
+	if err = validateTransforms(transforms); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	deliveries, err := s.DB.Deliveries(hook.UUID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	var raw []byte
+	for _, d := range deliveries {
+		if d.ID == dryRun.Delivery {
+			raw = d.Original
+			if raw == nil {
+				raw = d.Request
+			}
+		}
+	}
+	if raw == nil {
+		err = &ErrDeliveryNotExists{ID: dryRun.Delivery}
+		log.Error(err)
+		return nil, err
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if err = applyTransforms(req, transforms); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	msg, err := serialize(req)
+	if err != nil {
+		return nil, err
+	}
+	return &TransformResult{Request: string(msg)}, nil
+}
+
+func validateTransforms(transforms []Transform) error {
+	for _, t := range transforms {
+		switch t.Type {
+		case TransformHeaderAdd, TransformHeaderRemove, TransformHeaderRename:
+			if t.Name == "" || strings.ContainsAny(t.Name, " :\r\n") {
+				return &ErrInvalidTransform{Message: "'" + t.Name + "' is not a valid header name"}
+			}
+			if t.Type == TransformHeaderRename && (t.To == "" || strings.ContainsAny(t.To, " :\r\n")) {
+				return &ErrInvalidTransform{Message: "'" + t.To + "' is not a valid header name"}
+			}
+			if strings.ContainsAny(t.Value, "\r\n") {
+				return &ErrInvalidTransform{Message: "header '" + t.Name + "' contains a line break"}
+			}
+		case TransformJSONPick:
+			if len(t.Fields) == 0 {
+				return &ErrInvalidTransform{Message: "json.pick needs fields"}
+			}
+			for _, f := range t.Fields {
+				if _, err := parseFieldPath(f); err != nil {
+					return &ErrInvalidTransform{Message: err.Error()}
+				}
+			}
+		case TransformJSONRename, TransformJSONRedact:
+			if _, err := parseFieldPath(t.Name); err != nil {
+				return &ErrInvalidTransform{Message: err.Error()}
+			}
+			if t.Type == TransformJSONRename {
+				if _, err := parseFieldPath(t.To); err != nil {
+					return &ErrInvalidTransform{Message: err.Error()}
+				}
+			}
+		case TransformTemplate:
+			if _, err := template.New("body").Funcs(templateFuncs).Parse(t.Template); err != nil {
+				return &ErrInvalidTransform{Message: err.Error()}
+			}
+		case TransformFormToJSON:
+		default:
+			return &ErrInvalidTransform{Message: "unknown type '" + t.Type + "'"}
+		}
+	}
+	return nil
+}
+
+// transformState holds the body while the transforms are applied, so JSON is only decoded and encoded once
+type transformState struct {
+	req  *http.Request
+	body []byte
+	// data is the decoded JSON body if decoded is set, dirty means it has to be encoded again
+	data           interface{}
+	decoded, dirty bool
+}
+
+// applyTransforms changes req according to the transforms. Transforms of the body are skipped if it is no JSON
+func applyTransforms(req *http.Request, transforms []Transform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	state := &transformState{req: req}
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		state.body = b
+	}
+
+	for _, t := range transforms {
+		if err := state.apply(t); err != nil {
+			return err
+		}
+	}
+
+	if err := state.encode(); err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(state.body))
+	req.ContentLength = int64(len(state.body))
+	req.TransferEncoding = nil
+	return nil
+}
+
+func (st *transformState) apply(t Transform) error {
+	switch t.Type {
+	case TransformHeaderAdd:
+		st.req.Header.Set(t.Name, t.Value)
+	case TransformHeaderRemove:
+		st.req.Header.Del(t.Name)
+	case TransformHeaderRename:
+		values := st.req.Header.Values(t.Name)
+		st.req.Header.Del(t.Name)
+		for _, v := range values {
+			st.req.Header.Add(t.To, v)
+		}
+	case TransformJSONPick:
+		if !st.decode() {
+			return nil
+		}
+		var picked interface{} = make(map[string]interface{})
+		for _, f := range t.Fields {
+			// paths stored before their indexes were limited are skipped
+			steps, err := parseFieldPath(f)
+			if err != nil {
+				continue
+			}
+			if v, ok := lookupField(st.data, steps); ok {
+				picked = setField(picked, steps, v)
+			}
+		}
+		st.data = picked
+		st.dirty = true
+	case TransformJSONRename:
+		if !st.decode() {
+			return nil
+		}
+		from, err := parseFieldPath(t.Name)
+		if err != nil {
+			return nil
+		}
+		to, err := parseFieldPath(t.To)
+		if err != nil {
+			return nil
+		}
+		if v, ok := lookupField(st.data, from); ok {
+			st.data = setField(deleteField(st.data, from), to, v)
+			st.dirty = true
+		}
+	case TransformJSONRedact:
+		if !st.decode() {
+			return nil
+		}
+		steps, err := parseFieldPath(t.Name)
+		if err != nil {
+			return nil
+		}
+		if _, ok := lookupField(st.data, steps); ok {
+			value := t.Value
+			if value == "" {
+				value = redacted
+			}
+			st.data = setField(st.data, steps, value)
+			st.dirty = true
+		}
+	case TransformTemplate:
+		return st.template(t.Template)
+	case TransformFormToJSON:
+		return st.formToJSON(t.Name)
+	}
+	return nil
+}
+
+// decode decodes the JSON body once and tells whether it is JSON
+func (st *transformState) decode() bool {
+	if !st.decoded {
+		dec := json.NewDecoder(bytes.NewReader(st.body))
+		dec.UseNumber()
+		st.data = nil
+		st.decoded = dec.Decode(&st.data) == nil
+	}
+	return st.decoded
+}
+
+// encode writes changes of the decoded body back
+func (st *transformState) encode() error {
+	if !st.dirty {
+		return nil
+	}
+	b, err := json.Marshal(st.data)
+	if err != nil {
+		return err
+	}
+	st.body = b
+	st.dirty = false
+	return nil
+}
+
+// setBody replaces the body, the decoded JSON is discarded
+func (st *transformState) setBody(b []byte) {
+	st.body = b
+	st.data = nil
+	st.decoded = false
+	st.dirty = false
+}
+
+func (st *transformState) template(text string) error {
+	if err := st.encode(); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("body").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+
+	data := TemplateData{
+		Method:  st.req.Method,
+		Path:    st.req.URL.Path,
+		Query:   st.req.URL.Query(),
+		Header:  st.req.Header,
+		RawBody: string(st.body),
+	}
+	if st.decode() {
+		data.Body = st.data
+	}
+
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, data); err != nil {
+		return &ErrInvalidTransform{Message: err.Error()}
+	}
+	st.setBody(b.Bytes())
+	return nil
+}
+
+func (st *transformState) formToJSON(field string) error {
+	if err := st.encode(); err != nil {
+		return err
+	}
+
+	form, err := url.ParseQuery(string(st.body))
+	if err != nil {
+		// no form, leave the body unchanged
+		return nil
+	}
+
+	if field != "" {
+		if _, ok := form[field]; !ok {
+			return nil
+		}
+		st.setBody([]byte(form.Get(field)))
+	} else {
+		obj := make(map[string]interface{})
+		for k, v := range form {
+			if len(v) == 1 {
+				obj[k] = v[0]
+			} else {
+				obj[k] = v
+			}
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		st.setBody(b)
+	}
+
+	st.req.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// setField sets the field at steps to value, creating objects and arrays on the way. Arrays are filled with null up to
+// the index, which parseFieldPath limits to maxFieldIndex. Returns the new root
+func setField(v interface{}, steps []fieldStep, value interface{}) interface{} {
+	if len(steps) == 0 {
+		return value
+	}
+
+	step := steps[0]
+	if step.index < 0 {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			obj = make(map[string]interface{})
+		}
+		obj[step.key] = setField(obj[step.key], steps[1:], value)
+		return obj
+	}
+
+	arr, _ := v.([]interface{})
+	for len(arr) <= step.index {
+		arr = append(arr, nil)
+	}
+	arr[step.index] = setField(arr[step.index], steps[1:], value)
+	return arr
+}
+
+// deleteField removes the field at steps. Returns the new root
+func deleteField(v interface{}, steps []fieldStep) interface{} {
+	if len(steps) == 0 {
+		return v
+	}
+
+	step := steps[0]
+	last := len(steps) == 1
+	if step.index < 0 {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		if last {
+			delete(obj, step.key)
+		} else if child, ok := obj[step.key]; ok {
+			obj[step.key] = deleteField(child, steps[1:])
+		}
+		return obj
+	}
+
+	arr, ok := v.([]interface{})
+	if !ok || step.index >= len(arr) {
+		return v
+	}
+	if last {
+		return append(arr[:step.index], arr[step.index+1:]...)
+	}
+	arr[step.index] = deleteField(arr[step.index], steps[1:])
+	return arr
+}
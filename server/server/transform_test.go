@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sameBody tells whether two bodies are equal, comparing JSON by its content
+func sameBody(got, want string) bool {
+	var g, w interface{}
+	if json.Unmarshal([]byte(got), &g) != nil || json.Unmarshal([]byte(want), &w) != nil {
+		return got == want
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+func TestApplyTransforms(t *testing.T) {
+	tables := []struct {
+		transforms []Transform
+		body       string
+		want       string
+	}{
+		{[]Transform{{Type: TransformJSONPick, Fields: []string{"ref", "commits[0].id"}}}, testFilterBody, `{"ref":"refs/heads/master","commits":[{"id":"a1"}]}`},
+		{[]Transform{{Type: TransformJSONPick, Fields: []string{"$.commits[1].id"}}}, testFilterBody, `{"commits":[null,{"id":"b2"}]}`},
+		{[]Transform{{Type: TransformJSONPick, Fields: []string{"missing"}}}, testFilterBody, `{}`},
+		{[]Transform{{Type: TransformJSONRename, Name: "ref", To: "branch"}}, `{"ref":"main","size":1}`, `{"branch":"main","size":1}`},
+		{[]Transform{{Type: TransformJSONRename, Name: "size", To: "meta.list[2]"}}, `{"size":3}`, `{"meta":{"list":[null,null,3]}}`},
+		{[]Transform{{Type: TransformJSONRename, Name: "list[0]", To: "first"}}, `{"list":[1,2]}`, `{"list":[2],"first":1}`},
+		{[]Transform{{Type: TransformJSONRename, Name: "missing", To: "other"}}, `{"a":1}`, `{"a":1}`},
+		{[]Transform{{Type: TransformJSONRedact, Name: "repository.name"}}, `{"repository":{"name":"api"}}`, `{"repository":{"name":"REDACTED"}}`},
+		{[]Transform{{Type: TransformJSONRedact, Name: "$.token", Value: "***"}}, `{"token":"secret"}`, `{"token":"***"}`},
+		{[]Transform{{Type: TransformJSONRedact, Name: "token"}}, `{"a":1}`, `{"a":1}`},
+		{[]Transform{{Type: TransformJSONRedact, Name: "list[1]"}}, `{"list":["a","b"]}`, `{"list":["a","REDACTED"]}`},
+		// bodies that are no JSON are left alone
+		{[]Transform{{Type: TransformJSONPick, Fields: []string{"ref"}}}, `ref=main`, `ref=main`},
+		{[]Transform{{Type: TransformTemplate, Template: `{{ .Body.ref }}`}}, `{"ref":"main"}`, `main`},
+		{[]Transform{{Type: TransformFormToJSON}, {Type: TransformJSONRename, Name: "ref", To: "branch"}}, `ref=main`, `{"branch":"main"}`},
+		{[]Transform{{Type: TransformFormToJSON, Name: "payload"}}, `payload=%7B%22a%22%3A1%7D`, `{"a":1}`},
+		{[]Transform{{Type: TransformJSONRename, Name: "a", To: "b"}, {Type: TransformJSONPick, Fields: []string{"b"}}}, `{"a":1,"c":2}`, `{"b":1}`},
+	}
+
+	for _, table := range tables {
+		req, err := http.NewRequest(http.MethodPost, "/h/test", strings.NewReader(table.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = applyTransforms(req, table.transforms)
+		if err != nil {
+			t.Errorf("Error applying %+v: %s", table.transforms, err.Error())
+			continue
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		if !sameBody(string(b), table.want) {
+			t.Errorf("%+v turned %s into %s, want %s", table.transforms, table.body, b, table.want)
+		}
+		if req.ContentLength != int64(len(b)) {
+			t.Errorf("%+v: content length is %d, body has %d bytes", table.transforms, req.ContentLength, len(b))
+		}
+	}
+}
+
+func TestApplyHeaderTransforms(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/h/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Token", "secret")
+	req.Header.Add("X-Gitea-Event", "push")
+
+	err = applyTransforms(req, []Transform{
+		{Type: TransformHeaderRemove, Name: "X-Token"},
+		{Type: TransformHeaderRename, Name: "X-Gitea-Event", To: "X-Event"},
+		{Type: TransformHeaderAdd, Name: "X-Source", Value: "gitea"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		header string
+		value  string
+	}{
+		{"X-Token", ""},
+		{"X-Gitea-Event", ""},
+		{"X-Event", "push"},
+		{"X-Source", "gitea"},
+	}
+
+	for _, table := range tables {
+		if v := req.Header.Get(table.header); v != table.value {
+			t.Errorf("header %s is '%s', want '%s'", table.header, v, table.value)
+		}
+	}
+}
+
+// TestTransformsWithInvalidPaths checks that transforms stored before field indexes were limited leave the body alone
+func TestTransformsWithInvalidPaths(t *testing.T) {
+	body := `{"list":[1,2],"ref":"main"}`
+
+	tables := []struct {
+		transform Transform
+		want      string
+	}{
+		{Transform{Type: TransformJSONPick, Fields: []string{"ref", "list[5000]"}}, `{"ref":"main"}`},
+		{Transform{Type: TransformJSONRename, Name: "list[5000]", To: "first"}, body},
+		{Transform{Type: TransformJSONRename, Name: "ref", To: "list[100000000]"}, body},
+		{Transform{Type: TransformJSONRedact, Name: "list[5000]"}, body},
+	}
+
+	for _, table := range tables {
+		if err := validateTransforms([]Transform{table.transform}); err == nil {
+			t.Errorf("%+v was accepted", table.transform)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/h/test", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = applyTransforms(req, []Transform{table.transform})
+		if err != nil {
+			t.Errorf("Error applying %+v: %s", table.transform, err.Error())
+			continue
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		if !sameBody(string(b), table.want) {
+			t.Errorf("%+v turned %s into %s, want %s", table.transform, body, b, table.want)
+		}
+	}
+}
+
+func TestValidateTransforms(t *testing.T) {
+	tables := []struct {
+		transform Transform
+		valid     bool
+	}{
+		{Transform{Type: TransformHeaderAdd, Name: "X-Source", Value: "ci"}, true},
+		{Transform{Type: TransformHeaderAdd, Name: "X Source"}, false},
+		{Transform{Type: TransformHeaderAdd, Name: "X-Source", Value: "a\r\nb"}, false},
+		{Transform{Type: TransformHeaderRename, Name: "X-A"}, false},
+		{Transform{Type: TransformJSONPick}, false},
+		{Transform{Type: TransformJSONPick, Fields: []string{"list[1000]"}}, true},
+		{Transform{Type: TransformJSONPick, Fields: []string{"ref", "list[1001]"}}, false},
+		{Transform{Type: TransformJSONRename, Name: "a", To: "b[0]"}, true},
+		{Transform{Type: TransformJSONRename, Name: "a", To: "b[2000]"}, false},
+		{Transform{Type: TransformJSONRename, Name: "a"}, false},
+		{Transform{Type: TransformJSONRedact, Name: "$"}, false},
+		{Transform{Type: TransformTemplate, Template: "{{ .Body.ref }}"}, true},
+		{Transform{Type: TransformTemplate, Template: "{{ .Body.ref"}, false},
+		{Transform{Type: TransformFormToJSON}, true},
+		{Transform{Type: "json.sort"}, false},
+	}
+
+	for _, table := range tables {
+		err := validateTransforms([]Transform{table.transform})
+		if table.valid && err != nil {
+			t.Errorf("Error validating %+v: %s", table.transform, err.Error())
+		}
+		if !table.valid && err == nil {
+			t.Errorf("%+v was accepted", table.transform)
+		}
+	}
+}
@@ -9,43 +9,169 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // Webhook contains the information about a webhook
 type Webhook struct {
-	URL        string    `json:"url"`
-	Identifier string    `json:"identifier"`
-	UUID       string    `json:"uuid"`
-	CreatedAt  time.Time `json:"createdAt"`
-	LastCall   time.Time `json:"lastCall"`
-	client     *Client
+	URL         string            `json:"url"`
+	Identifier  string            `json:"identifier"`
+	UUID        string            `json:"uuid"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Provider    string            `json:"provider,omitempty"`
+	Slug        string            `json:"slug,omitempty"`
+	SlugURL     string            `json:"slugUrl,omitempty"`
+	Aliases     []string          `json:"aliases,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	LastCall    time.Time         `json:"lastCall"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`
+	MaxCalls    int               `json:"maxCalls,omitempty"`
+	Calls       int               `json:"calls"`
+	IPFilter    IPFilter          `json:"ipFilter"`
+	Limits      Limits            `json:"limits"`
+	Response    *Response         `json:"response,omitempty"`
+	Filter      *Filter           `json:"filter,omitempty"`
+	Transforms  []Transform       `json:"transforms,omitempty"`
+	Output      *Output           `json:"output,omitempty"`
+	Sinks       []*Sink           `json:"sinks,omitempty"`
+	NoReceiver  NoReceiverPolicy  `json:"noReceiver"`
+	Pause       Pause             `json:"pause"`
+	Subscribers []string          `json:"subscribers,omitempty"`
+	WebSub      *WebSub           `json:"websub,omitempty"`
+	client      *Client
+	subscribed  []*Client
+	limiter     *limiter
+	// pauseMu guards Pause against a resume while a call is buffered
+	pauseMu sync.Mutex
+	// resumeMu lets only one resume drain the buffer at a time
+	resumeMu sync.Mutex
+	// callsMu guards Calls and reserved
+	callsMu sync.Mutex
+	// reserved are calls that count against MaxCalls while they are passed on
+	reserved int
 }
 
-// Handle relays the request to all connected clients
-func (w *Webhook) Handle(req *http.Request) error {
+// MarshalJSON encodes the hook with Calls read under callsMu
+func (w *Webhook) MarshalJSON() ([]byte, error) {
+	type webhook Webhook
+	return json.Marshal(&struct {
+		*webhook
+		Calls int `json:"calls"`
+	}{(*webhook)(w), w.calls()})
+}
+
+// Handle transforms the request and relays it to the connected receivers of its client and of every subscribed client.
+// The serialized request, the number of receivers, the pushes to targets and the runs of the sinks of the hook and its
+// clients are recorded in d, they are started once d is stored. Returns the clients that had neither a receiver
+// connected nor a target or sink
+func (w *Webhook) Handle(req *http.Request, d *Delivery) ([]*Client, error) {
+	err := w.prepare(req, d)
+	if err != nil {
+		return nil, err
+	}
+
+	receivers, pushes, missed := w.broadcast(d.Request, d.key)
+	d.Receivers = receivers
+	d.pushes = pushes
+	if len(pushes) > 0 {
+		d.Targets = pendingResults(pushes)
+	}
+	d.sinks = w.sinkRuns(d.Request, d.key, d.ReceivedAt)
+	if len(d.sinks) > 0 {
+		d.Sinks = pendingSinkResults(d.sinks)
+	}
+	return missed, nil
+}
+
+// broadcast sends msg to the connections of its client and of every subscribed client. Returns the number of receivers,
+// the pushes to the targets of the clients, which the caller has to start, and the clients that had neither a receiver
+// connected nor a target or sink. deliveryKey is the key of the delivery record the pushes report to
+func (w *Webhook) broadcast(msg []byte, deliveryKey string) (int, []*push, []*Client) {
+	receivers := 0
+	pushes := make([]*push, 0)
+	missed := make([]*Client, 0)
+	for _, c := range w.clients() {
+		n := c.broadcast(w, msg, deliveryKey)
+		p := c.pushes(w, msg, deliveryKey)
+		if n == 0 && len(p) == 0 && len(c.Sinks) == 0 {
+			missed = append(missed, c)
+		}
+		receivers += n
+		pushes = append(pushes, p...)
+	}
+	return receivers, pushes, missed
+}
+
+// clients returns the client the hook belongs to followed by the clients it is shared with
+func (w *Webhook) clients() []*Client {
+	return append([]*Client{w.client}, w.subscribed...)
+}
+
+// prepare applies the transforms of the hook and serializes the request the way it is passed on to clients into d.
+// If the hook has transforms, the request as it was received is recorded too
+func (w *Webhook) prepare(req *http.Request, d *Delivery) error {
 	w.LastCall = time.Now()
 
+	if len(w.Transforms) > 0 {
+		original, err := serializeKeepBody(req)
+		if err != nil {
+			return err
+		}
+		d.Original = original
+
+		err = applyTransforms(req, w.Transforms)
+		if err != nil {
+			return err
+		}
+	}
+
+	msg, err := serialize(req)
+	if err != nil {
+		return err
+	}
+	d.Request = msg
+	return nil
+}
+
+// serialize writes the request the way it is passed on to clients
+func serialize(req *http.Request) ([]byte, error) {
 	var b bytes.Buffer
 	writer := bufio.NewWriter(&b)
 	err := req.WriteProxy(writer)
 	if err != nil {
 		log.Errorf("Cloud not write request: %s ", err.Error())
-		return err
+		return nil, err
 	}
 	err = writer.Flush()
 	if err != nil {
 		log.Error("Error flushing writer")
-		return err
+		return nil, err
 	}
 
-	for _, ws := range w.client.ws {
-		err = ws.Broadcast(b.Bytes())
+	return b.Bytes(), nil
+}
+
+// serializeKeepBody serializes the request and puts its body back, so it can be read again
+func serializeKeepBody(req *http.Request) ([]byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
 		if err != nil {
-			log.Error("Could not send to websocket")
+			return nil, err
 		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
 	}
 
-	return nil
+	msg, err := serialize(req)
+	if req.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return msg, err
 }
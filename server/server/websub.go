@@ -0,0 +1,576 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WebSubPending means the hub was asked to subscribe and has not verified the intent yet
+	WebSubPending = "pending"
+	// WebSubSubscribed means the hub verified the subscription, its content is passed on until the lease ends
+	WebSubSubscribed = "subscribed"
+	// WebSubRenewing means the hub was asked to renew the subscription and has not verified it yet, its content is
+	// passed on until the lease ends
+	WebSubRenewing = "renewing"
+	// WebSubDenied means the hub refused the subscription, see Error
+	WebSubDenied = "denied"
+	// WebSubFailed means the hub did not accept the subscription request, see Error
+	WebSubFailed = "failed"
+	// WebSubExpired means the lease ended before it could be renewed
+	WebSubExpired = "expired"
+	// WebSubUnsubscribing means the hub was asked to unsubscribe and has not verified the intent yet
+	WebSubUnsubscribing = "unsubscribing"
+)
+
+// OutcomeInvalidSignature means a call to a hook subscribed to a WebSub hub was not signed with the secret of the
+// subscription. The hub gets a success anyway, as the spec demands
+const OutcomeInvalidSignature = "invalidsignature"
+
+// HubSignatureHeader contains the signature of content distributed by a WebSub hub
+const HubSignatureHeader = "X-Hub-Signature"
+
+// websubTimeout is how long a request to a hub or topic may take
+const websubTimeout = 10 * time.Second
+
+// websubRetry is the time before a failed renewal is tried again
+const websubRetry = 5 * time.Minute
+
+// maxLeaseSeconds is the longest lease of a subscription, 30 days. Longer leases granted by hubs are cut
+const maxLeaseSeconds = 30 * 24 * 60 * 60
+
+// maxDiscoveryBody is how much of a topic is searched for its hub
+const maxDiscoveryBody = 1024 * 1024
+
+// websubMu guards the subscriptions of all hooks, as hubs verify them while they are requested. It is taken before
+// the lock of the server
+var websubMu sync.Mutex
+
+var linkTagPattern = regexp.MustCompile(`(?i)<(?:atom:)?link\s[^>]*>`)
+var linkAttrPattern = regexp.MustCompile(`(?i)\b(rel|href)\s*=\s*["']([^"']*)["']`)
+
+// WebSub is the subscription of a hook to a topic of a WebSub (PubSubHubbub) hub. The URL of the hook is the callback,
+// content the hub distributes is passed on like any other call once its signature is verified
+type WebSub struct {
+	// Hub is discovered from the topic if it is empty
+	Hub   string `json:"hub"`
+	Topic string `json:"topic"`
+	// LeaseSeconds is requested from the hub, which has the final say. 0 leaves it to the hub, at most 30 days
+	LeaseSeconds int `json:"leaseSeconds,omitempty"`
+	// Secret is generated, the hub signs the content with it
+	Secret string `json:"secret"`
+	State  string `json:"state"`
+	// Error tells why the hub denied the subscription or a request to it failed
+	Error       string     `json:"error,omitempty"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	VerifiedAt  *time.Time `json:"verifiedAt,omitempty"`
+	// ExpiresAt is the end of the lease the hub granted. The subscription is renewed before
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// GetWebSub returns the WebSub subscription of the hook identified by identifier of the given client
+func (s *Server) GetWebSub(clientname, identifier string) (*WebSub, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	websubMu.Lock()
+	defer websubMu.Unlock()
+	if hook.WebSub == nil {
+		err = &ErrWebSubNotExists{Identifier: identifier}
+		log.Error(err)
+		return nil, err
+	}
+	ws := *hook.WebSub
+	return &ws, nil
+}
+
+// SubscribeWebSub asks the hub to subscribe the hook identified by identifier of the given client to a topic. The
+// subscription is pending until the hub verifies it by calling the hook. A previous subscription of the hook is
+// replaced and runs out with its lease
+func (s *Server) SubscribeWebSub(clientname, identifier string, sub WebSub) (*WebSub, error) {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isHTTPURL(sub.Topic) {
+		err = &ErrInvalidWebSub{Message: "topic '" + sub.Topic + "' needs a http or https scheme and a host"}
+		log.Error(err)
+		return nil, err
+	}
+	if sub.LeaseSeconds < 0 || sub.LeaseSeconds > maxLeaseSeconds {
+		err = &ErrInvalidWebSub{Message: "leaseSeconds must be between 0 and " + strconv.Itoa(maxLeaseSeconds)}
+		log.Error(err)
+		return nil, err
+	}
+
+	if sub.Hub == "" {
+		sub.Hub, sub.Topic, err = s.discoverHub(sub.Topic)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+	if !isHTTPURL(sub.Hub) {
+		err = &ErrInvalidWebSub{Message: "hub '" + sub.Hub + "' needs a http or https scheme and a host"}
+		log.Error(err)
+		return nil, err
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	ws := &WebSub{
+		Hub:          sub.Hub,
+		Topic:        sub.Topic,
+		LeaseSeconds: sub.LeaseSeconds,
+		Secret:       secret,
+		State:        WebSubPending,
+		RequestedAt:  time.Now(),
+	}
+
+	// stored before the hub is asked, as hubs may verify before they answer
+	websubMu.Lock()
+	hook.WebSub = ws
+	err = s.storeClient(hook.client)
+	websubMu.Unlock()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	err = s.requestWebSub(hook, ws, "subscribe")
+	websubMu.Lock()
+	defer websubMu.Unlock()
+	if err != nil {
+		log.Error(err)
+		if hook.WebSub == ws {
+			ws.State = WebSubFailed
+			ws.Error = err.Error()
+			s.storeWebSub(hook)
+		}
+		return nil, err
+	}
+
+	result := *ws
+	return &result, nil
+}
+
+// UnsubscribeWebSub asks the hub to unsubscribe the hook identified by identifier of the given client. The subscription
+// is removed once the hub verified it. Subscriptions the hub does not know anymore are removed right away
+func (s *Server) UnsubscribeWebSub(clientname, identifier string) error {
+	hook, err := s.getHook(clientname, identifier)
+	if err != nil {
+		return err
+	}
+
+	websubMu.Lock()
+	ws := hook.WebSub
+	if ws == nil {
+		websubMu.Unlock()
+		err = &ErrWebSubNotExists{Identifier: identifier}
+		log.Error(err)
+		return err
+	}
+	if ws.State != WebSubPending && ws.State != WebSubSubscribed && ws.State != WebSubRenewing && ws.State != WebSubUnsubscribing {
+		hook.WebSub = nil
+		err = s.storeClient(hook.client)
+		websubMu.Unlock()
+		return err
+	}
+	previous := ws.State
+	ws.State = WebSubUnsubscribing
+	ws.RequestedAt = time.Now()
+	err = s.storeClient(hook.client)
+	websubMu.Unlock()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	err = s.requestWebSub(hook, ws, "unsubscribe")
+	if err != nil {
+		log.Error(err)
+		websubMu.Lock()
+		if hook.WebSub == ws {
+			ws.State = previous
+			ws.Error = err.Error()
+			s.storeWebSub(hook)
+		}
+		websubMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// VerifyWebSub answers the verification of intent a hub sends to the hook at path. Returns the challenge the hub
+// expects if it asks for the subscription, renewal or unsubscription that was requested, ErrWebSubNotExists otherwise.
+// Leases longer than 30 days are cut
+func (s *Server) VerifyWebSub(path string, req *http.Request) (string, error) {
+	hook := s.resolveHook(path)
+	if hook == nil || hook.expired(time.Now()) != "" {
+		return "", &ErrHookNotExists{Identifier: path}
+	}
+
+	err := s.checkIP(hook, req)
+	if err != nil {
+		log.Warn(err)
+		return "", err
+	}
+
+	websubMu.Lock()
+	defer websubMu.Unlock()
+
+	query := req.URL.Query()
+	ws := hook.WebSub
+	if ws == nil || query.Get("hub.topic") != ws.Topic {
+		err = &ErrWebSubNotExists{Identifier: hook.Identifier}
+		log.Warn(err)
+		return "", err
+	}
+
+	now := time.Now()
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		{
+			if ws.State != WebSubPending && ws.State != WebSubRenewing {
+				break
+			}
+			lease, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+			if err != nil || lease <= 0 {
+				err = &ErrInvalidWebSub{Message: "hub sent an invalid hub.lease_seconds"}
+				log.Warn(err)
+				return "", err
+			}
+			if lease > maxLeaseSeconds {
+				lease = maxLeaseSeconds
+			}
+			expires := now.Add(time.Duration(lease) * time.Second)
+			ws.State = WebSubSubscribed
+			ws.Error = ""
+			ws.VerifiedAt = &now
+			ws.ExpiresAt = &expires
+			log.Infof("Hook %s of %s subscribed to %s until %s", hook.Identifier, hook.client.Name, ws.Topic, expires.Format(time.RFC3339))
+			return query.Get("hub.challenge"), s.storeWebSub(hook)
+		}
+	case "unsubscribe":
+		{
+			if ws.State != WebSubUnsubscribing {
+				break
+			}
+			hook.WebSub = nil
+			log.Infof("Hook %s of %s unsubscribed from %s", hook.Identifier, hook.client.Name, ws.Topic)
+			return query.Get("hub.challenge"), s.storeWebSub(hook)
+		}
+	case "denied":
+		{
+			// only requests the hub has not verified yet can be denied
+			if ws.State != WebSubPending && ws.State != WebSubRenewing && ws.State != WebSubUnsubscribing {
+				break
+			}
+			ws.State = WebSubDenied
+			ws.Error = query.Get("hub.reason")
+			log.Warnf("Hub %s denied the subscription of hook %s of %s: %s", ws.Hub, hook.Identifier, hook.client.Name, ws.Error)
+			return "", s.storeWebSub(hook)
+		}
+	}
+
+	err = &ErrWebSubNotExists{Identifier: hook.Identifier}
+	log.Warn(err)
+	return "", err
+}
+
+// checkWebSubSignature tells whether a call of a hook subscribed to a hub was signed with the secret of the
+// subscription. Calls of other hooks are always fine. The body of req can be read again afterwards
+func (s *Server) checkWebSubSignature(hook *Webhook, d *Delivery, req *http.Request) (bool, error) {
+	websubMu.Lock()
+	secret := ""
+	if hook.WebSub != nil {
+		secret = hook.WebSub.Secret
+	}
+	websubMu.Unlock()
+	if secret == "" {
+		return true, nil
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return false, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if !validHubSignature(req.Header.Get(HubSignatureHeader), secret, body) {
+		log.Warnf("Call of hook %s of %s has no valid %s", hook.Identifier, hook.client.Name, HubSignatureHeader)
+		d.Outcome = OutcomeInvalidSignature
+		return false, nil
+	}
+	return true, nil
+}
+
+// validHubSignature checks a signature like sha256=<hex>, the HMAC of body keyed with secret
+func validHubSignature(signature, secret string, body []byte) bool {
+	split := strings.SplitN(signature, "=", 2)
+	if len(split) != 2 {
+		return false
+	}
+
+	var h func() hash.Hash
+	switch split[0] {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(split[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// renewWebSubs asks the hubs to renew the subscriptions whose lease ends soon. Subscriptions whose lease ended are
+// marked as expired
+func (s *Server) renewWebSubs(now time.Time) {
+	hooks := make([]*Webhook, 0)
+	s.mu.RLock()
+	for _, c := range s.Clients {
+		for _, h := range c.Hooks {
+			hooks = append(hooks, h)
+		}
+	}
+	s.mu.RUnlock()
+
+	websubMu.Lock()
+	defer websubMu.Unlock()
+
+	for _, h := range hooks {
+		ws := h.WebSub
+		if ws == nil || (ws.State != WebSubSubscribed && ws.State != WebSubRenewing) || ws.ExpiresAt == nil || ws.VerifiedAt == nil {
+			continue
+		}
+
+		if now.After(*ws.ExpiresAt) {
+			log.Warnf("Subscription of hook %s of %s to %s expired", h.Identifier, h.client.Name, ws.Topic)
+			ws.State = WebSubExpired
+			s.storeWebSub(h)
+			continue
+		}
+
+		// renew when a tenth of the lease is left, but at least a minute before it ends
+		before := ws.ExpiresAt.Sub(*ws.VerifiedAt) / 10
+		if before < time.Minute {
+			before = time.Minute
+		}
+		if now.Before(ws.ExpiresAt.Add(-before)) {
+			continue
+		}
+		// a renewal was requested and not verified yet
+		if ws.RequestedAt.After(*ws.VerifiedAt) && now.Sub(ws.RequestedAt) < websubRetry {
+			continue
+		}
+
+		ws.State = WebSubRenewing
+		ws.RequestedAt = now
+		s.storeWebSub(h)
+		go s.renewWebSub(h, ws)
+	}
+}
+
+// renewWebSub asks the hub to renew a subscription. The hub verifies it like a new one. If the hub can't be asked, the
+// subscription stays subscribed until the renewal is tried again
+func (s *Server) renewWebSub(hook *Webhook, ws *WebSub) {
+	err := s.requestWebSub(hook, ws, "subscribe")
+	if err == nil {
+		return
+	}
+
+	log.Errorf("Could not renew the subscription of hook %s of %s: %s", hook.Identifier, hook.client.Name, err.Error())
+	websubMu.Lock()
+	defer websubMu.Unlock()
+	if hook.WebSub == ws && ws.State == WebSubRenewing {
+		ws.State = WebSubSubscribed
+		ws.Error = err.Error()
+		s.storeWebSub(hook)
+	}
+}
+
+// storeWebSub persists the subscription of a hook. The caller holds websubMu
+func (s *Server) storeWebSub(hook *Webhook) error {
+	err := s.storeClient(hook.client)
+	if err != nil {
+		log.Errorf("Could not store the subscription of hook %s: %s", hook.Identifier, err.Error())
+	}
+	return err
+}
+
+// requestWebSub sends a subscription request with the given mode to the hub. The hub answers once it verified the
+// intent or later
+func (s *Server) requestWebSub(hook *Webhook, ws *WebSub, mode string) error {
+	form := url.Values{
+		"hub.mode":     {mode},
+		"hub.topic":    {ws.Topic},
+		"hub.callback": {hook.URL},
+	}
+	if mode == "subscribe" {
+		form.Set("hub.secret", ws.Secret)
+		if ws.LeaseSeconds > 0 {
+			form.Set("hub.lease_seconds", strconv.Itoa(ws.LeaseSeconds))
+		}
+	}
+
+	resp, err := s.websubClient().PostForm(ws.Hub, form)
+	if err != nil {
+		return &ErrHubFailed{Hub: ws.Hub, Message: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &ErrHubFailed{Hub: ws.Hub, Message: resp.Status}
+	}
+	return nil
+}
+
+// discoverHub looks up the hub of a topic in its Link headers or link elements and returns it with the URL the topic
+// calls itself
+func (s *Server) discoverHub(topic string) (string, string, error) {
+	resp, err := s.websubClient().Get(topic)
+	if err != nil {
+		return "", "", &ErrInvalidWebSub{Message: "could not discover the hub of '" + topic + "': " + err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", "", &ErrInvalidWebSub{Message: "could not discover the hub of '" + topic + "': " + resp.Status}
+	}
+
+	hub, self := parseLinkHeaders(resp.Header.Values("Link"))
+	if hub == "" {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiscoveryBody))
+		if err != nil {
+			return "", "", &ErrInvalidWebSub{Message: "could not discover the hub of '" + topic + "': " + err.Error()}
+		}
+		hub, self = parseLinkElements(body)
+	}
+	if hub == "" {
+		return "", "", &ErrInvalidWebSub{Message: "'" + topic + "' does not name a hub, set it explicitly"}
+	}
+
+	base := resp.Request.URL
+	hubURL, err := base.Parse(hub)
+	if err != nil {
+		return "", "", &ErrInvalidWebSub{Message: "invalid hub '" + hub + "'"}
+	}
+	if self == "" {
+		return hubURL.String(), topic, nil
+	}
+	selfURL, err := base.Parse(self)
+	if err != nil {
+		return hubURL.String(), topic, nil
+	}
+	return hubURL.String(), selfURL.String(), nil
+}
+
+// websubClient returns a client for requests to hubs and topics, which are reached like push targets
+func (s *Server) websubClient() *http.Client {
+	return &http.Client{
+		Timeout:   websubTimeout,
+		Transport: &http.Transport{DialContext: s.dialer().DialContext, DisableKeepAlives: true},
+	}
+}
+
+// parseLinkHeaders returns the first hub and self links of Link headers like <https://hub.example.com/>; rel="hub"
+func parseLinkHeaders(values []string) (string, string) {
+	hub, self := "", ""
+	for _, v := range values {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+			for _, p := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+				if len(kv) != 2 || strings.ToLower(kv[0]) != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					if rel == "hub" && hub == "" {
+						hub = target
+					}
+					if rel == "self" && self == "" {
+						self = target
+					}
+				}
+			}
+		}
+	}
+	return hub, self
+}
+
+// parseLinkElements returns the first hub and self links of the link elements of an HTML page or feed
+func parseLinkElements(body []byte) (string, string) {
+	hub, self := "", ""
+	for _, tag := range linkTagPattern.FindAll(body, -1) {
+		rel, href := "", ""
+		for _, attr := range linkAttrPattern.FindAllSubmatch(tag, -1) {
+			if strings.ToLower(string(attr[1])) == "rel" {
+				rel = string(attr[2])
+			} else {
+				href = string(attr[2])
+			}
+		}
+		for _, r := range strings.Fields(rel) {
+			if r == "hub" && hub == "" {
+				hub = href
+			}
+			if r == "self" && self == "" {
+				self = href
+			}
+		}
+	}
+	return hub, self
+}
+
+// isHTTPURL tells whether u is an absolute http or https URL
+func isHTTPURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2018 ceriath
+This Package is part of "captainhook"
+It is licensed under the MIT License
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testTopic = "https://example.com/feed"
+
+// websubHook returns a hook of a new client subscribed to testTopic in the given state
+func websubHook(t *testing.T, s *Server, state string) *Webhook {
+	_, err := s.AddClient("testclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := s.AddHook("testclient", "test", HookOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := time.Now().Add(-time.Hour)
+	expires := time.Now().Add(time.Minute)
+	hook.WebSub = &WebSub{
+		Hub:         "http://127.0.0.1:1/",
+		Topic:       testTopic,
+		Secret:      "secret",
+		State:       state,
+		RequestedAt: verified,
+		VerifiedAt:  &verified,
+		ExpiresAt:   &expires,
+	}
+	return hook
+}
+
+// verifyWebSub sends a verification of intent of the hub to the hook
+func verifyWebSub(s *Server, hook *Webhook, mode string, lease int) (string, error) {
+	query := url.Values{
+		"hub.mode":          {mode},
+		"hub.topic":         {testTopic},
+		"hub.challenge":     {"challenge"},
+		"hub.lease_seconds": {strconv.Itoa(lease)},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/"+hook.UUID+"?"+query.Encode(), nil)
+	req.RemoteAddr = "127.0.0.1:49152"
+	return s.VerifyWebSub(hook.UUID, req)
+}
+
+func TestVerifyWebSub(t *testing.T) {
+	tables := []struct {
+		state    string
+		mode     string
+		accepted bool
+		after    string
+	}{
+		{WebSubPending, "subscribe", true, WebSubSubscribed},
+		{WebSubRenewing, "subscribe", true, WebSubSubscribed},
+		// nothing was requested
+		{WebSubSubscribed, "subscribe", false, WebSubSubscribed},
+		{WebSubExpired, "subscribe", false, WebSubExpired},
+		{WebSubUnsubscribing, "subscribe", false, WebSubUnsubscribing},
+		{WebSubSubscribed, "unsubscribe", false, WebSubSubscribed},
+		{WebSubPending, "denied", true, WebSubDenied},
+		{WebSubRenewing, "denied", true, WebSubDenied},
+		{WebSubSubscribed, "denied", false, WebSubSubscribed},
+	}
+
+	for _, table := range tables {
+		s := testServer(t.TempDir())
+		hook := websubHook(t, s, table.state)
+
+		_, err := verifyWebSub(s, hook, table.mode, 3600)
+		if (err == nil) != table.accepted {
+			t.Errorf("%s while %s: accepted is %t, want %t", table.mode, table.state, err == nil, table.accepted)
+		}
+		if hook.WebSub.State != table.after {
+			t.Errorf("%s while %s: got state %s, want %s", table.mode, table.state, hook.WebSub.State, table.after)
+		}
+		s.Stop()
+	}
+}
+
+func TestWebSubLease(t *testing.T) {
+	tables := []struct {
+		lease int
+		want  time.Duration
+	}{
+		{3600, time.Hour},
+		{maxLeaseSeconds, maxLeaseSeconds * time.Second},
+		{maxLeaseSeconds + 1, maxLeaseSeconds * time.Second},
+		{1 << 62, maxLeaseSeconds * time.Second},
+	}
+
+	for _, table := range tables {
+		s := testServer(t.TempDir())
+		hook := websubHook(t, s, WebSubPending)
+
+		_, err := verifyWebSub(s, hook, "subscribe", table.lease)
+		if err != nil {
+			t.Errorf("Error verifying a lease of %d seconds: %s", table.lease, err.Error())
+		} else if lease := hook.WebSub.ExpiresAt.Sub(*hook.WebSub.VerifiedAt); lease != table.want {
+			t.Errorf("a lease of %d seconds lasts %s, want %s", table.lease, lease, table.want)
+		}
+		s.Stop()
+	}
+}
+
+// TestWebSubRenewal checks that a renewal the hub could not be asked for leaves the subscription subscribed
+func TestWebSubRenewal(t *testing.T) {
+	s := testServer(t.TempDir())
+	defer s.Stop()
+	hook := websubHook(t, s, WebSubSubscribed)
+
+	s.renewWebSubs(time.Now())
+	waitFor(t, "the renewal to fail", func() bool {
+		websubMu.Lock()
+		defer websubMu.Unlock()
+		return hook.WebSub.Error != ""
+	})
+
+	websubMu.Lock()
+	defer websubMu.Unlock()
+	if hook.WebSub.State != WebSubSubscribed {
+		t.Errorf("got state %s after a failed renewal, want %s", hook.WebSub.State, WebSubSubscribed)
+	}
+	if !hook.WebSub.RequestedAt.After(*hook.WebSub.VerifiedAt) {
+		t.Error("the renewal was not recorded")
+	}
+}